@@ -0,0 +1,819 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BoltConfig is the data directory of the embedded bolt backend and how it
+// is backed up and compacted.
+type BoltConfig struct {
+	Dir            string        `yaml:"dir" toml:"dir"`
+	BackupInterval time.Duration `yaml:"backup_interval" toml:"backup_interval"`
+	BackupKeep     int           `yaml:"backup_keep" toml:"backup_keep"`
+	CompactOnStart bool          `yaml:"compact_on_start" toml:"compact_on_start"`
+}
+
+// boltDB is the database of the bolt backend, when it is configured.
+var boltDB *bolt.DB
+
+// boltTxMaxSize bounds the transactions compaction copies in.
+const boltTxMaxSize = 64 << 20
+
+var (
+	boltEmployees  = []byte("employees")
+	boltOutboxKey  = []byte("outbox")
+	boltWebhooks   = []byte("webhooks")
+	boltDeliveries = []byte("webhook_deliveries")
+	// boltDueDeliveries indexes pending deliveries by next attempt.
+	boltDueDeliveries = []byte("webhook_deliveries_due")
+)
+
+// boltIndex is a secondary index of the employees bucket. Its keys are the
+// indexed value followed by the employee id, with empty values; employees
+// with a zero value are not indexed.
+type boltIndex struct {
+	bucket []byte
+	prefix func(employee Employee) []byte
+}
+
+// boltIndexes are tried in order to answer a filtered query, most selective
+// first.
+var boltIndexes = []boltIndex{
+	{[]byte("employees_by_empid"), func(e Employee) []byte {
+		if e.EmpID == 0 {
+			return nil
+		}
+		return binary.BigEndian.AppendUint64(nil, uint64(e.EmpID)^1<<63)
+	}},
+	{[]byte("employees_by_practice"), func(e Employee) []byte { return boltStringPrefix(e.Practice) }},
+	{[]byte("employees_by_lastname"), func(e Employee) []byte { return boltStringPrefix(e.Lastname) }},
+}
+
+// boltStringPrefix ends a string value with a NUL so that one value is not
+// the prefix of another.
+func boltStringPrefix(value string) []byte {
+	if value == "" {
+		return nil
+	}
+	return append([]byte(value), 0)
+}
+
+// boltDueKey orders a pending delivery by its next attempt, then id.
+func boltDueKey(delivery Delivery) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(unixNanos(delivery.NextAttempt))), delivery.ID...)
+}
+
+// boltPath is the database file in the data directory.
+func boltPath(cfg BoltConfig) string {
+	return filepath.Join(cfg.Dir, "employees.db")
+}
+
+// connectBolt opens the database in the data directory, compacting it first
+// when configured, prepares its schema and installs the stores. Backups run
+// until ctx is done.
+func connectBolt(ctx context.Context, cfg BoltConfig, autoMigrate bool) error {
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return err
+	}
+	path := boltPath(cfg)
+	if cfg.CompactOnStart {
+		if err := compactBolt(path); err != nil {
+			return err
+		}
+	}
+	database, err := openBolt(path)
+	if err != nil {
+		return err
+	}
+	m := boltMigrator(database)
+	if err := prepareSchema(ctx, m, autoMigrate); err != nil {
+		database.Close()
+		return err
+	}
+	boltDB = database
+	schema = m
+	s := &boltStore{db: database}
+	store = newTracedStore(instrumentedStore{next: s}, semconv.DBSystemNameKey.String("bolt"), string(boltEmployees))
+	outbox = boltOutbox{db: database}
+	webhookStore = boltWebhookStore{db: database}
+	if cfg.BackupInterval > 0 {
+		go s.backups(ctx, filepath.Join(cfg.Dir, "backups"), cfg.BackupInterval, cfg.BackupKeep)
+	}
+	return nil
+}
+
+// openBolt opens the database at path. The timeout keeps a second process
+// from waiting forever on the file lock.
+func openBolt(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+}
+
+// boltBuckets are the buckets of the store besides the indexes.
+var boltBuckets = [][]byte{boltEmployees, boltOutboxKey, boltWebhooks, boltDeliveries, boltDueDeliveries}
+
+// boltMigrations are the steps of the bolt backend.
+var boltMigrations = []migration[*bolt.Tx]{
+	{1, "create buckets", func(ctx context.Context, tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(ctx context.Context, tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	}},
+	{2, "index employees", func(ctx context.Context, tx *bolt.Tx) error {
+		for _, index := range boltIndexes {
+			if err := rebuildBoltIndex(tx, index); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(ctx context.Context, tx *bolt.Tx) error {
+		for _, index := range boltIndexes {
+			if err := tx.DeleteBucket(index.bucket); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// rebuildBoltIndex fills index from the employees stored.
+func rebuildBoltIndex(tx *bolt.Tx, index boltIndex) error {
+	if err := tx.DeleteBucket(index.bucket); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	b, err := tx.CreateBucket(index.bucket)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltEmployees).ForEach(func(k, v []byte) error {
+		var employee Employee
+		if err := json.Unmarshal(v, &employee); err != nil {
+			return err
+		}
+		if prefix := index.prefix(employee); prefix != nil {
+			return b.Put(append(prefix, k...), nil)
+		}
+		return nil
+	})
+}
+
+// boltMigrationLog records migrations in the "migrations" bucket, keyed by
+// version, in the transaction of the step. The file lock bolt holds keeps
+// other processes out, so there is nothing else to lock.
+type boltMigrationLog struct {
+	db *bolt.DB
+}
+
+var boltMigrationsKey = []byte("migrations")
+
+func boltMigrator(database *bolt.DB) migrator {
+	return migrations[*bolt.Tx]{log: boltMigrationLog{db: database}, steps: boltMigrations}
+}
+
+func (l boltMigrationLog) lock(ctx context.Context) (func(), error) {
+	return func() {}, nil
+}
+
+func (l boltMigrationLog) applied(ctx context.Context) (map[int]bool, error) {
+	applied := make(map[int]bool)
+	err := l.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltMigrationsKey)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			applied[int(binary.BigEndian.Uint64(k))] = true
+			return nil
+		})
+	})
+	return applied, err
+}
+
+func (l boltMigrationLog) apply(ctx context.Context, m migration[*bolt.Tx], up bool) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltMigrationsKey)
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(nil, uint64(m.version))
+		if !up {
+			if err := m.down(ctx, tx); err != nil {
+				return err
+			}
+			return b.Delete(key)
+		}
+		if err := m.up(ctx, tx); err != nil {
+			return err
+		}
+		record, err := json.Marshal(map[string]any{"name": m.name, "applied_at": time.Now()})
+		if err != nil {
+			return err
+		}
+		return b.Put(key, record)
+	})
+}
+
+// compactBolt rewrites the database at path without its free pages. It
+// runs before the database is opened, as bolt only reuses freed space and
+// never returns it to the file system.
+func compactBolt(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	src, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0o600, nil)
+	if err != nil {
+		return err
+	}
+	err = bolt.Compact(dst, src, boltTxMaxSize)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if compacted, err := os.Stat(path); err == nil {
+		logger.Info("compacted the database", "path", path, "before", info.Size(), "after", compacted.Size())
+	}
+	return nil
+}
+
+// boltStore is the EmployeeStore on an embedded bolt database. Employees
+// are JSON documents keyed by their ObjectId, with secondary indexes on
+// empid, practice and lastname kept in the same transactions. Each write
+// also records its event in the outbox bucket, and commits are synced to
+// disk before they return, so a crash loses neither a write nor its event.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func getBoltEmployee(tx *bolt.Tx, id bson.ObjectId) (Employee, error) {
+	var employee Employee
+	data := tx.Bucket(boltEmployees).Get([]byte(id))
+	if data == nil {
+		return employee, ErrNotFound
+	}
+	return employee, json.Unmarshal(data, &employee)
+}
+
+func putBoltEmployee(tx *bolt.Tx, employee Employee) error {
+	data, err := json.Marshal(employee)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltEmployees).Put([]byte(employee.ID), data); err != nil {
+		return err
+	}
+	for _, index := range boltIndexes {
+		if prefix := index.prefix(employee); prefix != nil {
+			if err := tx.Bucket(index.bucket).Put(append(prefix, employee.ID...), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func deleteBoltEmployee(tx *bolt.Tx, employee Employee) error {
+	for _, index := range boltIndexes {
+		if prefix := index.prefix(employee); prefix != nil {
+			if err := tx.Bucket(index.bucket).Delete(append(prefix, employee.ID...)); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Bucket(boltEmployees).Delete([]byte(employee.ID))
+}
+
+// write runs apply, which returns the employee for the event, in a
+// transaction together with the outbox insert.
+func (s *boltStore) write(eventType string, apply func(tx *bolt.Tx) (Employee, error)) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		employee, err := apply(tx)
+		if err != nil {
+			return err
+		}
+		entry := OutboxEntry{ID: bson.NewObjectId(), Type: eventType, Employee: employee, Time: time.Now()}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltOutboxKey).Put([]byte(entry.ID), data)
+	})
+	if err == nil {
+		notifyRelay()
+	}
+	return err
+}
+
+func (s *boltStore) Insert(ctx context.Context, employee *Employee) error {
+	if employee.ID == "" {
+		employee.ID = bson.NewObjectId()
+	}
+	return s.write(EventEmployeeCreated, func(tx *bolt.Tx) (Employee, error) {
+		if tx.Bucket(boltEmployees).Get([]byte(employee.ID)) != nil {
+			return *employee, errEmployeeExists
+		}
+		return *employee, putBoltEmployee(tx, *employee)
+	})
+}
+
+func (s *boltStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	var employee Employee
+	oid, err := objectID(id)
+	if err != nil {
+		return employee, err
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		employee, err = getBoltEmployee(tx, oid)
+		return err
+	})
+	return employee, err
+}
+
+// Find reads the employees matching the query through the first index that
+// applies, or the whole bucket, in id order.
+func (s *boltStore) Find(ctx context.Context, query EmployeeQuery) ([]Employee, error) {
+	probe := Employee{EmpID: query.EmpID, Practice: query.Practice, Lastname: query.Lastname}
+	var matched []Employee
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, index := range boltIndexes {
+			prefix := index.prefix(probe)
+			if prefix == nil {
+				continue
+			}
+			c := tx.Bucket(index.bucket).Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				id := k[len(prefix):]
+				if len(id) != 12 {
+					continue
+				}
+				employee, err := getBoltEmployee(tx, bson.ObjectId(id))
+				if err != nil {
+					return err
+				}
+				if query.matches(employee) {
+					matched = append(matched, employee)
+				}
+			}
+			return nil
+		}
+		return tx.Bucket(boltEmployees).ForEach(func(k, v []byte) error {
+			var employee Employee
+			if err := json.Unmarshal(v, &employee); err != nil {
+				return err
+			}
+			if query.matches(employee) {
+				matched = append(matched, employee)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pageEmployees(matched, query), ctx.Err()
+}
+
+// Iter reads the results up front, so that no read transaction stays open
+// while the caller is slow: bolt cannot grow the file under one.
+func (s *boltStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	employees, err := s.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &sliceIter{employees: employees}, nil
+}
+
+func (s *boltStore) Update(ctx context.Context, id string, employee Employee) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	return s.write(EventEmployeeUpdated, func(tx *bolt.Tx) (Employee, error) {
+		updated, err := getBoltEmployee(tx, oid)
+		if err != nil {
+			return updated, err
+		}
+		if err := deleteBoltEmployee(tx, updated); err != nil {
+			return updated, err
+		}
+		mergeEmployee(&updated, employee)
+		return updated, putBoltEmployee(tx, updated)
+	})
+}
+
+func (s *boltStore) Remove(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	return s.write(EventEmployeeDeleted, func(tx *bolt.Tx) (Employee, error) {
+		removed, err := getBoltEmployee(tx, oid)
+		if err != nil {
+			return removed, err
+		}
+		return removed, deleteBoltEmployee(tx, removed)
+	})
+}
+
+func (s *boltStore) CountByPractice(ctx context.Context) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEmployees).ForEach(func(k, v []byte) error {
+			var employee Employee
+			if err := json.Unmarshal(v, &employee); err != nil {
+				return err
+			}
+			counts[employee.Practice]++
+			return nil
+		})
+	})
+	return counts, err
+}
+
+// Ping fails once the database is closed.
+func (s *boltStore) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// backups writes a backup every interval until ctx is done.
+func (s *boltStore) backups(ctx context.Context, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if path, err := s.backup(dir, keep); err != nil {
+			logger.Error("backing up the database", "err", err)
+		} else {
+			logger.Info("backed up the database", "path", path)
+		}
+	}
+}
+
+// backup writes a consistent copy of the database to a new file in dir
+// from a read transaction, so writes go on meanwhile, then removes all but
+// the newest keep backups. It returns the path of the copy.
+func (s *boltStore) backup(dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "employees-"+time.Now().UTC().Format("20060102-150405.000")+".db")
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(f)
+		return err
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	backups, err := filepath.Glob(filepath.Join(dir, "employees-*.db"))
+	if err != nil {
+		return path, err
+	}
+	sort.Strings(backups)
+	for _, old := range backups[:max(len(backups)-keep, 0)] {
+		os.Remove(old)
+	}
+	return path, nil
+}
+
+// boltOutbox is the outbox bucket the boltStore writes in its transactions,
+// keyed by entry id. Relayed entries only stay for outboxRetention, so the
+// bucket is small enough to scan.
+type boltOutbox struct {
+	db *bolt.DB
+}
+
+// scan calls f with each entry by id until f returns false.
+func (o boltOutbox) scan(tx *bolt.Tx, f func(entry OutboxEntry) bool) error {
+	c := tx.Bucket(boltOutboxKey).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var entry OutboxEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		if !f(entry) {
+			break
+		}
+	}
+	return nil
+}
+
+func (o boltOutbox) Pending(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return o.scan(tx, func(entry OutboxEntry) bool {
+			if !entry.Relayed {
+				entries = append(entries, entry)
+			}
+			return len(entries) < limit
+		})
+	})
+	return entries, err
+}
+
+func (o boltOutbox) Ack(ctx context.Context, ids []bson.ObjectId) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			var entry OutboxEntry
+			switch err := getBoltDoc(tx, boltOutboxKey, id, &entry); err {
+			case nil:
+			case ErrNotFound:
+				continue
+			default:
+				return err
+			}
+			entry.Relayed = true
+			if err := putBoltDoc(tx, boltOutboxKey, id, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (o boltOutbox) Since(ctx context.Context, t time.Time, id bson.ObjectId, limit int) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return o.scan(tx, func(entry OutboxEntry) bool {
+			if entry.Time.After(t) || entry.Time.Equal(t) && entry.ID > id {
+				entries = append(entries, entry)
+			}
+			return true
+		})
+	})
+	slices.SortFunc(entries, func(a, b OutboxEntry) int {
+		return cmp.Or(a.Time.Compare(b.Time), strings.Compare(string(a.ID), string(b.ID)))
+	})
+	return entries[:min(limit, len(entries))], err
+}
+
+func (o boltOutbox) Purge(ctx context.Context, t time.Time) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		var expired []bson.ObjectId
+		err := o.scan(tx, func(entry OutboxEntry) bool {
+			if entry.Relayed && entry.Time.Before(t) {
+				expired = append(expired, entry.ID)
+			}
+			return true
+		})
+		for _, id := range expired {
+			if err == nil {
+				err = tx.Bucket(boltOutboxKey).Delete([]byte(id))
+			}
+		}
+		return err
+	})
+}
+
+// Lease always holds: bolt locks its file, so one process at a time has
+// the outbox.
+func (o boltOutbox) Lease(ctx context.Context, owner string, now time.Time) (bool, error) {
+	return true, nil
+}
+
+// boltWebhookStore keeps subscriptions and deliveries as JSON documents
+// keyed by id, with pending deliveries indexed by their next attempt.
+type boltWebhookStore struct {
+	db *bolt.DB
+}
+
+// getBoltDoc decodes the document with id in bucket into doc.
+func getBoltDoc(tx *bolt.Tx, bucket []byte, id bson.ObjectId, doc any) error {
+	data := tx.Bucket(bucket).Get([]byte(id))
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, doc)
+}
+
+func putBoltDoc(tx *bolt.Tx, bucket []byte, id bson.ObjectId, doc any) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put([]byte(id), data)
+}
+
+func (s boltWebhookStore) InsertSubscription(ctx context.Context, sub *Subscription) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltDoc(tx, boltWebhooks, sub.ID, sub)
+	})
+}
+
+func (s boltWebhookStore) FindSubscription(ctx context.Context, id string) (Subscription, error) {
+	var sub Subscription
+	oid, err := objectID(id)
+	if err != nil {
+		return sub, err
+	}
+	return sub, s.db.View(func(tx *bolt.Tx) error {
+		return getBoltDoc(tx, boltWebhooks, oid, &sub)
+	})
+}
+
+func (s boltWebhookStore) FindSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subs := []Subscription{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltWebhooks).ForEach(func(k, v []byte) error {
+			var sub Subscription
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return err
+			}
+			subs = append(subs, sub)
+			return nil
+		})
+	})
+	return subs, err
+}
+
+func (s boltWebhookStore) UpdateSubscription(ctx context.Context, sub Subscription) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltWebhooks).Get([]byte(sub.ID)) == nil {
+			return ErrNotFound
+		}
+		return putBoltDoc(tx, boltWebhooks, sub.ID, sub)
+	})
+}
+
+func (s boltWebhookStore) RemoveSubscription(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltWebhooks)
+		if b.Get([]byte(oid)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(oid))
+	})
+}
+
+// putBoltDelivery stores delivery and keeps the due index in step with it.
+func putBoltDelivery(tx *bolt.Tx, delivery Delivery) error {
+	var old Delivery
+	switch err := getBoltDoc(tx, boltDeliveries, delivery.ID, &old); err {
+	case nil:
+		if err := tx.Bucket(boltDueDeliveries).Delete(boltDueKey(old)); err != nil {
+			return err
+		}
+	case ErrNotFound:
+	default:
+		return err
+	}
+	if delivery.Status == deliveryPending {
+		if err := tx.Bucket(boltDueDeliveries).Put(boltDueKey(delivery), nil); err != nil {
+			return err
+		}
+	}
+	return putBoltDoc(tx, boltDeliveries, delivery.ID, delivery)
+}
+
+func (s boltWebhookStore) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltDeliveries).Get([]byte(delivery.ID)) != nil {
+			return nil
+		}
+		return putBoltDelivery(tx, *delivery)
+	})
+}
+
+func (s boltWebhookStore) FindDelivery(ctx context.Context, id string) (Delivery, error) {
+	var delivery Delivery
+	oid, err := objectID(id)
+	if err != nil {
+		return delivery, err
+	}
+	return delivery, s.db.View(func(tx *bolt.Tx) error {
+		return getBoltDoc(tx, boltDeliveries, oid, &delivery)
+	})
+}
+
+func (s boltWebhookStore) FindDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	var subscriptionID bson.ObjectId
+	if query.SubscriptionID != "" {
+		oid, err := objectID(query.SubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		subscriptionID = oid
+	}
+	deliveries := []Delivery{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltDeliveries).Cursor()
+		for k, v := c.Last(); k != nil && (query.Limit <= 0 || len(deliveries) < query.Limit); k, v = c.Prev() {
+			var delivery Delivery
+			if err := json.Unmarshal(v, &delivery); err != nil {
+				return err
+			}
+			if (subscriptionID == "" || delivery.SubscriptionID == subscriptionID) &&
+				(query.Status == "" || delivery.Status == query.Status) {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+func (s boltWebhookStore) DueDeliveries(ctx context.Context, now time.Time, exclude []bson.ObjectId, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltDueDeliveries).Cursor()
+		for k, _ := c.First(); k != nil && len(deliveries) < limit; k, _ = c.Next() {
+			if int64(binary.BigEndian.Uint64(k)) > now.UnixNano() {
+				break
+			}
+			var delivery Delivery
+			if err := getBoltDoc(tx, boltDeliveries, bson.ObjectId(k[8:]), &delivery); err != nil {
+				return err
+			}
+			if !slices.Contains(exclude, delivery.SubscriptionID) {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+func (s boltWebhookStore) ClaimDelivery(ctx context.Context, id bson.ObjectId, now, until time.Time) (Delivery, error) {
+	var delivery Delivery
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := getBoltDoc(tx, boltDeliveries, id, &delivery); err != nil {
+			return err
+		}
+		if delivery.Status != deliveryPending || delivery.NextAttempt.After(now) {
+			return ErrNotFound
+		}
+		delivery.NextAttempt = until
+		return putBoltDelivery(tx, delivery)
+	})
+	return delivery, err
+}
+
+func (s boltWebhookStore) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltDeliveries).Get([]byte(delivery.ID)) == nil {
+			return ErrNotFound
+		}
+		return putBoltDelivery(tx, delivery)
+	})
+}
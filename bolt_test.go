@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// openBoltTest returns a boltStore on a new, migrated database in a
+// temporary directory, and the database's path.
+func openBoltTest(t *testing.T) (*boltStore, string) {
+	path := filepath.Join(t.TempDir(), "employees.db")
+	database, err := openBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	m := boltMigrator(database)
+	if err := m.migrate(context.Background(), m.latest()); err != nil {
+		t.Fatal(err)
+	}
+	return &boltStore{db: database}, path
+}
+
+func TestBoltStore(t *testing.T) {
+	s, _ := openBoltTest(t)
+	testEmployeeStore(t, s)
+}
+
+func TestBoltIndexes(t *testing.T) {
+	s, path := openBoltTest(t)
+	t.Cleanup(func() { s.db.Close() })
+	ctx := context.Background()
+	ravi := Employee{Firstname: "ravi", Lastname: "kumar", EmpID: -3, Practice: "SAP"}
+	assert.NoError(t, s.Insert(ctx, &ravi))
+
+	t.Run("updates move index entries", func(t *testing.T) {
+		assert.NoError(t, s.Update(ctx, ravi.ID.Hex(), Employee{Practice: "IBM"}))
+		got, err := s.Find(ctx, EmployeeQuery{Practice: "SAP"})
+		assert.NoError(t, err)
+		assert.Empty(t, got)
+		got, err = s.Find(ctx, EmployeeQuery{Practice: "IBM", EmpID: -3})
+		assert.NoError(t, err)
+		assert.Len(t, got, 1)
+	})
+
+	t.Run("the index migration backfills", func(t *testing.T) {
+		m := boltMigrator(s.db)
+		assert.NoError(t, m.migrate(ctx, 1))
+		assert.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+			assert.Nil(t, tx.Bucket([]byte("employees_by_lastname")))
+			return nil
+		}))
+		s.db.Close()
+		database, err := openBolt(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.db = database
+		assert.NoError(t, prepareSchema(ctx, boltMigrator(database), true))
+		got, err := s.Find(ctx, EmployeeQuery{Lastname: "kumar"})
+		assert.NoError(t, err)
+		assert.Len(t, got, 1)
+	})
+
+	t.Run("removes drop index entries", func(t *testing.T) {
+		assert.NoError(t, s.Remove(ctx, ravi.ID.Hex()))
+		assert.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+			for _, index := range boltIndexes {
+				assert.Zero(t, tx.Bucket(index.bucket).Stats().KeyN, string(index.bucket))
+			}
+			return nil
+		}))
+	})
+}
+
+func TestBoltDataMigration(t *testing.T) {
+	s, _ := openBoltTest(t)
+	ctx := context.Background()
+	ravi := Employee{Firstname: "ravi", Salary: 100}
+	assert.NoError(t, s.Insert(ctx, &ravi))
+
+	scale := func(factor float64) func(ctx context.Context, tx *bolt.Tx) error {
+		return func(ctx context.Context, tx *bolt.Tx) error {
+			b := tx.Bucket(boltEmployees)
+			return b.ForEach(func(k, v []byte) error {
+				var employee Employee
+				if err := json.Unmarshal(v, &employee); err != nil {
+					return err
+				}
+				employee.Salary *= factor
+				v, err := json.Marshal(employee)
+				if err != nil {
+					return err
+				}
+				return b.Put(k, v)
+			})
+		}
+	}
+	m := migrations[*bolt.Tx]{log: boltMigrationLog{db: s.db}, steps: append(boltMigrations[:len(boltMigrations):len(boltMigrations)],
+		migration[*bolt.Tx]{100, "double salaries", scale(2), scale(0.5)},
+	)}
+	assert.NoError(t, m.migrate(ctx, 100))
+	got, _ := s.FindByID(ctx, ravi.ID.Hex())
+	assert.Equal(t, 200.0, got.Salary)
+
+	assert.NoError(t, m.migrate(ctx, 2))
+	got, _ = s.FindByID(ctx, ravi.ID.Hex())
+	assert.Equal(t, 100.0, got.Salary, "down reverts the backfill")
+}
+
+func TestBoltOutbox(t *testing.T) {
+	s, _ := openBoltTest(t)
+	o := boltOutbox{db: s.db}
+	ctx := context.Background()
+
+	employee := Employee{Firstname: "ravi"}
+	assert.NoError(t, s.Insert(ctx, &employee))
+	assert.ErrorIs(t, s.Insert(ctx, &employee), errEmployeeExists)
+	assert.NoError(t, s.Remove(ctx, employee.ID.Hex()))
+
+	entries, err := o.Pending(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2, "failed writes record nothing") {
+		assert.Equal(t, EventEmployeeCreated, entries[0].Type)
+		assert.Equal(t, EventEmployeeDeleted, entries[1].Type)
+		assert.Equal(t, employee, entries[1].Employee)
+	}
+	assert.NoError(t, o.Ack(ctx, []bson.ObjectId{entries[0].ID, entries[1].ID}))
+	entries, err = o.Pending(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	entries, err = o.Since(ctx, time.Now().Add(-time.Minute), "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2, "relayed entries stay for the other instances") {
+		assert.True(t, entries[0].Relayed)
+		after, err := o.Since(ctx, entries[0].Time, entries[0].ID, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, entries[1:], after)
+	}
+	assert.NoError(t, o.Purge(ctx, time.Now().Add(time.Second)))
+	entries, err = o.Since(ctx, time.Unix(0, 0), "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBoltWebhookStore(t *testing.T) {
+	s, _ := openBoltTest(t)
+	w := boltWebhookStore{db: s.db}
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	sub := Subscription{ID: bson.NewObjectId(), URL: "https://hooks.example.com", Active: true, CreatedAt: now}
+	assert.NoError(t, w.InsertSubscription(ctx, &sub))
+	due := Delivery{ID: bson.NewObjectId(), SubscriptionID: sub.ID, Payload: []byte(`{}`), Status: deliveryPending, NextAttempt: now.Add(-time.Second)}
+	later := due
+	later.ID, later.NextAttempt = bson.NewObjectId(), now.Add(time.Hour)
+	assert.NoError(t, w.InsertDelivery(ctx, &later))
+	assert.NoError(t, w.InsertDelivery(ctx, &due))
+	again := due
+	again.Status = deliveryDead
+	assert.NoError(t, w.InsertDelivery(ctx, &again), "inserting again is not an error")
+
+	deliveries, err := w.DueDeliveries(ctx, now, nil, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Delivery{due}, deliveries, "and changes nothing")
+	deliveries, err = w.DueDeliveries(ctx, now, []bson.ObjectId{sub.ID}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries, "excluded subscriptions are left out")
+
+	claimed, err := w.ClaimDelivery(ctx, due.ID, now, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute), claimed.NextAttempt)
+	_, err = w.ClaimDelivery(ctx, due.ID, now, now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrNotFound, "claimed deliveries are not due")
+	_, err = w.ClaimDelivery(ctx, later.ID, now, now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	due.Status = deliveryDelivered
+	assert.NoError(t, w.UpdateDelivery(ctx, due))
+	later.NextAttempt = now.Add(-time.Minute)
+	assert.NoError(t, w.UpdateDelivery(ctx, later))
+	deliveries, err = w.DueDeliveries(ctx, now, nil, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Delivery{later}, deliveries, "the due index follows updates")
+
+	deliveries, err = w.FindDeliveries(ctx, DeliveryQuery{Status: deliveryDelivered})
+	assert.NoError(t, err)
+	assert.Equal(t, []Delivery{due}, deliveries)
+	assert.ErrorIs(t, w.RemoveSubscription(ctx, bson.NewObjectId().Hex()), ErrNotFound)
+}
+
+func TestBoltBackup(t *testing.T) {
+	s, _ := openBoltTest(t)
+	ctx := context.Background()
+	employee := Employee{Firstname: "ravi"}
+	assert.NoError(t, s.Insert(ctx, &employee))
+	dir := filepath.Join(t.TempDir(), "backups")
+
+	var path string
+	for range 3 {
+		var err error
+		path, err = s.backup(dir, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Len(t, backups, 2, "older backups are removed")
+
+	database, err := openBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	got, err := (&boltStore{db: database}).FindByID(ctx, employee.ID.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, employee, got)
+}
+
+func TestCompactBolt(t *testing.T) {
+	s, path := openBoltTest(t)
+	ctx := context.Background()
+	var kept Employee
+	for i := range 500 {
+		employee := Employee{Firstname: "ravi", EmpID: i + 1, Practice: "SAP"}
+		assert.NoError(t, s.Insert(ctx, &employee))
+		if i > 0 {
+			assert.NoError(t, s.Remove(ctx, employee.ID.Hex()))
+		} else {
+			kept = employee
+		}
+	}
+	entries, err := boltOutbox{db: s.db}.Pending(ctx, 1000)
+	assert.NoError(t, err)
+	ids := make([]bson.ObjectId, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	assert.NoError(t, boltOutbox{db: s.db}.Ack(ctx, ids))
+	assert.NoError(t, boltOutbox{db: s.db}.Purge(ctx, time.Now().Add(time.Second)))
+	s.db.Close()
+	before, _ := os.Stat(path)
+
+	assert.NoError(t, compactBolt(path))
+	after, _ := os.Stat(path)
+	assert.Less(t, after.Size(), before.Size())
+
+	database, err := openBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	s.db = database
+	got, err := s.Find(ctx, EmployeeQuery{Practice: "SAP"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Employee{kept}, got)
+	assert.NoError(t, compactBolt(filepath.Join(t.TempDir(), "missing.db")), "nothing to compact")
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheConfig sizes the read-through cache of employee lookups by id. Size
+// is the most employees the in-process cache keeps, 0 disabling it; a not
+// found id is remembered for NegativeTTL, 0 disabling negative caching.
+type CacheConfig struct {
+	Size        int           `yaml:"size" toml:"size"`
+	TTL         time.Duration `yaml:"ttl" toml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl" toml:"negative_ttl"`
+}
+
+func (c CacheConfig) validate() error {
+	var errs []error
+	if c.Size < 0 {
+		errs = append(errs, errors.New("cache.size: must not be negative"))
+	}
+	if c.Size > 0 && (c.TTL <= 0 || c.NegativeTTL < 0) {
+		errs = append(errs, errors.New("cache: ttl must be positive and negative_ttl must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// EmployeeCache holds encoded employees by id until their TTL runs out.
+// Implementations backed by a shared store (redis, memcached...) can be
+// plugged in so that several API instances share entries and see each
+// other's invalidations.
+type EmployeeCache interface {
+	// Get reports false when key is missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// employeeCache replaces the in-process cache when set.
+var employeeCache EmployeeCache
+
+// cacheEventBuffer is how many events the cache lets pile up before the bus
+// drops its subscription.
+const cacheEventBuffer = 1024
+
+// lruCache is an in-process EmployeeCache of at most size entries, evicting
+// the least recently used first. Expired entries are dropped when read.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // of *lruEntry, most recently used first
+	now     func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+func (c *lruCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(element)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *lruCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+func (c *lruCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+// remove drops element; c.mu must be held.
+func (c *lruCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*lruEntry).key)
+	c.order.Remove(element)
+}
+
+// cachedStore serves FindByID from cache, loading misses from next once
+// however many requests ask for the same id at a time. Writes through the
+// store and events on the bus, which include writes made outside the API
+// when watching is on, drop the entry of the employee. Lists are not cached.
+type cachedStore struct {
+	next        EmployeeStore
+	cache       EmployeeCache
+	ttl         time.Duration
+	negativeTTL time.Duration
+	loads       singleflight.Group
+	// generation counts invalidations, so that a load that raced with a
+	// write does not cache what it read before the write.
+	generation atomic.Uint64
+}
+
+func newCachedStore(next EmployeeStore, cache EmployeeCache, cfg CacheConfig) *cachedStore {
+	return &cachedStore{next: next, cache: cache, ttl: cfg.TTL, negativeTTL: cfg.NegativeTTL}
+}
+
+// cacheStore puts the configured cache in front of store and keeps it in
+// step with the events on bus until ctx is done.
+func cacheStore(ctx context.Context, cfg CacheConfig, bus *eventBus) {
+	cache := employeeCache
+	if cache == nil {
+		if cfg.Size == 0 {
+			return
+		}
+		cache = newLRUCache(cfg.Size)
+	}
+	s := newCachedStore(store, cache, cfg)
+	store = s
+	go s.follow(ctx, bus)
+}
+
+// follow invalidates the employee of every event on bus until ctx is done.
+// Events missed while resubscribing are only bounded by the TTL.
+func (s *cachedStore) follow(ctx context.Context, bus *eventBus) {
+	for {
+		ch, unsubscribe := bus.Subscribe(cacheEventBuffer)
+		for open := true; open; {
+			select {
+			case <-ctx.Done():
+				unsubscribe()
+				return
+			case event, ok := <-ch:
+				if ok {
+					s.invalidate(ctx, event.Employee.ID.Hex())
+				}
+				open = ok
+			}
+		}
+		logger.Warn("resubscribing the employee cache to events")
+	}
+}
+
+func (s *cachedStore) invalidate(ctx context.Context, id string) {
+	s.generation.Add(1)
+	s.loads.Forget(id)
+	if err := s.cache.Delete(ctx, id); err != nil {
+		logger.Warn("invalidating a cached employee", "id", id, "err", err)
+	}
+}
+
+// lookup returns the cached result for id, reporting false on a miss. An
+// empty value records that the employee does not exist.
+func (s *cachedStore) lookup(ctx context.Context, id string) (Employee, bool, error) {
+	var employee Employee
+	value, ok, err := s.cache.Get(ctx, id)
+	if err == nil && ok && len(value) > 0 {
+		err = json.Unmarshal(value, &employee)
+	}
+	switch {
+	case err != nil:
+		cacheLookups.WithLabelValues("error").Inc()
+		logger.Warn("reading the employee cache", "id", id, "err", err)
+		return employee, false, nil
+	case !ok:
+		cacheLookups.WithLabelValues("miss").Inc()
+		return employee, false, nil
+	case len(value) == 0:
+		cacheLookups.WithLabelValues("negative_hit").Inc()
+		return employee, true, ErrNotFound
+	}
+	cacheLookups.WithLabelValues("hit").Inc()
+	return employee, true, nil
+}
+
+// load reads id from next and caches the result, unless an invalidation
+// happened meanwhile.
+func (s *cachedStore) load(ctx context.Context, id string) (Employee, error) {
+	cacheLoads.Inc()
+	generation := s.generation.Load()
+	employee, err := s.next.FindByID(ctx, id)
+	if err != nil && err != ErrNotFound {
+		return employee, err
+	}
+	var value []byte
+	ttl := s.negativeTTL
+	if err == nil {
+		value, _ = json.Marshal(employee)
+		ttl = s.ttl
+	}
+	if ttl > 0 && generation == s.generation.Load() {
+		if err := s.cache.Set(ctx, id, value, ttl); err != nil {
+			logger.Warn("writing the employee cache", "id", id, "err", err)
+		}
+	}
+	return employee, err
+}
+
+// FindByID waits for a load started by another request rather than start
+// its own. The load outlives a caller that gives up, for the others.
+func (s *cachedStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	if employee, ok, err := s.lookup(ctx, id); ok {
+		return employee, err
+	}
+	loaded := s.loads.DoChan(id, func() (any, error) {
+		return s.load(context.WithoutCancel(ctx), id)
+	})
+	select {
+	case <-ctx.Done():
+		return Employee{}, ctx.Err()
+	case result := <-loaded:
+		return result.Val.(Employee), result.Err
+	}
+}
+
+func (s *cachedStore) Insert(ctx context.Context, employee *Employee) error {
+	err := s.next.Insert(ctx, employee)
+	if err == nil {
+		s.invalidate(ctx, employee.ID.Hex())
+	}
+	return err
+}
+
+func (s *cachedStore) Find(ctx context.Context, query EmployeeQuery) ([]Employee, error) {
+	return s.next.Find(ctx, query)
+}
+
+func (s *cachedStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	return s.next.Iter(ctx, query)
+}
+
+// Update and Remove invalidate even when they fail, as the write may have
+// happened before the error.
+func (s *cachedStore) Update(ctx context.Context, id string, employee Employee) error {
+	defer s.invalidate(ctx, id)
+	return s.next.Update(ctx, id, employee)
+}
+
+func (s *cachedStore) Remove(ctx context.Context, id string) error {
+	defer s.invalidate(ctx, id)
+	return s.next.Remove(ctx, id)
+}
+
+func (s *cachedStore) CountByPractice(ctx context.Context) (map[string]int, error) {
+	return s.next.CountByPractice(ctx)
+}
+
+func (s *cachedStore) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// countingStore counts the FindByID calls reaching the store and, when
+// release is set, holds each of them until it is closed.
+type countingStore struct {
+	EmployeeStore
+	reads   atomic.Int32
+	release chan struct{}
+}
+
+func (s *countingStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	s.reads.Add(1)
+	if s.release != nil {
+		<-s.release
+	}
+	return s.EmployeeStore.FindByID(ctx, id)
+}
+
+func newCachedTestStore(t *testing.T) (*cachedStore, *countingStore) {
+	next := &countingStore{EmployeeStore: &memoryStore{}}
+	return newCachedStore(next, newLRUCache(10), defaultConfig().Cache), next
+}
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := newLRUCache(2)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+	_, ok, _ := c.Get(ctx, "b")
+	assert.False(t, ok, "the least recently used entry is evicted")
+	value, ok, _ := c.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+
+	now = now.Add(time.Minute)
+	_, ok, _ = c.Get(ctx, "c")
+	assert.False(t, ok, "expired")
+	assert.Len(t, c.entries, 1)
+
+	c.Delete(ctx, "a")
+	assert.Zero(t, c.order.Len())
+}
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	s, next := newCachedTestStore(t)
+	ravi := Employee{Firstname: "ravi", Practice: "SAP"}
+	assert.NoError(t, s.Insert(ctx, &ravi))
+	id := ravi.ID.Hex()
+
+	t.Run("it reads through", func(t *testing.T) {
+		hits := testutil.ToFloat64(cacheLookups.WithLabelValues("hit"))
+		for range 3 {
+			got, err := s.FindByID(ctx, id)
+			assert.NoError(t, err)
+			assert.Equal(t, ravi, got)
+		}
+		assert.Equal(t, int32(1), next.reads.Load())
+		assert.Equal(t, hits+2, testutil.ToFloat64(cacheLookups.WithLabelValues("hit")))
+	})
+
+	t.Run("updates invalidate", func(t *testing.T) {
+		assert.NoError(t, s.Update(ctx, id, Employee{Practice: "IBM"}))
+		got, err := s.FindByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, "IBM", got.Practice)
+	})
+
+	t.Run("removes invalidate and misses are cached", func(t *testing.T) {
+		assert.NoError(t, s.Remove(ctx, id))
+		reads := next.reads.Load()
+		negative := testutil.ToFloat64(cacheLookups.WithLabelValues("negative_hit"))
+		for range 2 {
+			_, err := s.FindByID(ctx, id)
+			assert.ErrorIs(t, err, ErrNotFound)
+		}
+		assert.Equal(t, reads+1, next.reads.Load())
+		assert.Equal(t, negative+1, testutil.ToFloat64(cacheLookups.WithLabelValues("negative_hit")))
+	})
+
+	t.Run("inserts clear a cached miss", func(t *testing.T) {
+		assert.NoError(t, s.Insert(ctx, &ravi))
+		got, err := s.FindByID(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, ravi, got)
+	})
+
+	t.Run("bus events invalidate", func(t *testing.T) {
+		bus := newEventBus()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.follow(ctx, bus)
+		assert.Eventually(t, func() bool {
+			bus.mu.Lock()
+			defer bus.mu.Unlock()
+			return len(bus.subscribers) == 1
+		}, time.Second, time.Millisecond)
+
+		changed := ravi
+		changed.Salary = 40
+		next.EmployeeStore.Update(ctx, id, changed)
+		bus.Publish(EmployeeEvent{Type: EventEmployeeUpdated, Employee: changed})
+		assert.Eventually(t, func() bool {
+			got, _ := s.FindByID(ctx, id)
+			return got.Salary == 40
+		}, time.Second, time.Millisecond)
+	})
+}
+
+func TestCachedStoreLoads(t *testing.T) {
+	ctx := context.Background()
+	s, next := newCachedTestStore(t)
+	ravi := Employee{ID: bson.NewObjectId(), Firstname: "ravi"}
+	next.Insert(ctx, &ravi)
+	next.release = make(chan struct{})
+
+	t.Run("concurrent misses share one read", func(t *testing.T) {
+		loads := testutil.ToFloat64(cacheLoads)
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := s.FindByID(ctx, ravi.ID.Hex())
+				assert.NoError(t, err)
+				assert.Equal(t, ravi, got)
+			}()
+		}
+		assert.Eventually(t, func() bool { return next.reads.Load() == 1 }, time.Second, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		close(next.release)
+		wg.Wait()
+		assert.Equal(t, int32(1), next.reads.Load())
+		assert.Equal(t, loads+1, testutil.ToFloat64(cacheLoads))
+	})
+
+	t.Run("a caller may give up on a load", func(t *testing.T) {
+		s.invalidate(ctx, ravi.ID.Hex())
+		next.release = make(chan struct{})
+		defer close(next.release)
+		waiting, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := s.FindByID(waiting, ravi.ID.Hex())
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("a load racing a write is not cached", func(t *testing.T) {
+		s, next := newCachedTestStore(t)
+		next.release = make(chan struct{})
+		id := bson.NewObjectId().Hex()
+		done := make(chan error)
+		go func() {
+			_, err := s.FindByID(ctx, id)
+			done <- err
+		}()
+		assert.Eventually(t, func() bool { return next.reads.Load() == 1 }, time.Second, time.Millisecond)
+		s.invalidate(ctx, id)
+		close(next.release)
+		assert.ErrorIs(t, <-done, ErrNotFound)
+		_, ok, _ := s.cache.Get(ctx, id)
+		assert.False(t, ok)
+	})
+}
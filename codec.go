@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Codec encodes and decodes request and response bodies of one media type.
+type Codec struct {
+	MediaType string
+	Aliases   []string
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+// jsonCodec marshals through mockMarshal so tests can make encoding fail.
+var jsonCodec = Codec{
+	MediaType: "application/json",
+	Marshal:   func(v interface{}) ([]byte, error) { return mockMarshal(v) },
+	Unmarshal: json.Unmarshal,
+}
+
+var xmlCodec = Codec{
+	MediaType: "application/xml",
+	Aliases:   []string{"text/xml"},
+	Marshal:   xml.Marshal,
+	Unmarshal: xml.Unmarshal,
+}
+
+// codecs lists the supported media types; the first one is the default.
+var codecs = []Codec{jsonCodec, xmlCodec}
+
+var (
+	errNotAcceptable        = errors.New("none of the accepted media types can be produced")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// registerCodec makes a media type available to content negotiation.
+func registerCodec(c Codec) {
+	codecs = append(codecs, c)
+}
+
+func codecFor(mediaType string) (Codec, bool) {
+	for _, c := range codecs {
+		if c.MediaType == mediaType {
+			return c, true
+		}
+		for _, alias := range c.Aliases {
+			if alias == mediaType {
+				return c, true
+			}
+		}
+	}
+	return Codec{}, false
+}
+
+// acceptedCodec picks the response codec from the Accept header, preferring
+// higher q-values and, among equals, the order of codecs.
+func acceptedCodec(request *http.Request) (Codec, bool) {
+	accept := request.Header.Get("Accept")
+	if accept == "" {
+		return codecs[0], true
+	}
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		if r.mediaType == "*/*" {
+			return codecs[0], true
+		}
+		if strings.HasSuffix(r.mediaType, "/*") {
+			prefix := strings.TrimSuffix(r.mediaType, "*")
+			for _, c := range codecs {
+				if strings.HasPrefix(c.MediaType, prefix) {
+					return c, true
+				}
+			}
+			continue
+		}
+		if c, ok := codecFor(r.mediaType); ok {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// contentCodec picks the request codec from the Content-Type header. Bodies
+// without one are read as the default media type.
+func contentCodec(request *http.Request) (Codec, bool) {
+	contentType := request.Header.Get("Content-Type")
+	if contentType == "" {
+		return codecs[0], true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Codec{}, false
+	}
+	return codecFor(mediaType)
+}
+
+// negotiate picks the codecs for the request body and the response and sets
+// the response headers. It answers 406 or 415 itself and reports false when
+// no codec fits.
+func negotiate(response http.ResponseWriter, request *http.Request) (in, out Codec, ok bool) {
+	setResponseHeader(response)
+	response.Header().Add("Vary", "Accept")
+	out, ok = acceptedCodec(request)
+	if !ok {
+		writeError(response, request, http.StatusNotAcceptable, errNotAcceptable)
+		return in, out, false
+	}
+	response.Header().Set("content-type", out.MediaType)
+	if request.Method == http.MethodPost || request.Method == http.MethodPut {
+		if in, ok = contentCodec(request); !ok {
+			writeError(response, request, http.StatusUnsupportedMediaType, errUnsupportedMediaType)
+			return in, out, false
+		}
+	}
+	return in, out, true
+}
+
+// maxBodyBytes caps the body of a single employee request.
+const maxBodyBytes = 1 << 20
+
+// decodeBody reads the request body, up to maxBodyBytes, with codec.
+func decodeBody(response http.ResponseWriter, request *http.Request, codec Codec, v interface{}) error {
+	data, err := io.ReadAll(http.MaxBytesReader(response, request.Body, maxBodyBytes))
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return io.EOF
+	}
+	return codec.Unmarshal(data, v)
+}
+
+// employeeWire is how Employee travels in formats other than JSON, whose
+// encoders would otherwise write the raw bytes of the ObjectId.
+type employeeWire struct {
+	ID        string  `json:"_id,omitempty" xml:"id,omitempty" yaml:"_id,omitempty"`
+	Firstname string  `json:"firstname,omitempty" xml:"firstname,omitempty" yaml:"firstname,omitempty"`
+	Lastname  string  `json:"lastname,omitempty" xml:"lastname,omitempty" yaml:"lastname,omitempty"`
+	EmpID     int     `json:"empid,omitempty" xml:"empid,omitempty" yaml:"empid,omitempty"`
+	Salary    float64 `json:"salary,omitempty" xml:"salary,omitempty" yaml:"salary,omitempty"`
+	Practice  string  `json:"practice,omitempty" xml:"practice,omitempty" yaml:"practice,omitempty"`
+}
+
+// collectionWire is the wire form of EmployeeCollection.
+type collectionWire struct {
+	Employees []employeeWire `json:"employees" yaml:"employees"`
+	Count     int            `json:"count" yaml:"count"`
+}
+
+func (e Employee) wire() employeeWire {
+	return employeeWire{
+		ID:        e.ID.Hex(),
+		Firstname: e.Firstname,
+		Lastname:  e.Lastname,
+		EmpID:     e.EmpID,
+		Salary:    e.Salary,
+		Practice:  e.Practice,
+	}
+}
+
+func (w employeeWire) employee() (Employee, error) {
+	e := Employee{
+		Firstname: w.Firstname,
+		Lastname:  w.Lastname,
+		EmpID:     w.EmpID,
+		Salary:    w.Salary,
+		Practice:  w.Practice,
+	}
+	if w.ID != "" {
+		if !bson.IsObjectIdHex(w.ID) {
+			return e, errors.New("invalid employee id " + strconv.Quote(w.ID))
+		}
+		e.ID = bson.ObjectIdHex(w.ID)
+	}
+	return e, nil
+}
+
+func (c EmployeeCollection) wire() collectionWire {
+	w := collectionWire{Count: c.Count}
+	for _, e := range c.AllEmployees {
+		w.Employees = append(w.Employees, e.wire())
+	}
+	return w
+}
+
+func (w collectionWire) collection() (EmployeeCollection, error) {
+	c := EmployeeCollection{Count: w.Count}
+	for _, ew := range w.Employees {
+		e, err := ew.employee()
+		if err != nil {
+			return c, err
+		}
+		c.AllEmployees = append(c.AllEmployees, e)
+	}
+	return c, nil
+}
+
+// toWire replaces employees in v by their wire form.
+func toWire(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Employee:
+		return v.wire()
+	case *Employee:
+		return v.wire()
+	case EmployeeCollection:
+		return v.wire()
+	case *EmployeeCollection:
+		return v.wire()
+	}
+	return v
+}
+
+// wireMarshal adapts a marshal function to encode employees in wire form.
+func wireMarshal(marshal func(v interface{}) ([]byte, error)) func(v interface{}) ([]byte, error) {
+	return func(v interface{}) ([]byte, error) {
+		return marshal(toWire(v))
+	}
+}
+
+// wireUnmarshal adapts an unmarshal function to decode employees from their
+// wire form.
+func wireUnmarshal(unmarshal func(data []byte, v interface{}) error) func(data []byte, v interface{}) error {
+	return func(data []byte, v interface{}) error {
+		switch v := v.(type) {
+		case *Employee:
+			var w employeeWire
+			if err := unmarshal(data, &w); err != nil {
+				return err
+			}
+			e, err := w.employee()
+			*v = e
+			return err
+		case *EmployeeCollection:
+			var w collectionWire
+			if err := unmarshal(data, &w); err != nil {
+				return err
+			}
+			c, err := w.collection()
+			*v = c
+			return err
+		}
+		return unmarshal(data, v)
+	}
+}
+
+// MarshalXML writes the employee as an <employee> element with a hex id.
+func (e Employee) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "employee"}
+	return enc.EncodeElement(e.wire(), start)
+}
+
+// UnmarshalXML reads an employee written by MarshalXML.
+func (e *Employee) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var w employeeWire
+	if err := dec.DecodeElement(&w, &start); err != nil {
+		return err
+	}
+	decoded, err := w.employee()
+	*e = decoded
+	return err
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestAcceptedCodec(t *testing.T) {
+	cases := map[string]string{
+		"":                                      "application/json",
+		"*/*":                                   "application/json",
+		"application/xml":                       "application/xml",
+		"text/xml":                              "application/xml",
+		"application/json;q=0.5, text/xml":      "application/xml",
+		"application/xml;q=0, application/*":    "application/json",
+		"text/html, application/xml;q=0.9":      "application/xml",
+		"application/json, application/xml":     "application/json",
+		"application/xml, application/json;q=1": "application/xml",
+	}
+	for accept, want := range cases {
+		req, _ := http.NewRequest("GET", "/employees", nil)
+		req.Header.Set("Accept", accept)
+		codec, ok := acceptedCodec(req)
+		if !ok || codec.MediaType != want {
+			t.Errorf("Accept %q: got %q want %q", accept, codec.MediaType, want)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/employees", nil)
+	req.Header.Set("Accept", "text/html")
+	_, ok := acceptedCodec(req)
+	assert.False(t, ok)
+}
+
+func TestEmployeeXML(t *testing.T) {
+	employee := Employee{bson.NewObjectId(), "aditi", "patil", 1200, 20000, "IBM"}
+	out, err := xml.Marshal(employee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(out), "<employee><id>"+employee.ID.Hex()+"</id>")
+
+	var decoded Employee
+	if err := xml.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, employee, decoded)
+
+	t.Run("it rejects malformed ids", func(t *testing.T) {
+		var decoded Employee
+		err := xml.Unmarshal([]byte("<employee><id>42</id></employee>"), &decoded)
+		assert.Error(t, err)
+	})
+
+	t.Run("it wraps collections", func(t *testing.T) {
+		out, _ := xml.Marshal(EmployeeCollection{AllEmployees: []Employee{{Firstname: "aditi"}}, Count: 1})
+		assert.Equal(t, "<employees><employee><firstname>aditi</firstname></employee><count>1</count></employees>", string(out))
+	})
+}
+
+func TestNegotiation(t *testing.T) {
+	saved, ready := store, storeReady.Load()
+	store = stubStore{}
+	storeReady.Store(true)
+	defer func() {
+		store = saved
+		storeReady.Store(ready)
+	}()
+
+	req, _ := http.NewRequest("GET", "/employees", nil)
+	req.Header.Set("Accept", "application/xml")
+	rr := httptest.NewRecorder()
+	GetEmployeesEndpoint(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/xml", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "<employees><count>0</count></employees>", rr.Body.String())
+
+	t.Run("it returns 406 for unsupported Accept", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/employees", nil)
+		req.Header.Set("Accept", "text/csv")
+		rr := httptest.NewRecorder()
+		GetEmployeesEndpoint(rr, req)
+		if status := rr.Code; status != http.StatusNotAcceptable {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusNotAcceptable)
+		}
+	})
+
+	t.Run("it returns 415 for unsupported Content-Type", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/employees", bytes.NewBufferString("firstname=aditi"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		CreateEmployeeEndpoint(rr, req)
+		if status := rr.Code; status != http.StatusUnsupportedMediaType {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusUnsupportedMediaType)
+		}
+	})
+
+	t.Run("it accepts XML bodies", func(t *testing.T) {
+		payload := `<employee><firstname>aditi</firstname><empid>1200</empid></employee>`
+		req, _ := http.NewRequest("POST", "/employees", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/xml")
+		rr := httptest.NewRecorder()
+		CreateEmployeeEndpoint(rr, req)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"firstname":"aditi"`)
+	})
+
+	t.Run("it returns 400 for malformed bodies", func(t *testing.T) {
+		for _, body := range []struct{ contentType, payload string }{
+			{"application/json", `{"firstname": `},
+			{"application/xml", `<employee><firstname>aditi</employee>`},
+			{"application/yaml", "firstname: [aditi"},
+			{"application/json", ""},
+		} {
+			req, _ := http.NewRequest("POST", "/employees", bytes.NewBufferString(body.payload))
+			req.Header.Set("Content-Type", body.contentType)
+			rr := httptest.NewRecorder()
+			CreateEmployeeEndpoint(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+
+			req, _ = http.NewRequest("PUT", "/employee/000000000000000000000000", bytes.NewBufferString(body.payload))
+			req.Header.Set("Content-Type", body.contentType)
+			rr = httptest.NewRecorder()
+			UpdateEmployeeEndpoint(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+		}
+	})
+
+	t.Run("it returns 413 for oversized bodies", func(t *testing.T) {
+		payload := `{"firstname": "` + strings.Repeat("a", maxBodyBytes) + `"}`
+		req, _ := http.NewRequest("POST", "/employees", bytes.NewBufferString(payload))
+		rr := httptest.NewRecorder()
+		CreateEmployeeEndpoint(rr, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("it answers deletes without a body", func(t *testing.T) {
+		req, _ := http.NewRequest("DELETE", "/employee/000000000000000000000000", nil)
+		req.Header.Set("Accept", "application/x-protobuf")
+		rr := httptest.NewRecorder()
+		DeleteEmployeeEndpoint(rr, req)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Empty(t, rr.Body.String())
+	})
+
+	t.Run("it writes error envelopes in XML", func(t *testing.T) {
+		store = stubStore{err: ErrNotFound}
+		req, _ := http.NewRequest("GET", "/employee/000000000000000000000000", nil)
+		req.Header.Set("Accept", "application/xml")
+		rr := httptest.NewRecorder()
+		GetEmployeeEndpoint(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "<error><message>not found</message></error>", rr.Body.String())
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getenvFrom(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, printOnly, err := loadConfig(nil, getenvFrom(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, printOnly)
+	assert.Equal(t, defaultConfig().Addr, cfg.Addr)
+
+	t.Run("it applies file, env and flags in order", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		os.WriteFile(path, []byte(`
+addr: ":8080"
+log_level: debug
+mongo:
+  url: mongo.internal
+  database: employees
+server:
+  shutdown_timeout: 10s
+`), 0o600)
+		env := map[string]string{
+			"MUXCRUD_CONFIG":    path,
+			"MUXCRUD_LOG_LEVEL": "warn",
+			"MUXCRUD_MONGO_URL": "mongo.env",
+		}
+		cfg, _, err := loadConfig([]string{"--mongo-url", "mongo.flag"}, getenvFrom(env))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, ":8080", cfg.Addr)
+		assert.Equal(t, "employees", cfg.Mongo.Database)
+		assert.Equal(t, 10*time.Second, cfg.Server.ShutdownTimeout)
+		assert.Equal(t, "warn", cfg.LogLevel)
+		assert.Equal(t, "mongo.flag", cfg.Mongo.URL)
+	})
+
+	t.Run("it reads TOML files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		os.WriteFile(path, []byte(`
+[cors]
+allowed_origins = ["https://hr.example.com"]
+
+[server]
+drain_delay = "1s"
+`), 0o600)
+		cfg, _, err := loadConfig([]string{"--config", path}, getenvFrom(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []string{"https://hr.example.com"}, cfg.CORS.AllowedOrigins)
+		assert.Equal(t, time.Second, cfg.Server.DrainDelay)
+	})
+
+	t.Run("it reports every invalid value", func(t *testing.T) {
+		env := map[string]string{"MUXCRUD_ADDR": "nope", "MUXCRUD_TRACE_EXPORTER": "zipkin"}
+		_, _, err := loadConfig([]string{"--write-timeout", "0s"}, getenvFrom(env))
+		if err == nil {
+			t.Fatal("invalid configuration was accepted")
+		}
+		for _, field := range []string{"addr", "trace_exporter", "server.write_timeout"} {
+			assert.Contains(t, err.Error(), field)
+		}
+	})
+}
+
+func TestPrintConfig(t *testing.T) {
+	cfg, printOnly, err := loadConfig([]string{"--print-config", "--mongo-url", "mongodb://hr:s3cret@db/admin"}, getenvFrom(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, printOnly)
+
+	var buf bytes.Buffer
+	printConfig(&buf, cfg)
+	assert.Contains(t, buf.String(), "mongodb://hr:xxxxx@db/admin")
+	assert.False(t, strings.Contains(buf.String(), "s3cret"))
+}
+
+func TestBackendConfig(t *testing.T) {
+	t.Run("SQL backends need a DSN", func(t *testing.T) {
+		_, _, err := loadConfig([]string{"--backend", "postgres"}, getenvFrom(nil))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "sql.dsn")
+		}
+		cfg, _, err := loadConfig([]string{"--backend", "sqlite", "--sql-dsn", "employees.db"}, getenvFrom(nil))
+		assert.NoError(t, err)
+		assert.Equal(t, "employees.db", cfg.SQL.DSN)
+	})
+
+	t.Run("it rejects unknown backends", func(t *testing.T) {
+		_, _, err := loadConfig([]string{"--backend", "mysql"}, getenvFrom(nil))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "backend")
+		}
+	})
+
+	t.Run("watching works with either mongo driver", func(t *testing.T) {
+		for _, driver := range []string{"mgo", "mongo"} {
+			for _, mode := range []string{"changestream", "poll"} {
+				_, _, err := loadConfig([]string{"--watch-mode", mode, "--mongo-driver", driver}, getenvFrom(nil))
+				assert.NoError(t, err, driver+" "+mode)
+			}
+		}
+		_, _, err := loadConfig([]string{"--watch-mode", "auto", "--backend", "bolt"}, getenvFrom(nil))
+		assert.ErrorContains(t, err, "watch.mode")
+	})
+
+	t.Run("it redacts DSN passwords", func(t *testing.T) {
+		assert.Equal(t, "postgres://hr:xxxxx@db/employees", redactDSN("postgres://hr:s3cret@db/employees"))
+		assert.Equal(t, "host=db user=hr password=xxxxx", redactDSN("host=db user=hr password=s3cret"))
+		assert.Equal(t, "employees.db", redactDSN("employees.db"))
+	})
+}
+
+func TestConfigFlags(t *testing.T) {
+	t.Run("flags are typed", func(t *testing.T) {
+		cfg, _, err := loadConfig([]string{"--graphiql", "--jobs-workers", "4", "--cors-allowed-origins", "https://a,https://b"}, getenvFrom(nil))
+		assert.NoError(t, err)
+		assert.True(t, cfg.GraphQL.GraphiQL, "bool flags need no value")
+		assert.Equal(t, 4, cfg.Jobs.Workers)
+		assert.Equal(t, []string{"https://a", "https://b"}, cfg.CORS.AllowedOrigins)
+
+		_, _, err = loadConfig([]string{"--read-timeout", "soon"}, getenvFrom(nil))
+		assert.ErrorContains(t, err, "read-timeout")
+	})
+
+	t.Run("usage shows the defaults", func(t *testing.T) {
+		flags := defaultConfig()
+		fs, _, _ := configFlags(&flags, getenvFrom(nil))
+		var usage strings.Builder
+		fs.SetOutput(&usage)
+		fs.PrintDefaults()
+		assert.Contains(t, usage.String(), `(default 15s)`)
+		assert.Contains(t, usage.String(), `(default "localhost")`)
+		assert.Contains(t, usage.String(), `(default X-Requested-With,Content-Type,Authorization)`)
+		assert.Equal(t, "100", fs.Lookup("mongo-max-pool-size").DefValue)
+	})
+}
+
+func TestCacheConfig(t *testing.T) {
+	_, _, err := loadConfig([]string{"--cache-ttl", "0s"}, getenvFrom(nil))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cache")
+	}
+	cfg, _, err := loadConfig([]string{"--cache-size", "0", "--cache-ttl", "0s"}, getenvFrom(nil))
+	assert.NoError(t, err, "a disabled cache needs no TTL")
+	assert.Zero(t, cfg.Cache.Size)
+}
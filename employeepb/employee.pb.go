@@ -0,0 +1,746 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: employeepb/employee.proto
+
+package employeepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Employee is an employee record. id is the hex ObjectId.
+type Employee struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Firstname     string                 `protobuf:"bytes,2,opt,name=firstname,proto3" json:"firstname,omitempty"`
+	Lastname      string                 `protobuf:"bytes,3,opt,name=lastname,proto3" json:"lastname,omitempty"`
+	Empid         int64                  `protobuf:"varint,4,opt,name=empid,proto3" json:"empid,omitempty"`
+	Salary        float64                `protobuf:"fixed64,5,opt,name=salary,proto3" json:"salary,omitempty"`
+	Practice      string                 `protobuf:"bytes,6,opt,name=practice,proto3" json:"practice,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Employee) Reset() {
+	*x = Employee{}
+	mi := &file_employeepb_employee_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Employee) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Employee) ProtoMessage() {}
+
+func (x *Employee) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Employee.ProtoReflect.Descriptor instead.
+func (*Employee) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Employee) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Employee) GetFirstname() string {
+	if x != nil {
+		return x.Firstname
+	}
+	return ""
+}
+
+func (x *Employee) GetLastname() string {
+	if x != nil {
+		return x.Lastname
+	}
+	return ""
+}
+
+func (x *Employee) GetEmpid() int64 {
+	if x != nil {
+		return x.Empid
+	}
+	return 0
+}
+
+func (x *Employee) GetSalary() float64 {
+	if x != nil {
+		return x.Salary
+	}
+	return 0
+}
+
+func (x *Employee) GetPractice() string {
+	if x != nil {
+		return x.Practice
+	}
+	return ""
+}
+
+// EmployeeCollection is a page of employees.
+type EmployeeCollection struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Employees     []*Employee            `protobuf:"bytes,1,rep,name=employees,proto3" json:"employees,omitempty"`
+	Count         int64                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EmployeeCollection) Reset() {
+	*x = EmployeeCollection{}
+	mi := &file_employeepb_employee_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EmployeeCollection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EmployeeCollection) ProtoMessage() {}
+
+func (x *EmployeeCollection) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EmployeeCollection.ProtoReflect.Descriptor instead.
+func (*EmployeeCollection) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *EmployeeCollection) GetEmployees() []*Employee {
+	if x != nil {
+		return x.Employees
+	}
+	return nil
+}
+
+func (x *EmployeeCollection) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+// Error is the body of every error response.
+type Error struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	RequestId     string                 `protobuf:"bytes,2,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	mi := &file_employeepb_employee_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Error) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Error) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+type CreateEmployeeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Employee      *Employee              `protobuf:"bytes,1,opt,name=employee,proto3" json:"employee,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateEmployeeRequest) Reset() {
+	*x = CreateEmployeeRequest{}
+	mi := &file_employeepb_employee_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateEmployeeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateEmployeeRequest) ProtoMessage() {}
+
+func (x *CreateEmployeeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateEmployeeRequest.ProtoReflect.Descriptor instead.
+func (*CreateEmployeeRequest) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CreateEmployeeRequest) GetEmployee() *Employee {
+	if x != nil {
+		return x.Employee
+	}
+	return nil
+}
+
+type GetEmployeeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetEmployeeRequest) Reset() {
+	*x = GetEmployeeRequest{}
+	mi := &file_employeepb_employee_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetEmployeeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetEmployeeRequest) ProtoMessage() {}
+
+func (x *GetEmployeeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetEmployeeRequest.ProtoReflect.Descriptor instead.
+func (*GetEmployeeRequest) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetEmployeeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// ListEmployeesRequest pages, filters and sorts like GET /employees: page
+// starts at 1 and a zero limit returns everything. Empty filters match every
+// employee.
+type ListEmployeesRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Limit    int32                  `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Page     int32                  `protobuf:"varint,2,opt,name=page,proto3" json:"page,omitempty"`
+	Practice string                 `protobuf:"bytes,3,opt,name=practice,proto3" json:"practice,omitempty"`
+	Lastname string                 `protobuf:"bytes,4,opt,name=lastname,proto3" json:"lastname,omitempty"`
+	Empid    int64                  `protobuf:"varint,5,opt,name=empid,proto3" json:"empid,omitempty"`
+	// sort is firstname, lastname, empid, salary or practice, prefixed with -
+	// for descending order.
+	Sort          string `protobuf:"bytes,6,opt,name=sort,proto3" json:"sort,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListEmployeesRequest) Reset() {
+	*x = ListEmployeesRequest{}
+	mi := &file_employeepb_employee_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListEmployeesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListEmployeesRequest) ProtoMessage() {}
+
+func (x *ListEmployeesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListEmployeesRequest.ProtoReflect.Descriptor instead.
+func (*ListEmployeesRequest) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListEmployeesRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListEmployeesRequest) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListEmployeesRequest) GetPractice() string {
+	if x != nil {
+		return x.Practice
+	}
+	return ""
+}
+
+func (x *ListEmployeesRequest) GetLastname() string {
+	if x != nil {
+		return x.Lastname
+	}
+	return ""
+}
+
+func (x *ListEmployeesRequest) GetEmpid() int64 {
+	if x != nil {
+		return x.Empid
+	}
+	return 0
+}
+
+func (x *ListEmployeesRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+type UpdateEmployeeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Employee      *Employee              `protobuf:"bytes,2,opt,name=employee,proto3" json:"employee,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateEmployeeRequest) Reset() {
+	*x = UpdateEmployeeRequest{}
+	mi := &file_employeepb_employee_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateEmployeeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateEmployeeRequest) ProtoMessage() {}
+
+func (x *UpdateEmployeeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateEmployeeRequest.ProtoReflect.Descriptor instead.
+func (*UpdateEmployeeRequest) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *UpdateEmployeeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *UpdateEmployeeRequest) GetEmployee() *Employee {
+	if x != nil {
+		return x.Employee
+	}
+	return nil
+}
+
+type DeleteEmployeeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteEmployeeRequest) Reset() {
+	*x = DeleteEmployeeRequest{}
+	mi := &file_employeepb_employee_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteEmployeeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteEmployeeRequest) ProtoMessage() {}
+
+func (x *DeleteEmployeeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteEmployeeRequest.ProtoReflect.Descriptor instead.
+func (*DeleteEmployeeRequest) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DeleteEmployeeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type DeleteEmployeeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteEmployeeResponse) Reset() {
+	*x = DeleteEmployeeResponse{}
+	mi := &file_employeepb_employee_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteEmployeeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteEmployeeResponse) ProtoMessage() {}
+
+func (x *DeleteEmployeeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteEmployeeResponse.ProtoReflect.Descriptor instead.
+func (*DeleteEmployeeResponse) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{8}
+}
+
+// WatchEmployeesRequest optionally restricts events to one practice.
+type WatchEmployeesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Practice      string                 `protobuf:"bytes,1,opt,name=practice,proto3" json:"practice,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchEmployeesRequest) Reset() {
+	*x = WatchEmployeesRequest{}
+	mi := &file_employeepb_employee_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEmployeesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEmployeesRequest) ProtoMessage() {}
+
+func (x *WatchEmployeesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEmployeesRequest.ProtoReflect.Descriptor instead.
+func (*WatchEmployeesRequest) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *WatchEmployeesRequest) GetPractice() string {
+	if x != nil {
+		return x.Practice
+	}
+	return ""
+}
+
+// EmployeeEvent is a change to an employee. type is employee.created,
+// employee.updated or employee.deleted; deletions carry the employee as it
+// was before removal.
+type EmployeeEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Employee      *Employee              `protobuf:"bytes,2,opt,name=employee,proto3" json:"employee,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EmployeeEvent) Reset() {
+	*x = EmployeeEvent{}
+	mi := &file_employeepb_employee_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EmployeeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EmployeeEvent) ProtoMessage() {}
+
+func (x *EmployeeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_employeepb_employee_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EmployeeEvent.ProtoReflect.Descriptor instead.
+func (*EmployeeEvent) Descriptor() ([]byte, []int) {
+	return file_employeepb_employee_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *EmployeeEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *EmployeeEvent) GetEmployee() *Employee {
+	if x != nil {
+		return x.Employee
+	}
+	return nil
+}
+
+var File_employeepb_employee_proto protoreflect.FileDescriptor
+
+const file_employeepb_employee_proto_rawDesc = "" +
+	"\n" +
+	"\x19employeepb/employee.proto\x12\n" +
+	"muxcrud.v1\"\x9e\x01\n" +
+	"\bEmployee\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1c\n" +
+	"\tfirstname\x18\x02 \x01(\tR\tfirstname\x12\x1a\n" +
+	"\blastname\x18\x03 \x01(\tR\blastname\x12\x14\n" +
+	"\x05empid\x18\x04 \x01(\x03R\x05empid\x12\x16\n" +
+	"\x06salary\x18\x05 \x01(\x01R\x06salary\x12\x1a\n" +
+	"\bpractice\x18\x06 \x01(\tR\bpractice\"^\n" +
+	"\x12EmployeeCollection\x122\n" +
+	"\temployees\x18\x01 \x03(\v2\x14.muxcrud.v1.EmployeeR\temployees\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x03R\x05count\"@\n" +
+	"\x05Error\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x02 \x01(\tR\trequestId\"I\n" +
+	"\x15CreateEmployeeRequest\x120\n" +
+	"\bemployee\x18\x01 \x01(\v2\x14.muxcrud.v1.EmployeeR\bemployee\"$\n" +
+	"\x12GetEmployeeRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xa2\x01\n" +
+	"\x14ListEmployeesRequest\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x12\n" +
+	"\x04page\x18\x02 \x01(\x05R\x04page\x12\x1a\n" +
+	"\bpractice\x18\x03 \x01(\tR\bpractice\x12\x1a\n" +
+	"\blastname\x18\x04 \x01(\tR\blastname\x12\x14\n" +
+	"\x05empid\x18\x05 \x01(\x03R\x05empid\x12\x12\n" +
+	"\x04sort\x18\x06 \x01(\tR\x04sort\"Y\n" +
+	"\x15UpdateEmployeeRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x120\n" +
+	"\bemployee\x18\x02 \x01(\v2\x14.muxcrud.v1.EmployeeR\bemployee\"'\n" +
+	"\x15DeleteEmployeeRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\x18\n" +
+	"\x16DeleteEmployeeResponse\"3\n" +
+	"\x15WatchEmployeesRequest\x12\x1a\n" +
+	"\bpractice\x18\x01 \x01(\tR\bpractice\"U\n" +
+	"\rEmployeeEvent\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x120\n" +
+	"\bemployee\x18\x02 \x01(\v2\x14.muxcrud.v1.EmployeeR\bemployee2\xea\x03\n" +
+	"\x0fEmployeeService\x12I\n" +
+	"\x0eCreateEmployee\x12!.muxcrud.v1.CreateEmployeeRequest\x1a\x14.muxcrud.v1.Employee\x12C\n" +
+	"\vGetEmployee\x12\x1e.muxcrud.v1.GetEmployeeRequest\x1a\x14.muxcrud.v1.Employee\x12Q\n" +
+	"\rListEmployees\x12 .muxcrud.v1.ListEmployeesRequest\x1a\x1e.muxcrud.v1.EmployeeCollection\x12I\n" +
+	"\x0eUpdateEmployee\x12!.muxcrud.v1.UpdateEmployeeRequest\x1a\x14.muxcrud.v1.Employee\x12W\n" +
+	"\x0eDeleteEmployee\x12!.muxcrud.v1.DeleteEmployeeRequest\x1a\".muxcrud.v1.DeleteEmployeeResponse\x12P\n" +
+	"\x0eWatchEmployees\x12!.muxcrud.v1.WatchEmployeesRequest\x1a\x19.muxcrud.v1.EmployeeEvent0\x01B.Z,github.com/shubhamkhanna/mux_crud/employeepbb\x06proto3"
+
+var (
+	file_employeepb_employee_proto_rawDescOnce sync.Once
+	file_employeepb_employee_proto_rawDescData []byte
+)
+
+func file_employeepb_employee_proto_rawDescGZIP() []byte {
+	file_employeepb_employee_proto_rawDescOnce.Do(func() {
+		file_employeepb_employee_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_employeepb_employee_proto_rawDesc), len(file_employeepb_employee_proto_rawDesc)))
+	})
+	return file_employeepb_employee_proto_rawDescData
+}
+
+var file_employeepb_employee_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_employeepb_employee_proto_goTypes = []any{
+	(*Employee)(nil),               // 0: muxcrud.v1.Employee
+	(*EmployeeCollection)(nil),     // 1: muxcrud.v1.EmployeeCollection
+	(*Error)(nil),                  // 2: muxcrud.v1.Error
+	(*CreateEmployeeRequest)(nil),  // 3: muxcrud.v1.CreateEmployeeRequest
+	(*GetEmployeeRequest)(nil),     // 4: muxcrud.v1.GetEmployeeRequest
+	(*ListEmployeesRequest)(nil),   // 5: muxcrud.v1.ListEmployeesRequest
+	(*UpdateEmployeeRequest)(nil),  // 6: muxcrud.v1.UpdateEmployeeRequest
+	(*DeleteEmployeeRequest)(nil),  // 7: muxcrud.v1.DeleteEmployeeRequest
+	(*DeleteEmployeeResponse)(nil), // 8: muxcrud.v1.DeleteEmployeeResponse
+	(*WatchEmployeesRequest)(nil),  // 9: muxcrud.v1.WatchEmployeesRequest
+	(*EmployeeEvent)(nil),          // 10: muxcrud.v1.EmployeeEvent
+}
+var file_employeepb_employee_proto_depIdxs = []int32{
+	0,  // 0: muxcrud.v1.EmployeeCollection.employees:type_name -> muxcrud.v1.Employee
+	0,  // 1: muxcrud.v1.CreateEmployeeRequest.employee:type_name -> muxcrud.v1.Employee
+	0,  // 2: muxcrud.v1.UpdateEmployeeRequest.employee:type_name -> muxcrud.v1.Employee
+	0,  // 3: muxcrud.v1.EmployeeEvent.employee:type_name -> muxcrud.v1.Employee
+	3,  // 4: muxcrud.v1.EmployeeService.CreateEmployee:input_type -> muxcrud.v1.CreateEmployeeRequest
+	4,  // 5: muxcrud.v1.EmployeeService.GetEmployee:input_type -> muxcrud.v1.GetEmployeeRequest
+	5,  // 6: muxcrud.v1.EmployeeService.ListEmployees:input_type -> muxcrud.v1.ListEmployeesRequest
+	6,  // 7: muxcrud.v1.EmployeeService.UpdateEmployee:input_type -> muxcrud.v1.UpdateEmployeeRequest
+	7,  // 8: muxcrud.v1.EmployeeService.DeleteEmployee:input_type -> muxcrud.v1.DeleteEmployeeRequest
+	9,  // 9: muxcrud.v1.EmployeeService.WatchEmployees:input_type -> muxcrud.v1.WatchEmployeesRequest
+	0,  // 10: muxcrud.v1.EmployeeService.CreateEmployee:output_type -> muxcrud.v1.Employee
+	0,  // 11: muxcrud.v1.EmployeeService.GetEmployee:output_type -> muxcrud.v1.Employee
+	1,  // 12: muxcrud.v1.EmployeeService.ListEmployees:output_type -> muxcrud.v1.EmployeeCollection
+	0,  // 13: muxcrud.v1.EmployeeService.UpdateEmployee:output_type -> muxcrud.v1.Employee
+	8,  // 14: muxcrud.v1.EmployeeService.DeleteEmployee:output_type -> muxcrud.v1.DeleteEmployeeResponse
+	10, // 15: muxcrud.v1.EmployeeService.WatchEmployees:output_type -> muxcrud.v1.EmployeeEvent
+	10, // [10:16] is the sub-list for method output_type
+	4,  // [4:10] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_employeepb_employee_proto_init() }
+func file_employeepb_employee_proto_init() {
+	if File_employeepb_employee_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_employeepb_employee_proto_rawDesc), len(file_employeepb_employee_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_employeepb_employee_proto_goTypes,
+		DependencyIndexes: file_employeepb_employee_proto_depIdxs,
+		MessageInfos:      file_employeepb_employee_proto_msgTypes,
+	}.Build()
+	File_employeepb_employee_proto = out.File
+	file_employeepb_employee_proto_goTypes = nil
+	file_employeepb_employee_proto_depIdxs = nil
+}
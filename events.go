@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Employee event types.
+const (
+	EventEmployeeCreated = "employee.created"
+	EventEmployeeUpdated = "employee.updated"
+	EventEmployeeDeleted = "employee.deleted"
+)
+
+// eventReplayBuffer is how many recent events the bus keeps for subscribers
+// resuming after a disconnect.
+const eventReplayBuffer = 1000
+
+// EmployeeEvent is a change made through the store. Updates carry the
+// employee as stored afterwards and deletions the employee as it was. The
+// bus sets ID when the event is published.
+type EmployeeEvent struct {
+	ID       string
+	Type     string
+	Employee Employee
+	Time     time.Time
+}
+
+// eventBus fans employee events out to in-process subscribers and keeps the
+// latest ones for replay. Event ids are "<epoch>-<sequence>", the epoch
+// telling ids of an earlier process apart.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan EmployeeEvent]struct{}
+	epoch       string
+	seq         uint64
+	replay      []EmployeeEvent
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: make(map[chan EmployeeEvent]struct{}),
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+// events carries every change made through store.
+var events = newEventBus()
+
+// Subscribe returns a channel receiving events published from now on and a
+// function that ends the subscription. A subscriber that lets buffer events
+// pile up is dropped and its channel closed rather than blocking writers.
+func (b *eventBus) Subscribe(buffer int) (<-chan EmployeeEvent, func()) {
+	_, _, ch, unsubscribe := b.SubscribeSince("", buffer)
+	return ch, unsubscribe
+}
+
+// SubscribeSince subscribes like Subscribe and also returns the buffered
+// events published after the one with id lastID. It reports false when
+// events since then may be missing: lastID is unknown, from an earlier
+// process, or older than the buffer. An empty lastID replays nothing.
+func (b *eventBus) SubscribeSince(lastID string, buffer int) ([]EmployeeEvent, bool, <-chan EmployeeEvent, func()) {
+	ch := make(chan EmployeeEvent, buffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	replayed, complete := b.since(lastID)
+	b.mu.Unlock()
+	return replayed, complete, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.drop(ch)
+	}
+}
+
+// since returns the buffered events after lastID; b.mu must be held.
+func (b *eventBus) since(lastID string) ([]EmployeeEvent, bool) {
+	if lastID == "" {
+		return nil, true
+	}
+	epoch, seqText, _ := strings.Cut(lastID, "-")
+	seq, err := strconv.ParseUint(seqText, 10, 64)
+	if err != nil || epoch != b.epoch || seq > b.seq {
+		return nil, false
+	}
+	oldest := b.seq - uint64(len(b.replay)) // sequence before the first buffered event
+	if seq < oldest {
+		return append([]EmployeeEvent(nil), b.replay...), false
+	}
+	return append([]EmployeeEvent(nil), b.replay[seq-oldest:]...), true
+}
+
+// drop removes ch; b.mu must be held.
+func (b *eventBus) drop(ch chan EmployeeEvent) {
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish gives event the next id and delivers it to every subscriber
+// without blocking.
+func (b *eventBus) Publish(event EmployeeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	event.ID = b.epoch + "-" + strconv.FormatUint(b.seq, 10)
+	b.replay = append(b.replay, event)
+	if len(b.replay) > eventReplayBuffer {
+		b.replay = b.replay[1:]
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logger.Warn("dropping slow event subscriber", "event", event.Type)
+			b.drop(ch)
+		}
+	}
+}
+
+// notifyingStore publishes an event after every successful write, for
+// stores that do not record events in an outbox. The write stands when
+// publishing fails, so the error is only logged.
+type notifyingStore struct {
+	next      EmployeeStore
+	publisher Publisher
+}
+
+func (s notifyingStore) publish(ctx context.Context, eventType string, employee Employee) {
+	event := EmployeeEvent{Type: eventType, Employee: employee, Time: time.Now()}
+	if err := s.publisher.Publish(context.WithoutCancel(ctx), event); err != nil {
+		logger.Error("publishing employee event", "event", eventType, "err", err)
+	}
+}
+
+func (s notifyingStore) Insert(ctx context.Context, employee *Employee) error {
+	if err := s.next.Insert(ctx, employee); err != nil {
+		return err
+	}
+	s.publish(ctx, EventEmployeeCreated, *employee)
+	return nil
+}
+
+func (s notifyingStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	return s.next.FindByID(ctx, id)
+}
+
+func (s notifyingStore) Find(ctx context.Context, query EmployeeQuery) ([]Employee, error) {
+	return s.next.Find(ctx, query)
+}
+
+func (s notifyingStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	return s.next.Iter(ctx, query)
+}
+
+func (s notifyingStore) Update(ctx context.Context, id string, employee Employee) error {
+	if err := s.next.Update(ctx, id, employee); err != nil {
+		return err
+	}
+	if updated, err := s.next.FindByID(ctx, id); err == nil {
+		employee = updated
+	} else {
+		employee.ID, _ = objectID(id)
+	}
+	s.publish(ctx, EventEmployeeUpdated, employee)
+	return nil
+}
+
+func (s notifyingStore) Remove(ctx context.Context, id string) error {
+	removed, err := s.next.FindByID(ctx, id)
+	if err != nil {
+		removed = Employee{}
+		removed.ID, _ = objectID(id)
+	}
+	if err := s.next.Remove(ctx, id); err != nil {
+		return err
+	}
+	s.publish(ctx, EventEmployeeDeleted, removed)
+	return nil
+}
+
+func (s notifyingStore) CountByPractice(ctx context.Context) (map[string]int, error) {
+	return s.next.CountByPractice(ctx)
+}
+
+func (s notifyingStore) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := newEventBus()
+	ch, unsubscribe := bus.Subscribe(1)
+	bus.Publish(EmployeeEvent{Type: EventEmployeeCreated})
+	assert.Equal(t, EventEmployeeCreated, (<-ch).Type)
+
+	t.Run("it drops slow subscribers", func(t *testing.T) {
+		bus.Publish(EmployeeEvent{Type: EventEmployeeCreated})
+		bus.Publish(EmployeeEvent{Type: EventEmployeeUpdated})
+		<-ch
+		_, ok := <-ch
+		assert.False(t, ok)
+		unsubscribe()
+	})
+}
+
+func TestNotifyingStore(t *testing.T) {
+	bus := newEventBus()
+	s := notifyingStore{next: &memoryStore{}, publisher: busPublisher{bus: bus}}
+	ch, unsubscribe := bus.Subscribe(4)
+	defer unsubscribe()
+	ctx := context.Background()
+
+	employee := Employee{Firstname: "aditi", Practice: "IBM"}
+	s.Insert(ctx, &employee)
+	s.Update(ctx, employee.ID.Hex(), Employee{Salary: 10})
+	s.Remove(ctx, employee.ID.Hex())
+	assert.Error(t, s.Remove(ctx, employee.ID.Hex()))
+
+	created, updated, deleted := <-ch, <-ch, <-ch
+	assert.Equal(t, EventEmployeeCreated, created.Type)
+	assert.Equal(t, employee, created.Employee)
+	assert.Equal(t, EventEmployeeUpdated, updated.Type)
+	assert.Equal(t, Employee{employee.ID, "aditi", "", 0, 10, "IBM"}, updated.Employee)
+	assert.Equal(t, EventEmployeeDeleted, deleted.Type)
+	assert.Equal(t, updated.Employee, deleted.Employee)
+	assert.Empty(t, ch)
+}
+
+func TestEventReplay(t *testing.T) {
+	bus := newEventBus()
+	for range eventReplayBuffer + 10 {
+		bus.Publish(EmployeeEvent{Type: EventEmployeeUpdated})
+	}
+	last := bus.replay[len(bus.replay)-1].ID
+
+	t.Run("it replays the events after the last id", func(t *testing.T) {
+		lastID := bus.replay[len(bus.replay)-3].ID
+		replayed, complete, _, unsubscribe := bus.SubscribeSince(lastID, 1)
+		defer unsubscribe()
+		assert.True(t, complete)
+		if assert.Len(t, replayed, 2) {
+			assert.Equal(t, last, replayed[1].ID)
+		}
+	})
+
+	t.Run("it replays nothing when up to date", func(t *testing.T) {
+		replayed, complete, _, unsubscribe := bus.SubscribeSince(last, 1)
+		defer unsubscribe()
+		assert.True(t, complete)
+		assert.Empty(t, replayed)
+	})
+
+	t.Run("it reports gaps", func(t *testing.T) {
+		for _, lastID := range []string{bus.epoch + "-5", "0-1", "garbage", bus.epoch + "-99999"} {
+			replayed, complete, _, unsubscribe := bus.SubscribeSince(lastID, 1)
+			unsubscribe()
+			assert.False(t, complete, lastID)
+			if lastID == bus.epoch+"-5" {
+				assert.Len(t, replayed, eventReplayBuffer)
+			}
+		}
+	})
+}
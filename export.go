@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// employeeColumns is the column order of exports without field selection.
+var employeeColumns = []string{"firstname", "lastname", "empid", "salary", "practice"}
+
+// exportFormats maps the format parameter to its media type.
+var exportFormats = map[string]string{
+	"csv":    csvMediaType,
+	"ndjson": "application/x-ndjson",
+	"xlsx":   xlsxMediaType,
+}
+
+// employeeValue returns field of e as a spreadsheet cell.
+func employeeValue(e Employee, field string) interface{} {
+	switch field {
+	case "firstname":
+		return e.Firstname
+	case "lastname":
+		return e.Lastname
+	case "empid":
+		return e.EmpID
+	case "salary":
+		return e.Salary
+	case "practice":
+		return e.Practice
+	}
+	return e.ID.Hex()
+}
+
+// spreadsheetText quotes text a spreadsheet would otherwise run as a
+// formula, so that a field like "=HYPERLINK(...)" opens as plain text.
+func spreadsheetText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// spreadsheetValue returns field of e as employeeValue does, with text
+// quoted by spreadsheetText.
+func spreadsheetValue(e Employee, field string) interface{} {
+	v := employeeValue(e, field)
+	if s, ok := v.(string); ok {
+		return spreadsheetText(s)
+	}
+	return v
+}
+
+// exportWriter writes one export format row by row.
+type exportWriter interface {
+	header(columns []string) error
+	row(e Employee) error
+	close() error
+}
+
+type csvExport struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+}
+
+func (x *csvExport) header(columns []string) error {
+	x.columns = columns
+	return x.w.Write(columns)
+}
+
+func (x *csvExport) row(e Employee) error {
+	x.record = x.record[:0]
+	for _, column := range x.columns {
+		switch v := spreadsheetValue(e, column).(type) {
+		case int:
+			x.record = append(x.record, strconv.Itoa(v))
+		case float64:
+			x.record = append(x.record, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			x.record = append(x.record, v.(string))
+		}
+	}
+	return x.w.Write(x.record)
+}
+
+func (x *csvExport) close() error {
+	x.w.Flush()
+	return x.w.Error()
+}
+
+type ndjsonExport struct {
+	enc *json.Encoder
+}
+
+func (x ndjsonExport) header([]string) error { return nil }
+func (x ndjsonExport) row(e Employee) error  { return x.enc.Encode(e) }
+func (x ndjsonExport) close() error          { return nil }
+
+// xlsxExport builds the sheet with excelize's stream writer, which keeps
+// rows on disk rather than in memory, and writes the workbook on close.
+type xlsxExport struct {
+	w       io.Writer
+	file    *excelize.File
+	sheet   *excelize.StreamWriter
+	columns []string
+	rows    int
+}
+
+func newXLSXExport(w io.Writer) (*xlsxExport, error) {
+	file := excelize.NewFile()
+	sheet, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxExport{w: w, file: file, sheet: sheet}, nil
+}
+
+func (x *xlsxExport) setRow(values []interface{}) error {
+	x.rows++
+	cell, err := excelize.CoordinatesToCellName(1, x.rows)
+	if err != nil {
+		return err
+	}
+	return x.sheet.SetRow(cell, values)
+}
+
+func (x *xlsxExport) header(columns []string) error {
+	x.columns = columns
+	values := make([]interface{}, len(columns))
+	for i, c := range columns {
+		values[i] = c
+	}
+	return x.setRow(values)
+}
+
+func (x *xlsxExport) row(e Employee) error {
+	values := make([]interface{}, len(x.columns))
+	for i, column := range x.columns {
+		values[i] = spreadsheetValue(e, column)
+	}
+	return x.setRow(values)
+}
+
+func (x *xlsxExport) close() error {
+	defer x.file.Close()
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.file.Write(x.w)
+}
+
+// newExportWriter returns the writer for format, which must be one of
+// exportFormats.
+func newExportWriter(format string, w io.Writer) (exportWriter, error) {
+	switch format {
+	case "ndjson":
+		return ndjsonExport{enc: json.NewEncoder(w)}, nil
+	case "xlsx":
+		return newXLSXExport(w)
+	}
+	return &csvExport{w: csv.NewWriter(w)}, nil
+}
+
+// exportColumns returns the columns of an export of query.
+func exportColumns(query EmployeeQuery) []string {
+	if len(query.Fields) > 0 {
+		return append([]string{"_id"}, query.Fields...)
+	}
+	return append([]string{"_id"}, employeeColumns...)
+}
+
+// writeExport writes the employees of it to w, calling progress with the
+// number of rows written so far, and closes it.
+func writeExport(it EmployeeIterator, w exportWriter, columns []string, progress func(rows int)) error {
+	err := w.header(columns)
+	var employee Employee
+	for rows := 1; err == nil && it.Next(&employee); rows++ {
+		err = w.row(employee)
+		progress(rows)
+	}
+	if closeErr := it.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = w.close()
+	}
+	return err
+}
+
+// ExportEmployeesEndpoint streams every employee matching the list filters
+// as a CSV, NDJSON or XLSX download.
+func ExportEmployeesEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /employees/export ExportEmployeesEndpoint
+	//
+	// Exports employees as a file.
+	// Takes the filter, sort and field parameters of GET /employees.
+	// ---
+	// produces:
+	// - text/csv
+	// - application/x-ndjson
+	// - application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+	// parameters:
+	// - name: format
+	//   in: query
+	//   description: csv (default), ndjson or xlsx
+	//   type: string
+	// - name: async
+	//   in: query
+	//   description: queue the export as a job instead of streaming it
+	//   type: boolean
+	// responses:
+	//   '200':
+	//     description: export file
+	//   '202':
+	//     description: export job queued
+	//   '400':
+	//     description: bad request
+	//   '500':
+	//     description: internal server error
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	format := request.FormValue("format")
+	if format == "" {
+		format = "csv"
+	}
+	mediaType, ok := exportFormats[format]
+	if !ok {
+		writeError(response, request, http.StatusBadRequest, fmt.Errorf("unknown format %q", format))
+		return
+	}
+	query, err := listQuery(request)
+	if err != nil {
+		writeError(response, request, http.StatusBadRequest, err)
+		return
+	}
+	if async, _ := strconv.ParseBool(request.FormValue("async")); async {
+		submitJob(response, request, jobExport)
+		return
+	}
+
+	it, err := store.Iter(request.Context(), query)
+	if err != nil {
+		writeError(response, request, http.StatusInternalServerError, err)
+		return
+	}
+	// Full extracts take longer than the server write timeout allows, so
+	// the deadline only bounds each write, as for import reports: a client
+	// that stops reading is cut off after the import idle timeout.
+	rc := http.NewResponseController(response)
+	rc.SetWriteDeadline(time.Time{})
+	w, err := newExportWriter(format, idleWriter{w: response, rc: rc, idle: importConfig.IdleTimeout})
+	if err != nil {
+		it.Close()
+		writeError(response, request, http.StatusInternalServerError, err)
+		return
+	}
+
+	filename := "employees-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	response.Header().Set("content-type", mediaType)
+	response.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	if err := writeExport(it, w, exportColumns(query), func(int) {}); err != nil {
+		// The status is already sent; dropping the connection keeps a
+		// truncated file from passing as complete.
+		logger.ErrorContext(request.Context(), "export failed", "err", err, "request_id", requestIDFrom(request.Context()))
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestExportEmployees(t *testing.T) {
+	mem := useMemoryStore(t)
+	ctx := context.Background()
+	aditi := Employee{Firstname: "aditi", Lastname: "patil", EmpID: 1200, Salary: 300.5, Practice: "IBM"}
+	ravi := Employee{Firstname: "ravi", Lastname: "kumar", EmpID: 7, Salary: 100, Practice: "SAP"}
+	meera := Employee{Firstname: "meera", Lastname: "rao", EmpID: 42, Salary: 200, Practice: "IBM"}
+	for _, e := range []*Employee{&aditi, &ravi, &meera} {
+		mem.Insert(ctx, e)
+	}
+
+	export := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/employees/export?"+query, nil)
+		rr := httptest.NewRecorder()
+		ExportEmployeesEndpoint(rr, req)
+		return rr
+	}
+
+	t.Run("it writes CSV by default", func(t *testing.T) {
+		rr := export("practice=IBM&sort=salary")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+		assert.Regexp(t, `^attachment; filename="employees-\d{8}-\d{6}\.csv"$`, rr.Header().Get("Content-Disposition"))
+		assert.Equal(t, "_id,firstname,lastname,empid,salary,practice\n"+
+			meera.ID.Hex()+",meera,rao,42,200,IBM\n"+
+			aditi.ID.Hex()+",aditi,patil,1200,300.5,IBM\n", rr.Body.String())
+	})
+
+	t.Run("it selects fields", func(t *testing.T) {
+		rr := export("fields=empid,firstname&empid=7")
+		assert.Equal(t, "_id,empid,firstname\n"+ravi.ID.Hex()+",7,ravi\n", rr.Body.String())
+	})
+
+	t.Run("it writes NDJSON", func(t *testing.T) {
+		rr := export("format=ndjson")
+		assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+		var exported []Employee
+		scanner := bufio.NewScanner(rr.Body)
+		for scanner.Scan() {
+			var e Employee
+			json.Unmarshal(scanner.Bytes(), &e)
+			exported = append(exported, e)
+		}
+		assert.Equal(t, []Employee{aditi, ravi, meera}, exported)
+	})
+
+	t.Run("it writes XLSX", func(t *testing.T) {
+		rr := export("format=xlsx&lastname=kumar")
+		assert.Equal(t, xlsxMediaType, rr.Header().Get("Content-Type"))
+		f, err := excelize.OpenReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, _ := f.GetRows("Sheet1")
+		assert.Equal(t, [][]string{
+			{"_id", "firstname", "lastname", "empid", "salary", "practice"},
+			{ravi.ID.Hex(), "ravi", "kumar", "7", "100", "SAP"},
+		}, rows)
+	})
+
+	t.Run("it quotes text spreadsheets would run as formulas", func(t *testing.T) {
+		formula := Employee{Firstname: "=HYPERLINK(\"http://evil\")", Lastname: "@sum", EmpID: -5, Practice: "-1+1"}
+		mem.Insert(ctx, &formula)
+		rr := export("empid=-5&fields=firstname,lastname,empid,practice")
+		assert.Equal(t, "_id,firstname,lastname,empid,practice\n"+
+			formula.ID.Hex()+`,"'=HYPERLINK(""http://evil"")",'@sum,-5,'-1+1`+"\n", rr.Body.String())
+
+		rr = export("format=xlsx&empid=-5&fields=firstname,empid")
+		f, err := excelize.OpenReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, _ := f.GetRows("Sheet1")
+		assert.Equal(t, []string{formula.ID.Hex(), `'=HYPERLINK("http://evil")`, "-5"}, rows[1])
+
+		rr = export("format=ndjson&empid=-5")
+		assert.Contains(t, rr.Body.String(), `"firstname":"=HYPERLINK`, "NDJSON is not a spreadsheet format")
+	})
+
+	t.Run("it rejects unknown formats", func(t *testing.T) {
+		rr := export("format=pdf")
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusBadRequest)
+		}
+		assert.True(t, strings.Contains(rr.Body.String(), "unknown format"))
+	})
+
+	t.Run("it cuts off clients that stop reading", func(t *testing.T) {
+		saved := importConfig
+		defer func() { importConfig = saved }()
+		importConfig.IdleTimeout = 50 * time.Millisecond
+		for i := 0; i < 2000; i++ {
+			mem.Insert(ctx, &Employee{Firstname: "bulk", Lastname: strings.Repeat("x", 8<<10), EmpID: 100000 + i})
+		}
+		done := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			defer close(done)
+			defer func() { recover() }()
+			ExportEmployeesEndpoint(response, request)
+		}))
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "GET /employees/export?format=ndjson HTTP/1.1\r\nHost: export\r\n\r\n")
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("the export kept writing to a client that stopped reading")
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative employeepb/employee.proto
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/shubhamkhanna/mux_crud/employeepb"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Binary and YAML codecs. They encode employees through employeeWire so that
+// every format carries the id as a hex string, like JSON and XML.
+var (
+	yamlCodec = Codec{
+		MediaType: "application/yaml",
+		Aliases:   []string{"application/x-yaml", "text/yaml"},
+		Marshal:   wireMarshal(yaml.Marshal),
+		Unmarshal: wireUnmarshal(yaml.Unmarshal),
+	}
+	msgpackCodec = Codec{
+		MediaType: "application/msgpack",
+		Aliases:   []string{"application/x-msgpack"},
+		Marshal:   wireMarshal(msgpackMarshal),
+		Unmarshal: wireUnmarshal(msgpackUnmarshal),
+	}
+	cborCodec = Codec{
+		MediaType: "application/cbor",
+		Marshal:   wireMarshal(cbor.Marshal),
+		Unmarshal: wireUnmarshal(cbor.Unmarshal),
+	}
+	protobufCodec = Codec{
+		MediaType: "application/x-protobuf",
+		Aliases:   []string{"application/protobuf"},
+		Marshal:   protobufMarshal,
+		Unmarshal: protobufUnmarshal,
+	}
+)
+
+func init() {
+	registerCodec(yamlCodec)
+	registerCodec(msgpackCodec)
+	registerCodec(cborCodec)
+	registerCodec(protobufCodec)
+}
+
+// msgpackMarshal reuses the json struct tags so that field names match the
+// JSON representation.
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func msgpackUnmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func employeeToProto(e Employee) *employeepb.Employee {
+	return &employeepb.Employee{
+		Id:        e.ID.Hex(),
+		Firstname: e.Firstname,
+		Lastname:  e.Lastname,
+		Empid:     int64(e.EmpID),
+		Salary:    e.Salary,
+		Practice:  e.Practice,
+	}
+}
+
+func employeeFromProto(m *employeepb.Employee) (Employee, error) {
+	return employeeWire{
+		ID:        m.GetId(),
+		Firstname: m.GetFirstname(),
+		Lastname:  m.GetLastname(),
+		EmpID:     int(m.GetEmpid()),
+		Salary:    m.GetSalary(),
+		Practice:  m.GetPractice(),
+	}.employee()
+}
+
+func collectionToProto(c EmployeeCollection) *employeepb.EmployeeCollection {
+	m := &employeepb.EmployeeCollection{Count: int64(c.Count)}
+	for _, e := range c.AllEmployees {
+		m.Employees = append(m.Employees, employeeToProto(e))
+	}
+	return m
+}
+
+// toProto maps the response bodies of the API to their protobuf messages.
+func toProto(v interface{}) (proto.Message, error) {
+	switch v := v.(type) {
+	case Employee:
+		return employeeToProto(v), nil
+	case *Employee:
+		return employeeToProto(*v), nil
+	case EmployeeCollection:
+		return collectionToProto(v), nil
+	case *EmployeeCollection:
+		return collectionToProto(*v), nil
+	case errorEnvelope:
+		return &employeepb.Error{Message: v.Message, RequestId: v.RequestID}, nil
+	case proto.Message:
+		return v, nil
+	}
+	return nil, fmt.Errorf("protobuf: cannot encode %T", v)
+}
+
+func protobufMarshal(v interface{}) ([]byte, error) {
+	m, err := toProto(v)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+func protobufUnmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *Employee:
+		var m employeepb.Employee
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		e, err := employeeFromProto(&m)
+		*v = e
+		return err
+	case *EmployeeCollection:
+		var m employeepb.EmployeeCollection
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		c := EmployeeCollection{Count: int(m.GetCount())}
+		for _, em := range m.GetEmployees() {
+			e, err := employeeFromProto(em)
+			if err != nil {
+				return err
+			}
+			c.AllEmployees = append(c.AllEmployees, e)
+		}
+		*v = c
+		return nil
+	case *errorEnvelope:
+		var m employeepb.Error
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*v = errorEnvelope{Message: m.GetMessage(), RequestID: m.GetRequestId()}
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(data, v)
+	}
+	return fmt.Errorf("protobuf: cannot decode %T", v)
+}
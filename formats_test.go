@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shubhamkhanna/mux_crud/employeepb"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	employee := Employee{bson.NewObjectId(), "aditi", "patil", 1200, 20000.5, "IBM"}
+	collection := EmployeeCollection{AllEmployees: []Employee{employee, {Firstname: "ravi", EmpID: 7}}, Count: 2}
+
+	for _, codec := range codecs {
+		t.Run(codec.MediaType, func(t *testing.T) {
+			out, err := codec.Marshal(employee)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Employee
+			if err := codec.Unmarshal(out, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, employee, decoded)
+
+			out, err = codec.Marshal(collection)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decodedCollection EmployeeCollection
+			if err := codec.Unmarshal(out, &decodedCollection); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, collection.Count, decodedCollection.Count)
+			assert.Equal(t, collection.AllEmployees, decodedCollection.AllEmployees)
+
+			out, err = codec.Marshal(errorEnvelope{Message: "not found", RequestID: "abc"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var envelope errorEnvelope
+			if err := codec.Unmarshal(out, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "not found", envelope.Message)
+			assert.Equal(t, "abc", envelope.RequestID)
+		})
+	}
+}
+
+func TestCodecRejectsMalformedIDs(t *testing.T) {
+	malformed := []struct {
+		codec Codec
+		body  interface{}
+	}{
+		{yamlCodec, employeeWire{ID: "42"}},
+		{msgpackCodec, employeeWire{ID: "42"}},
+		{cborCodec, employeeWire{ID: "42"}},
+		{protobufCodec, &employeepb.Employee{Id: "42"}},
+	}
+	for _, m := range malformed {
+		codec := m.codec
+		out, err := codec.Marshal(m.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Employee
+		assert.Error(t, codec.Unmarshal(out, &decoded), codec.MediaType)
+	}
+}
+
+func TestNegotiatesAdditionalFormats(t *testing.T) {
+	saved, ready := store, storeReady.Load()
+	store = stubStore{}
+	storeReady.Store(true)
+	defer func() {
+		store = saved
+		storeReady.Store(ready)
+	}()
+
+	for _, accept := range []string{"application/yaml", "application/x-msgpack", "application/cbor", "application/protobuf"} {
+		req, _ := http.NewRequest("GET", "/employees", nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		GetEmployeesEndpoint(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+		codec, _ := codecFor(accept)
+		assert.Equal(t, codec.MediaType, rr.Header().Get("Content-Type"))
+	}
+}
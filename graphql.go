@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	gqlast "github.com/vektah/gqlparser/v2/ast"
+	gqlparser "github.com/vektah/gqlparser/v2/parser"
+)
+
+// GraphQLConfig limits what a single GraphQL query may ask for.
+type GraphQLConfig struct {
+	MaxDepth      int  `yaml:"max_depth" toml:"max_depth"`
+	MaxComplexity int  `yaml:"max_complexity" toml:"max_complexity"`
+	GraphiQL      bool `yaml:"graphiql" toml:"graphiql"`
+}
+
+// graphqlConfig is installed by main before DefineRoute.
+var graphqlConfig = defaultConfig().GraphQL
+
+const (
+	graphqlPageSize    = 20
+	graphqlMaxPageSize = 100
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	employee(id: ID!): Employee
+	employeeByEmpid(empid: Int!): Employee
+	# employees pages through employees, 20 at a time unless first says
+	# otherwise (at most 100).
+	employees(first: Int, after: String, filter: EmployeeFilter, sort: EmployeeSort): EmployeeConnection!
+}
+
+type Mutation {
+	createEmployee(input: EmployeeInput!): Employee!
+	# updateEmployee changes the fields given in input.
+	updateEmployee(id: ID!, input: EmployeeInput!): Employee!
+	deleteEmployee(id: ID!): ID!
+}
+
+type Employee {
+	id: ID!
+	firstname: String!
+	lastname: String!
+	empid: Int!
+	salary: Float!
+	practice: String!
+}
+
+input EmployeeInput {
+	firstname: String
+	lastname: String
+	empid: Int
+	salary: Float
+	practice: String
+}
+
+input EmployeeFilter {
+	practice: String
+	lastname: String
+}
+
+input EmployeeSort {
+	field: EmployeeSortField!
+	direction: SortDirection
+}
+
+enum EmployeeSortField {
+	FIRSTNAME
+	LASTNAME
+	EMPID
+	SALARY
+	PRACTICE
+}
+
+enum SortDirection {
+	ASC
+	DESC
+}
+
+type EmployeeConnection {
+	edges: [EmployeeEdge!]!
+	pageInfo: PageInfo!
+}
+
+type EmployeeEdge {
+	cursor: String!
+	node: Employee!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	hasPreviousPage: Boolean!
+	startCursor: String
+	endCursor: String
+}
+`
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// graphqlResolver resolves the Query and Mutation fields on store.
+type graphqlResolver struct{}
+
+type employeeResolver struct {
+	e Employee
+}
+
+func (r employeeResolver) ID() graphql.ID    { return graphql.ID(r.e.ID.Hex()) }
+func (r employeeResolver) Firstname() string { return r.e.Firstname }
+func (r employeeResolver) Lastname() string  { return r.e.Lastname }
+func (r employeeResolver) Salary() float64   { return r.e.Salary }
+func (r employeeResolver) Practice() string  { return r.e.Practice }
+
+// Empid fails for ids stored through the other APIs that a GraphQL Int,
+// which is 32-bit, cannot hold, rather than truncate them.
+func (r employeeResolver) Empid() (int32, error) {
+	if r.e.EmpID < math.MinInt32 || r.e.EmpID > math.MaxInt32 {
+		return 0, fmt.Errorf("empid %d does not fit a GraphQL Int", r.e.EmpID)
+	}
+	return int32(r.e.EmpID), nil
+}
+
+type employeeInput struct {
+	Firstname *string
+	Lastname  *string
+	Empid     *int32
+	Salary    *float64
+	Practice  *string
+}
+
+// employee returns the fields set in the input; the rest stay zero and so
+// are left alone by store.Update.
+func (in employeeInput) employee() Employee {
+	var e Employee
+	if in.Firstname != nil {
+		e.Firstname = *in.Firstname
+	}
+	if in.Lastname != nil {
+		e.Lastname = *in.Lastname
+	}
+	if in.Empid != nil {
+		e.EmpID = int(*in.Empid)
+	}
+	if in.Salary != nil {
+		e.Salary = *in.Salary
+	}
+	if in.Practice != nil {
+		e.Practice = *in.Practice
+	}
+	return e
+}
+
+type employeeFilter struct {
+	Practice *string
+	Lastname *string
+}
+
+type employeeSort struct {
+	Field     string
+	Direction *string
+}
+
+func (graphqlResolver) Employee(ctx context.Context, args struct{ ID graphql.ID }) (*employeeResolver, error) {
+	e, err := store.FindByID(ctx, string(args.ID))
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &employeeResolver{e}, nil
+}
+
+func (graphqlResolver) EmployeeByEmpid(ctx context.Context, args struct{ Empid int32 }) (*employeeResolver, error) {
+	if args.Empid == 0 {
+		return nil, nil
+	}
+	employees, err := store.Find(ctx, EmployeeQuery{EmpID: int(args.Empid), Limit: 1})
+	if err != nil || len(employees) == 0 {
+		return nil, err
+	}
+	return &employeeResolver{employees[0]}, nil
+}
+
+type employeesArgs struct {
+	First  *int32
+	After  *string
+	Filter *employeeFilter
+	Sort   *employeeSort
+}
+
+func (graphqlResolver) Employees(ctx context.Context, args employeesArgs) (employeeConnection, error) {
+	first := graphqlPageSize
+	if args.First != nil {
+		first = int(*args.First)
+	}
+	if first < 0 || first > graphqlMaxPageSize {
+		return employeeConnection{}, fmt.Errorf("first must be between 0 and %d", graphqlMaxPageSize)
+	}
+	offset := 0
+	if args.After != nil {
+		after, err := decodeCursor(*args.After)
+		if err != nil {
+			return employeeConnection{}, err
+		}
+		offset = after + 1
+	}
+
+	// One extra employee tells whether there is a next page.
+	query := EmployeeQuery{Skip: offset, Limit: first + 1}
+	if args.Filter != nil {
+		if args.Filter.Practice != nil {
+			query.Practice = *args.Filter.Practice
+		}
+		if args.Filter.Lastname != nil {
+			query.Lastname = *args.Filter.Lastname
+		}
+	}
+	if args.Sort != nil {
+		query.Sort = strings.ToLower(args.Sort.Field)
+		if args.Sort.Direction != nil && *args.Sort.Direction == "DESC" {
+			query.Sort = "-" + query.Sort
+		}
+	}
+	employees, err := store.Find(ctx, query)
+	if err != nil {
+		return employeeConnection{}, err
+	}
+	c := employeeConnection{offset: offset, hasNext: len(employees) > first}
+	if c.hasNext {
+		employees = employees[:first]
+	}
+	for i, e := range employees {
+		c.edges = append(c.edges, employeeEdge{cursor: encodeCursor(offset + i), node: e})
+	}
+	return c, nil
+}
+
+func (graphqlResolver) CreateEmployee(ctx context.Context, args struct{ Input employeeInput }) (employeeResolver, error) {
+	e := args.Input.employee()
+	err := store.Insert(ctx, &e)
+	return employeeResolver{e}, err
+}
+
+func (graphqlResolver) UpdateEmployee(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input employeeInput
+}) (employeeResolver, error) {
+	if err := store.Update(ctx, string(args.ID), args.Input.employee()); err != nil {
+		return employeeResolver{}, err
+	}
+	e, err := store.FindByID(ctx, string(args.ID))
+	return employeeResolver{e}, err
+}
+
+func (graphqlResolver) DeleteEmployee(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	return args.ID, store.Remove(ctx, string(args.ID))
+}
+
+// employeeConnection is a page of employees in the Relay connection shape.
+// Cursors encode the offset of an employee in the result.
+type employeeConnection struct {
+	edges   []employeeEdge
+	offset  int
+	hasNext bool
+}
+
+type employeeEdge struct {
+	cursor string
+	node   Employee
+}
+
+func (c employeeConnection) Edges() []employeeEdge { return c.edges }
+
+func (c employeeConnection) PageInfo() pageInfo {
+	p := pageInfo{hasNext: c.hasNext, hasPrevious: c.offset > 0}
+	if len(c.edges) > 0 {
+		p.start, p.end = &c.edges[0].cursor, &c.edges[len(c.edges)-1].cursor
+	}
+	return p
+}
+
+func (e employeeEdge) Cursor() string         { return e.cursor }
+func (e employeeEdge) Node() employeeResolver { return employeeResolver{e.node} }
+
+type pageInfo struct {
+	hasNext, hasPrevious bool
+	start, end           *string
+}
+
+func (p pageInfo) HasNextPage() bool     { return p.hasNext }
+func (p pageInfo) HasPreviousPage() bool { return p.hasPrevious }
+func (p pageInfo) StartCursor() *string  { return p.start }
+func (p pageInfo) EndCursor() *string    { return p.end }
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), "offset:"))
+	if err != nil || offset < 0 || !strings.HasPrefix(string(raw), "offset:") {
+		return 0, errInvalidCursor
+	}
+	return offset, nil
+}
+
+// queryComplexity estimates how many fields a selection resolves. Every field
+// costs one and a "first" argument multiplies the cost of the selections
+// below it.
+func queryComplexity(doc *gqlast.QueryDocument, set gqlast.SelectionSet, vars map[string]interface{}, visiting map[string]bool) float64 {
+	total := 0.0
+	for _, selection := range set {
+		switch selection := selection.(type) {
+		case *gqlast.Field:
+			total++
+			if len(selection.SelectionSet) > 0 {
+				total += pageSizeOf(selection, vars) * queryComplexity(doc, selection.SelectionSet, vars, visiting)
+			}
+		case *gqlast.InlineFragment:
+			total += queryComplexity(doc, selection.SelectionSet, vars, visiting)
+		case *gqlast.FragmentSpread:
+			fragment := doc.Fragments.ForName(selection.Name)
+			if fragment == nil || visiting[selection.Name] {
+				continue
+			}
+			visiting[selection.Name] = true
+			total += queryComplexity(doc, fragment.SelectionSet, vars, visiting)
+			delete(visiting, selection.Name)
+		}
+	}
+	return total
+}
+
+// pageSizeOf is how many items field resolves: its "first" argument, or the
+// default page size for the employees connection.
+func pageSizeOf(field *gqlast.Field, vars map[string]interface{}) float64 {
+	arg := field.Arguments.ForName("first")
+	if arg == nil {
+		if field.Name == "employees" {
+			return graphqlPageSize
+		}
+		return 1
+	}
+	value, _ := arg.Value.Value(vars)
+	switch n := value.(type) {
+	case int64:
+		return math.Max(0, float64(n))
+	case float64:
+		return math.Max(0, n)
+	}
+	return graphqlPageSize
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// checkOperation rejects mutations sent with GET and queries over the
+// complexity limit. Queries it cannot parse are rejected too, as their
+// complexity is unknown.
+func checkOperation(req graphqlRequest, method string, maxComplexity int) (int, *gqlerrors.QueryError) {
+	doc, err := gqlparser.ParseQuery(&gqlast.Source{Input: req.Query})
+	if err != nil {
+		return http.StatusOK, gqlerrors.Errorf("%s", err)
+	}
+	for _, op := range doc.Operations {
+		if req.OperationName != "" && op.Name != req.OperationName {
+			continue
+		}
+		if op.Operation != gqlast.Query && method == http.MethodGet {
+			return http.StatusMethodNotAllowed, gqlerrors.Errorf("%s operations must be sent with POST", op.Operation)
+		}
+		complexity := queryComplexity(doc, op.SelectionSet, req.Variables, map[string]bool{})
+		if complexity > float64(maxComplexity) {
+			return http.StatusOK, gqlerrors.Errorf("query complexity %.0f exceeds the limit of %d", complexity, maxComplexity)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// graphqlHandler serves the GraphQL API with the limits of cfg. Queries may
+// use GET or POST, mutations only POST.
+func graphqlHandler(cfg GraphQLConfig) http.HandlerFunc {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{}, graphql.MaxDepth(cfg.MaxDepth))
+	return func(response http.ResponseWriter, request *http.Request) {
+		var req graphqlRequest
+		if request.Method == http.MethodGet {
+			req.Query = request.FormValue("query")
+			req.OperationName = request.FormValue("operationName")
+			if variables := request.FormValue("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					writeError(response, request, http.StatusBadRequest, err)
+					return
+				}
+			}
+		} else if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			writeError(response, request, http.StatusBadRequest, err)
+			return
+		}
+		if req.Query == "" {
+			writeError(response, request, http.StatusBadRequest, errors.New("missing query"))
+			return
+		}
+
+		result := &graphql.Response{}
+		status, qerr := checkOperation(req, request.Method, cfg.MaxComplexity)
+		if qerr != nil {
+			result.Errors = []*gqlerrors.QueryError{qerr}
+		} else {
+			result = schema.Exec(request.Context(), req.Query, req.OperationName, req.Variables)
+		}
+		body, err := json.Marshal(result)
+		if err != nil {
+			writeError(response, request, http.StatusInternalServerError, err)
+			return
+		}
+		setResponseHeader(response)
+		response.WriteHeader(status)
+		response.Write(body)
+	}
+}
+
+// GraphiQLEndpoint serves an in-browser IDE for /graphql. It is only routed
+// when GraphiQL is enabled, which should not be done in production.
+func GraphiQLEndpoint(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("content-type", "text/html; charset=utf-8")
+	response.Write([]byte(graphiqlPage))
+}
+
+const graphiqlPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>GraphiQL</title>
+	<link rel="stylesheet" href="https://unpkg.com/graphiql@3/graphiql.min.css">
+</head>
+<body style="margin: 0">
+	<div id="graphiql" style="height: 100vh"></div>
+	<script src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+	<script src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+	<script src="https://unpkg.com/graphiql@3/graphiql.min.js"></script>
+	<script>
+		const fetcher = GraphiQL.createFetcher({ url: "/graphql" });
+		ReactDOM.createRoot(document.getElementById("graphiql")).render(React.createElement(GraphiQL, { fetcher }));
+	</script>
+</body>
+</html>
+`
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type graphqlResult struct {
+	Data   json.RawMessage
+	Errors []struct{ Message string }
+}
+
+func execGraphQL(t *testing.T, cfg GraphQLConfig, query string, variables map[string]interface{}) graphqlResult {
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	req, _ := http.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	graphqlHandler(cfg)(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var result graphqlResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestGraphQL(t *testing.T) {
+	useMemoryStore(t)
+	cfg := defaultConfig().GraphQL
+
+	var ids []string
+	for _, input := range []string{
+		`{firstname: "aditi", lastname: "patil", empid: 1200, salary: 300, practice: "IBM"}`,
+		`{firstname: "ravi", lastname: "patil", empid: 7, salary: 100, practice: "SAP"}`,
+		`{firstname: "meera", lastname: "rao", empid: 42, salary: 200, practice: "IBM"}`,
+	} {
+		result := execGraphQL(t, cfg, `mutation { createEmployee(input: `+input+`) { id } }`, nil)
+		assert.Empty(t, result.Errors)
+		var data struct{ CreateEmployee struct{ ID string } }
+		json.Unmarshal(result.Data, &data)
+		ids = append(ids, data.CreateEmployee.ID)
+	}
+
+	t.Run("it reads by id and empid", func(t *testing.T) {
+		result := execGraphQL(t, cfg, `query($id: ID!) {
+			byID: employee(id: $id) { firstname practice }
+			byEmpid: employeeByEmpid(empid: 42) { id salary }
+			missing: employee(id: "000000000000000000000000") { id }
+		}`, map[string]interface{}{"id": ids[0]})
+		assert.Empty(t, result.Errors)
+		assert.JSONEq(t, `{
+			"byID": {"firstname": "aditi", "practice": "IBM"},
+			"byEmpid": {"id": "`+ids[2]+`", "salary": 200},
+			"missing": null
+		}`, string(result.Data))
+	})
+
+	t.Run("it pages filtered and sorted lists", func(t *testing.T) {
+		query := `query($after: String) {
+			employees(first: 1, after: $after, filter: {practice: "IBM"}, sort: {field: SALARY, direction: DESC}) {
+				edges { cursor node { firstname } }
+				pageInfo { hasNextPage hasPreviousPage endCursor }
+			}
+		}`
+		var page struct {
+			Employees struct {
+				Edges []struct {
+					Node struct{ Firstname string }
+				}
+				PageInfo struct {
+					HasNextPage, HasPreviousPage bool
+					EndCursor                    string
+				}
+			}
+		}
+		result := execGraphQL(t, cfg, query, nil)
+		assert.Empty(t, result.Errors)
+		json.Unmarshal(result.Data, &page)
+		assert.Equal(t, "aditi", page.Employees.Edges[0].Node.Firstname)
+		assert.True(t, page.Employees.PageInfo.HasNextPage)
+		assert.False(t, page.Employees.PageInfo.HasPreviousPage)
+
+		result = execGraphQL(t, cfg, query, map[string]interface{}{"after": page.Employees.PageInfo.EndCursor})
+		assert.Empty(t, result.Errors)
+		json.Unmarshal(result.Data, &page)
+		assert.Equal(t, "meera", page.Employees.Edges[0].Node.Firstname)
+		assert.False(t, page.Employees.PageInfo.HasNextPage)
+		assert.True(t, page.Employees.PageInfo.HasPreviousPage)
+	})
+
+	t.Run("it rejects invalid cursors", func(t *testing.T) {
+		result := execGraphQL(t, cfg, `{ employees(after: "bogus") { edges { cursor } } }`, nil)
+		assert.Len(t, result.Errors, 1)
+	})
+
+	t.Run("it updates and deletes", func(t *testing.T) {
+		result := execGraphQL(t, cfg, `mutation($id: ID!) {
+			updateEmployee(id: $id, input: {salary: 500}) { firstname salary }
+		}`, map[string]interface{}{"id": ids[1]})
+		assert.Empty(t, result.Errors)
+		assert.JSONEq(t, `{"updateEmployee": {"firstname": "ravi", "salary": 500}}`, string(result.Data))
+
+		result = execGraphQL(t, cfg, `mutation($id: ID!) { deleteEmployee(id: $id) }`, map[string]interface{}{"id": ids[1]})
+		assert.Empty(t, result.Errors)
+		result = execGraphQL(t, cfg, `mutation($id: ID!) { deleteEmployee(id: $id) }`, map[string]interface{}{"id": ids[1]})
+		assert.Equal(t, "not found", result.Errors[0].Message)
+	})
+
+	t.Run("it limits depth and complexity", func(t *testing.T) {
+		result := execGraphQL(t, GraphQLConfig{MaxDepth: 2, MaxComplexity: 1000}, `{ employees { edges { node { id } } } }`, nil)
+		assert.NotEmpty(t, result.Errors)
+
+		query := `{ employees(first: $n) { edges { node { id firstname } } } }`
+		query = "query($n: Int) " + query
+		result = execGraphQL(t, GraphQLConfig{MaxDepth: 8, MaxComplexity: 100}, query, map[string]interface{}{"n": 50})
+		assert.Contains(t, result.Errors[0].Message, "complexity 201 exceeds the limit of 100")
+		result = execGraphQL(t, GraphQLConfig{MaxDepth: 8, MaxComplexity: 100}, query, map[string]interface{}{"n": 10})
+		assert.Empty(t, result.Errors)
+
+		result = execGraphQL(t, GraphQLConfig{MaxDepth: 8, MaxComplexity: 50}, `{ employees { edges { node { id firstname } } } }`, nil)
+		if assert.NotEmpty(t, result.Errors, "a missing first counts as the default page size") {
+			assert.Contains(t, result.Errors[0].Message, "complexity 81 exceeds the limit of 50")
+		}
+	})
+
+	t.Run("it rejects queries it cannot parse", func(t *testing.T) {
+		result := execGraphQL(t, cfg, `{ employees { edges { node { id } } }`, nil)
+		if assert.Len(t, result.Errors, 1) {
+			assert.Contains(t, result.Errors[0].Message, "Expected Name")
+		}
+		assert.Empty(t, result.Data, "nothing is executed")
+	})
+
+	t.Run("it does not truncate empids", func(t *testing.T) {
+		big := Employee{Firstname: "big", EmpID: math.MaxInt32 + 1}
+		store.Insert(context.Background(), &big)
+		result := execGraphQL(t, cfg, `query($id: ID!) { employee(id: $id) { empid } }`, map[string]interface{}{"id": big.ID.Hex()})
+		if assert.Len(t, result.Errors, 1) {
+			assert.Equal(t, "empid 2147483648 does not fit a GraphQL Int", result.Errors[0].Message)
+		}
+	})
+
+	t.Run("it refuses mutations over GET", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/graphql?query="+url.QueryEscape(`mutation { deleteEmployee(id: "x") }`), nil)
+		rr := httptest.NewRecorder()
+		graphqlHandler(cfg)(rr, req)
+		if status := rr.Code; status != http.StatusMethodNotAllowed {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestGraphiQLEndpoint(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/graphiql", nil)
+	rr := httptest.NewRecorder()
+	GraphiQLEndpoint(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `url: "/graphql"`)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/shubhamkhanna/mux_crud/employeepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// watchBuffer is how many events a WatchEmployees stream may fall behind
+// before it is ended.
+const watchBuffer = 64
+
+// employeeService implements the gRPC EmployeeService on the same store as
+// the REST endpoints. Watch streams end when done is closed.
+type employeeService struct {
+	employeepb.UnimplementedEmployeeServiceServer
+	done <-chan struct{}
+}
+
+// grpcError maps store errors to gRPC status codes.
+func grpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errEmployeeExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	logger.Error(err.Error(), "protocol", "grpc")
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s employeeService) CreateEmployee(ctx context.Context, req *employeepb.CreateEmployeeRequest) (*employeepb.Employee, error) {
+	employee, err := employeeFromProto(req.GetEmployee())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := store.Insert(ctx, &employee); err != nil {
+		return nil, grpcError(err)
+	}
+	return employeeToProto(employee), nil
+}
+
+func (s employeeService) GetEmployee(ctx context.Context, req *employeepb.GetEmployeeRequest) (*employeepb.Employee, error) {
+	employee, err := store.FindByID(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return employeeToProto(employee), nil
+}
+
+func (s employeeService) ListEmployees(ctx context.Context, req *employeepb.ListEmployeesRequest) (*employeepb.EmployeeCollection, error) {
+	query := pageQuery(int(req.GetLimit()), int(req.GetPage()))
+	query.Practice = req.GetPractice()
+	query.Lastname = req.GetLastname()
+	query.EmpID = int(req.GetEmpid())
+	query.Sort = req.GetSort()
+	if err := checkSort(query.Sort); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	employees, err := store.Find(ctx, query)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return collectionToProto(EmployeeCollection{AllEmployees: employees, Count: len(employees)}), nil
+}
+
+func (s employeeService) UpdateEmployee(ctx context.Context, req *employeepb.UpdateEmployeeRequest) (*employeepb.Employee, error) {
+	employee, err := employeeFromProto(req.GetEmployee())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	employee.ID = ""
+	if err := store.Update(ctx, req.GetId(), employee); err != nil {
+		return nil, grpcError(err)
+	}
+	updated, err := store.FindByID(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return employeeToProto(updated), nil
+}
+
+func (s employeeService) DeleteEmployee(ctx context.Context, req *employeepb.DeleteEmployeeRequest) (*employeepb.DeleteEmployeeResponse, error) {
+	if err := store.Remove(ctx, req.GetId()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &employeepb.DeleteEmployeeResponse{}, nil
+}
+
+func (s employeeService) WatchEmployees(req *employeepb.WatchEmployeesRequest, stream grpc.ServerStreamingServer[employeepb.EmployeeEvent]) error {
+	ch, unsubscribe := events.Subscribe(watchBuffer)
+	defer unsubscribe()
+	// Headers tell the client that no later change will be missed.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.done:
+			return status.Error(codes.Unavailable, errDraining.Error())
+		case event, ok := <-ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watch fell behind")
+			}
+			if req.GetPractice() != "" && event.Employee.Practice != req.GetPractice() {
+				continue
+			}
+			err := stream.Send(&employeepb.EmployeeEvent{Type: event.Type, Employee: employeeToProto(event.Employee)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// storeUnaryInterceptor answers Unavailable until the store is connected,
+// like requireStore does for the REST endpoints.
+func storeUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !storeReady.Load() {
+		return nil, status.Error(codes.Unavailable, errStoreUnavailable.Error())
+	}
+	return handler(ctx, req)
+}
+
+func storeStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !storeReady.Load() {
+		return status.Error(codes.Unavailable, errStoreUnavailable.Error())
+	}
+	return handler(srv, stream)
+}
+
+// newGRPCServer returns a server for EmployeeService, using TLS when
+// tlsConfig is set. tlsConfig must offer "h2" over ALPN.
+func newGRPCServer(tlsConfig *tls.Config, done <-chan struct{}) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(storeUnaryInterceptor),
+		grpc.ChainStreamInterceptor(storeStreamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	employeepb.RegisterEmployeeServiceServer(server, employeeService{done: done})
+	return server
+}
+
+// serveGRPC runs server on listener until ctx is cancelled, then lets
+// in-flight calls finish for up to shutdownTimeout.
+func serveGRPC(ctx context.Context, server *grpc.Server, listener net.Listener, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		server.Stop()
+	}
+	return <-serveErr
+}
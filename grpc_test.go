@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shubhamkhanna/mux_crud/employeepb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCClient serves EmployeeService in memory and returns a client.
+func newGRPCClient(t *testing.T) employeepb.EmployeeServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	done := make(chan struct{})
+	server := newGRPCServer(nil, done)
+	go server.Serve(listener)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		close(done)
+		server.Stop()
+	})
+	return employeepb.NewEmployeeServiceClient(conn)
+}
+
+// serveREST sends a JSON request to the employee endpoints.
+func serveREST(method, path string, body interface{}) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/employees", CreateEmployeeEndpoint).Methods("POST")
+	r.HandleFunc("/employees", GetEmployeesEndpoint).Methods("GET")
+	r.HandleFunc("/employee/{id}", GetEmployeeEndpoint).Methods("GET")
+	r.HandleFunc("/employee/{id}", UpdateEmployeeEndpoint).Methods("PUT")
+	r.HandleFunc("/employee/{id}", DeleteEmployeeEndpoint).Methods("DELETE")
+
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req, _ := http.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func fromProto(t *testing.T, m *employeepb.Employee) Employee {
+	e, err := employeeFromProto(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestGRPCParity(t *testing.T) {
+	useMemoryStore(t)
+	client := newGRPCClient(t)
+	ctx := context.Background()
+
+	t.Run("created over REST is read over gRPC", func(t *testing.T) {
+		rr := serveREST("POST", "/employees", Employee{Firstname: "aditi", Lastname: "patil", EmpID: 1200, Salary: 20000, Practice: "IBM"})
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var created Employee
+		json.Unmarshal(rr.Body.Bytes(), &created)
+
+		got, err := client.GetEmployee(ctx, &employeepb.GetEmployeeRequest{Id: created.ID.Hex()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, created, fromProto(t, got))
+	})
+
+	t.Run("created over gRPC is read over REST", func(t *testing.T) {
+		created, err := client.CreateEmployee(ctx, &employeepb.CreateEmployeeRequest{
+			Employee: &employeepb.Employee{Firstname: "ravi", Lastname: "patil", Empid: 7, Salary: 100, Practice: "SAP"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := serveREST("GET", "/employee/"+created.GetId(), nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var got Employee
+		json.Unmarshal(rr.Body.Bytes(), &got)
+		assert.Equal(t, fromProto(t, created), got)
+	})
+
+	t.Run("lists filter and page alike", func(t *testing.T) {
+		serveREST("POST", "/employees", Employee{Firstname: "meera", Lastname: "rao", Practice: "IBM"})
+		requests := map[string]*employeepb.ListEmployeesRequest{
+			"/employees":                             {},
+			"/employees?practice=IBM":                {Practice: "IBM"},
+			"/employees?lastname=patil":              {Lastname: "patil"},
+			"/employees?practice=IBM&limit=1&page=2": {Practice: "IBM", Limit: 1, Page: 2},
+			"/employees?empid=7":                     {Empid: 7},
+			"/employees?sort=-firstname&limit=2":     {Sort: "-firstname", Limit: 2},
+		}
+		for path, req := range requests {
+			rr := serveREST("GET", path, nil)
+			var rest EmployeeCollection
+			json.Unmarshal(rr.Body.Bytes(), &rest)
+
+			list, err := client.ListEmployees(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var grpcEmployees []Employee
+			for _, m := range list.GetEmployees() {
+				grpcEmployees = append(grpcEmployees, fromProto(t, m))
+			}
+			assert.Equal(t, rest.Count, int(list.GetCount()), path)
+			assert.Equal(t, rest.AllEmployees, grpcEmployees, path)
+		}
+	})
+
+	t.Run("updated over gRPC is read over REST", func(t *testing.T) {
+		list, _ := client.ListEmployees(ctx, &employeepb.ListEmployeesRequest{Lastname: "rao"})
+		id := list.GetEmployees()[0].GetId()
+		updated, err := client.UpdateEmployee(ctx, &employeepb.UpdateEmployeeRequest{Id: id, Employee: &employeepb.Employee{Salary: 5000}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := serveREST("GET", "/employee/"+id, nil)
+		var got Employee
+		json.Unmarshal(rr.Body.Bytes(), &got)
+		assert.Equal(t, 5000.0, got.Salary)
+		assert.Equal(t, "meera", got.Firstname)
+		assert.Equal(t, got, fromProto(t, updated), "the response is the stored employee")
+
+		_, err = client.UpdateEmployee(ctx, &employeepb.UpdateEmployeeRequest{Id: "000000000000000000000000", Employee: &employeepb.Employee{Salary: 1}})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("deleted over REST is gone over gRPC", func(t *testing.T) {
+		list, _ := client.ListEmployees(ctx, &employeepb.ListEmployeesRequest{Lastname: "rao"})
+		id := list.GetEmployees()[0].GetId()
+		rr := serveREST("DELETE", "/employee/"+id, nil)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+
+		_, err := client.GetEmployee(ctx, &employeepb.GetEmployeeRequest{Id: id})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		rr = serveREST("GET", "/employee/"+id, nil)
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("it rejects malformed ids", func(t *testing.T) {
+		_, err := client.CreateEmployee(ctx, &employeepb.CreateEmployeeRequest{Employee: &employeepb.Employee{Id: "42"}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("it rejects unknown sort fields", func(t *testing.T) {
+		_, err := client.ListEmployees(ctx, &employeepb.ListEmployeesRequest{Sort: "password"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("it reports taken ids", func(t *testing.T) {
+		created, _ := client.CreateEmployee(ctx, &employeepb.CreateEmployeeRequest{Employee: &employeepb.Employee{Firstname: "asha"}})
+		_, err := client.CreateEmployee(ctx, &employeepb.CreateEmployeeRequest{Employee: created})
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	})
+}
+
+func TestGRPCTLS(t *testing.T) {
+	useMemoryStore(t)
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	cfg := TLSConfig{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	}
+	certPEM, keyPEM := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, cfg.CertFile, certPEM, time.Now())
+	writeFile(t, cfg.KeyFile, keyPEM, time.Now())
+	reloader, err := newCertReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	server := newGRPCServer(reloader.tlsConfig("h2"), done)
+	go server.Serve(listener)
+	defer func() {
+		close(done)
+		server.Stop()
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	conn, err := grpc.NewClient(listener.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: roots})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = employeepb.NewEmployeeServiceClient(conn).ListEmployees(context.Background(), &employeepb.ListEmployeesRequest{})
+	assert.NoError(t, err, "the handshake negotiates h2")
+}
+
+func TestGRPCStoreUnavailable(t *testing.T) {
+	useMemoryStore(t)
+	storeReady.Store(false)
+	client := newGRPCClient(t)
+
+	_, err := client.GetEmployee(context.Background(), &employeepb.GetEmployeeRequest{Id: "000000000000000000000000"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestGRPCWatch(t *testing.T) {
+	useMemoryStore(t)
+	client := newGRPCClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchEmployees(ctx, &employeepb.WatchEmployeesRequest{Practice: "IBM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server sends headers once it has subscribed.
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	serveREST("POST", "/employees", Employee{Firstname: "ravi", Practice: "SAP"})
+	rr := serveREST("POST", "/employees", Employee{Firstname: "aditi", Practice: "IBM"})
+	var created Employee
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	serveREST("DELETE", "/employee/"+created.ID.Hex(), nil)
+
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, EventEmployeeCreated, event.GetType())
+	assert.Equal(t, created, fromProto(t, event.GetEmployee()))
+
+	event, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, EventEmployeeDeleted, event.GetType())
+	assert.Equal(t, created.ID.Hex(), event.GetEmployee().GetId())
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// healthCheck reports whether a dependency the API needs is usable.
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+var healthChecks []healthCheck
+
+// registerHealthCheck adds a dependency to /readyz and /health, replacing
+// the check registered under the same name.
+func registerHealthCheck(name string, check func(ctx context.Context) error) {
+	for i := range healthChecks {
+		if healthChecks[i].name == name {
+			healthChecks[i].check = check
+			return
+		}
+	}
+	healthChecks = append(healthChecks, healthCheck{name: name, check: check})
+}
+
+// registerStoreChecks adds the store, named after its backend, and its
+// schema, which is down while migrations are pending.
+func registerStoreChecks(backend string) {
+	registerHealthCheck(backend, func(ctx context.Context) error {
+		if !storeReady.Load() {
+			return errStoreUnavailable
+		}
+		return store.Ping(ctx)
+	})
+	registerHealthCheck("migrations", func(ctx context.Context) error {
+		if !storeReady.Load() {
+			if schemaPending.Load() {
+				return errMigrationsPending
+			}
+			return errStoreUnavailable
+		}
+		statuses, err := schema.status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if !s.Applied {
+				return errMigrationsPending
+			}
+		}
+		return nil
+	})
+}
+
+// checkResult is the status of one dependency.
+type checkResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// healthReport is the body of /health.
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// runHealthChecks runs every check concurrently, each bounded by
+// healthCheckTimeout.
+func runHealthChecks(ctx context.Context) healthReport {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	report := healthReport{Status: "up", Checks: make(map[string]checkResult, len(healthChecks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range healthChecks {
+		wg.Add(1)
+		go func(hc healthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := hc.check(ctx)
+			result := checkResult{Status: "up", Latency: time.Since(start).String()}
+			if err != nil {
+				result.Status = "down"
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[hc.name] = result
+			if err != nil {
+				report.Status = "down"
+			}
+		}(hc)
+	}
+	wg.Wait()
+	return report
+}
+
+// LivenessEndpoint reports that the process is serving requests.
+func LivenessEndpoint(response http.ResponseWriter, request *http.Request) {
+	setResponseHeader(response)
+	response.Write([]byte(`{"status":"up"}`))
+}
+
+// ReadinessEndpoint reports whether every dependency is usable.
+func ReadinessEndpoint(response http.ResponseWriter, request *http.Request) {
+	setResponseHeader(response)
+	if report := runHealthChecks(request.Context()); report.Status != "up" {
+		response.WriteHeader(http.StatusServiceUnavailable)
+		response.Write([]byte(`{"status":"down"}`))
+		return
+	}
+	response.Write([]byte(`{"status":"up"}`))
+}
+
+// HealthEndpoint reports the status of each dependency.
+func HealthEndpoint(response http.ResponseWriter, request *http.Request) {
+	setResponseHeader(response)
+	report := runHealthChecks(request.Context())
+	result, err := json.Marshal(report)
+	if err != nil {
+		writeError(response, request, http.StatusInternalServerError, err)
+		return
+	}
+	if report.Status != "up" {
+		response.WriteHeader(http.StatusServiceUnavailable)
+	}
+	response.Write(result)
+}
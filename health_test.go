@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	saved, ready, checks, savedSchema := store, storeReady.Load(), healthChecks, schema
+	defer func() {
+		store = saved
+		storeReady.Store(ready)
+		healthChecks = checks
+		schema = savedSchema
+		schemaPending.Store(false)
+	}()
+	healthChecks = append([]healthCheck(nil), checks...)
+	registerStoreChecks("sqlite")
+	m, _ := fakeMigrations(1)
+	assert.NoError(t, m.migrate(context.Background(), 1))
+	schema = m
+
+	storeReady.Store(false)
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	rr := httptest.NewRecorder()
+	ReadinessEndpoint(rr, req)
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusServiceUnavailable)
+	}
+
+	t.Run("it is alive without a store", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		LivenessEndpoint(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("it rejects employee requests without a store", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		requireStore(GetEmployeesEndpoint)(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	})
+
+	t.Run("it is ready once the store answers", func(t *testing.T) {
+		store = stubStore{}
+		storeReady.Store(true)
+		rr := httptest.NewRecorder()
+		ReadinessEndpoint(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("it reports each dependency", func(t *testing.T) {
+		store = stubStore{err: errors.New("no reachable servers")}
+		rr := httptest.NewRecorder()
+		HealthEndpoint(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		var report healthReport
+		json.Unmarshal(rr.Body.Bytes(), &report)
+		assert.Equal(t, "down", report.Checks["sqlite"].Status)
+		assert.Equal(t, "no reachable servers", report.Checks["sqlite"].Error)
+		assert.Equal(t, "up", report.Checks["migrations"].Status)
+	})
+
+	t.Run("it is not ready while migrations are pending", func(t *testing.T) {
+		store = stubStore{}
+		m, _ := fakeMigrations(1, 2)
+		assert.NoError(t, m.migrate(context.Background(), 1))
+		schema = m
+		rr := httptest.NewRecorder()
+		HealthEndpoint(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		var report healthReport
+		json.Unmarshal(rr.Body.Bytes(), &report)
+		assert.Equal(t, "up", report.Checks["sqlite"].Status)
+		assert.Equal(t, errMigrationsPending.Error(), report.Checks["migrations"].Error)
+
+		storeReady.Store(false)
+		schemaPending.Store(true)
+		rr = httptest.NewRecorder()
+		HealthEndpoint(rr, req)
+		json.Unmarshal(rr.Body.Bytes(), &report)
+		assert.Equal(t, errMigrationsPending.Error(), report.Checks["migrations"].Error, "before connecting too")
+	})
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	csvMediaType  = "text/csv"
+	xlsxMediaType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// ImportConfig bounds import uploads: bodies over MaxBytes are refused, and
+// an upload that sends nothing for IdleTimeout is cut off.
+type ImportConfig struct {
+	MaxBytes    int           `yaml:"max_bytes" toml:"max_bytes"`
+	IdleTimeout time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+}
+
+// importConfig is installed by main before DefineRoute.
+var importConfig = defaultConfig().Import
+
+// importMediaTypes are the spreadsheet formats an import accepts.
+var importMediaTypes = map[string]bool{
+	csvMediaType:      true,
+	"application/csv": true,
+	xlsxMediaType:     true,
+}
+
+// Import row actions.
+const (
+	importCreate = "create"
+	importUpdate = "update"
+)
+
+var (
+	errImportEmpID   = errors.New("empid is required")
+	errImportNoEmpID = errors.New("no column maps to empid")
+)
+
+// rowReader yields spreadsheet rows one at a time, returning io.EOF after
+// the last one.
+type rowReader interface {
+	Read() ([]string, error)
+}
+
+// idleReader reads an import body, moving the connection's deadlines idle
+// past every read so that large files may take as long as they need while
+// a stalled upload is still cut off. The write deadline moves too, as the
+// response is written while, or once, the body is read.
+type idleReader struct {
+	body io.Reader
+	rc   *http.ResponseController
+	idle time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	deadline := time.Now().Add(r.idle)
+	r.rc.SetReadDeadline(deadline)
+	r.rc.SetWriteDeadline(deadline)
+	return r.body.Read(p)
+}
+
+// idleWriter moves the write deadline idle past every write of a report.
+type idleWriter struct {
+	w    io.Writer
+	rc   *http.ResponseController
+	idle time.Duration
+}
+
+func (w idleWriter) Write(p []byte) (int, error) {
+	w.rc.SetWriteDeadline(time.Now().Add(w.idle))
+	return w.w.Write(p)
+}
+
+// importBody returns the body of an import, capped at importConfig.MaxBytes
+// and read under the idle timeout rather than the server timeouts.
+func importBody(response http.ResponseWriter, request *http.Request) io.Reader {
+	body := http.MaxBytesReader(response, request.Body, int64(importConfig.MaxBytes))
+	return idleReader{body: body, rc: http.NewResponseController(response), idle: importConfig.IdleTimeout}
+}
+
+// bodyStatus is the status of a failure to read a request body: 413 when it
+// is over the limit, otherwise status.
+func bodyStatus(err error, status int) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return status
+}
+
+// xlsxRows reads the first sheet of a workbook row by row.
+type xlsxRows struct {
+	file *excelize.File
+	rows *excelize.Rows
+}
+
+// xlsxUnzipRatio bounds how much larger than the upload limit a workbook
+// may grow once unzipped, so that a small zip bomb cannot exhaust memory.
+const xlsxUnzipRatio = 10
+
+// openXLSX spools body to a temporary file, since the zip container cannot
+// be read as a stream, and iterates over the first sheet from there.
+// Worksheets larger than the upload limit are unzipped to disk rather than
+// memory.
+func openXLSX(body io.Reader) (*xlsxRows, error) {
+	tmp, err := os.CreateTemp("", "import-*.xlsx")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, body); err != nil {
+		return nil, err
+	}
+	file, err := excelize.OpenFile(tmp.Name(), excelize.Options{
+		UnzipSizeLimit:    int64(importConfig.MaxBytes) * xlsxUnzipRatio,
+		UnzipXMLSizeLimit: int64(importConfig.MaxBytes),
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := file.Rows(file.GetSheetList()[0])
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &xlsxRows{file: file, rows: rows}, nil
+}
+
+func (x *xlsxRows) Read() ([]string, error) {
+	if !x.rows.Next() {
+		if err := x.rows.Error(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return x.rows.Columns()
+}
+
+func (x *xlsxRows) Close() error {
+	x.rows.Close()
+	return x.file.Close()
+}
+
+// importColumn sets one Employee field from a cell.
+type importColumn func(e *Employee, value string) error
+
+var importColumns = map[string]importColumn{
+	"firstname": func(e *Employee, value string) error { e.Firstname = value; return nil },
+	"lastname":  func(e *Employee, value string) error { e.Lastname = value; return nil },
+	"practice":  func(e *Employee, value string) error { e.Practice = value; return nil },
+	"empid": func(e *Employee, value string) (err error) {
+		e.EmpID, err = strconv.Atoi(value)
+		return err
+	},
+	"salary": func(e *Employee, value string) (err error) {
+		e.Salary, err = strconv.ParseFloat(value, 64)
+		return err
+	},
+}
+
+// normalizeHeader lets "First Name", "first_name" and "firstname" name the
+// same column.
+func normalizeHeader(header string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(header)))
+}
+
+// importMapping assigns Employee fields to the columns of header. Columns
+// match the field of the same name unless mapping, keyed by header, says
+// otherwise; unknown columns are ignored.
+func importMapping(header []string, mapping map[string]string) ([]string, error) {
+	fields := make([]string, len(header))
+	hasEmpID := false
+	for i, h := range header {
+		field, ok := mapping[normalizeHeader(h)]
+		if !ok {
+			field = normalizeHeader(h)
+		}
+		if _, known := importColumns[field]; !known {
+			if ok {
+				return nil, fmt.Errorf("column %q: unknown field %q", h, field)
+			}
+			continue
+		}
+		fields[i] = field
+		hasEmpID = hasEmpID || field == "empid"
+	}
+	if !hasEmpID {
+		return nil, errImportNoEmpID
+	}
+	return fields, nil
+}
+
+// parseMappings reads "map=Column:field" parameters.
+func parseMappings(values []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(values))
+	for _, v := range values {
+		i := strings.LastIndex(v, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("map %q: want column:field", v)
+		}
+		mapping[normalizeHeader(v[:i])] = normalizeHeader(v[i+1:])
+	}
+	return mapping, nil
+}
+
+// importResult is one line of the import report. Rows are numbered from 1,
+// the header being row 1.
+type importResult struct {
+	Row    int    `json:"row"`
+	EmpID  int    `json:"empid,omitempty"`
+	Action string `json:"action,omitempty"`
+	ID     string `json:"_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// importSummary is the last line of the import report.
+type importSummary struct {
+	DryRun  bool   `json:"dry_run"`
+	Created int    `json:"created"`
+	Updated int    `json:"updated"`
+	Failed  int    `json:"failed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// importer applies rows to the store, or with dryRun only works out what it
+// would do.
+type importer struct {
+	fields []string
+	dryRun bool
+	// seen remembers the empids a dry run would have created, so that a
+	// repeated empid is reported as the update it would become.
+	seen map[int]bool
+}
+
+func (im *importer) apply(ctx context.Context, row int, cells []string) importResult {
+	result := importResult{Row: row}
+	var employee Employee
+	for i, cell := range cells {
+		if i >= len(im.fields) || im.fields[i] == "" || strings.TrimSpace(cell) == "" {
+			continue
+		}
+		if err := importColumns[im.fields[i]](&employee, strings.TrimSpace(cell)); err != nil {
+			result.Error = fmt.Sprintf("%s: %v", im.fields[i], err)
+			return result
+		}
+	}
+	if employee.EmpID <= 0 {
+		result.Error = errImportEmpID.Error()
+		return result
+	}
+	result.EmpID = employee.EmpID
+
+	existing, err := store.Find(ctx, EmployeeQuery{EmpID: employee.EmpID, Limit: 1})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	switch {
+	case len(existing) > 0:
+		result.Action, result.ID = importUpdate, existing[0].ID.Hex()
+		if !im.dryRun {
+			err = store.Update(ctx, result.ID, employee)
+		}
+	case im.dryRun && im.seen[employee.EmpID]:
+		result.Action = importUpdate
+	case im.dryRun:
+		result.Action = importCreate
+		im.seen[employee.EmpID] = true
+	default:
+		result.Action = importCreate
+		err = store.Insert(ctx, &employee)
+		result.ID = employee.ID.Hex()
+	}
+	if err != nil {
+		result.Action, result.Error = "", err.Error()
+	}
+	return result
+}
+
+// importOptions reads the dry_run and map parameters of an import.
+func importOptions(params url.Values) (bool, map[string]string, error) {
+	dryRun, _ := strconv.ParseBool(params.Get("dry_run"))
+	mapping, err := parseMappings(params["map"])
+	return dryRun, mapping, err
+}
+
+// importRun is an import whose header row has been mapped.
+type importRun struct {
+	rows   rowReader
+	closer io.Closer
+	im     *importer
+	dryRun bool
+}
+
+// newImport opens body as a mediaType spreadsheet and maps its header.
+func newImport(body io.Reader, mediaType string, dryRun bool, mapping map[string]string) (*importRun, error) {
+	run := &importRun{dryRun: dryRun}
+	switch mediaType {
+	case csvMediaType, "application/csv":
+		r := csv.NewReader(body)
+		r.FieldsPerRecord = -1
+		r.ReuseRecord = true
+		run.rows = r
+	case xlsxMediaType:
+		x, err := openXLSX(body)
+		if err != nil {
+			return nil, err
+		}
+		run.rows, run.closer = x, x
+	default:
+		return nil, errUnsupportedMediaType
+	}
+
+	header, err := run.rows.Read()
+	var fields []string
+	if err == nil {
+		fields, err = importMapping(append([]string(nil), header...), mapping)
+	}
+	if err != nil {
+		run.Close()
+		return nil, err
+	}
+	run.im = &importer{fields: fields, dryRun: dryRun, seen: make(map[int]bool)}
+	return run, nil
+}
+
+func (r *importRun) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// run applies the rows, writing one NDJSON report line per row and then a
+// summary, and calls progress with the number of rows done so far.
+func (r *importRun) run(ctx context.Context, w io.Writer, progress func(rows int)) error {
+	enc := json.NewEncoder(w)
+	summary := importSummary{DryRun: r.dryRun}
+	for row := 2; ; row++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cells, err := r.rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			summary.Error = fmt.Sprintf("row %d: %v", row, err)
+			break
+		}
+		result := r.im.apply(ctx, row, cells)
+		switch {
+		case result.Error != "":
+			summary.Failed++
+		case result.Action == importCreate:
+			summary.Created++
+		default:
+			summary.Updated++
+		}
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+		progress(row - 1)
+	}
+	return enc.Encode(map[string]importSummary{"summary": summary})
+}
+
+// ImportEmployeesEndpoint creates or updates employees from a CSV or XLSX
+// body, matching existing employees on empid.
+func ImportEmployeesEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation POST /employees/import ImportEmployeesEndpoint
+	//
+	// Imports employees from a spreadsheet.
+	// The report is streamed as newline-delimited JSON: one line per row,
+	// then a summary line.
+	// ---
+	// consumes:
+	// - text/csv
+	// - application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+	// produces:
+	// - application/x-ndjson
+	// parameters:
+	// - name: dry_run
+	//   in: query
+	//   description: validate and report without writing
+	//   type: boolean
+	// - name: map
+	//   in: query
+	//   description: column:field pairs for headers that do not name a field
+	//   type: array
+	//   items:
+	//     type: string
+	//   collectionFormat: multi
+	// - name: async
+	//   in: query
+	//   description: queue the import as a job instead of waiting for the report
+	//   type: boolean
+	// responses:
+	//   '200':
+	//     description: import report
+	//   '202':
+	//     description: import job queued
+	//   '400':
+	//     description: bad request
+	//   '413':
+	//     description: file too large
+	//   '415':
+	//     description: unsupported media type
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	dryRun, mapping, err := importOptions(request.URL.Query())
+	if err != nil {
+		writeError(response, request, http.StatusBadRequest, err)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if !importMediaTypes[mediaType] {
+		writeError(response, request, http.StatusUnsupportedMediaType, errUnsupportedMediaType)
+		return
+	}
+
+	if async, _ := strconv.ParseBool(request.URL.Query().Get("async")); async {
+		submitJob(response, request, jobImport)
+		return
+	}
+	run, err := newImport(importBody(response, request), mediaType, dryRun, mapping)
+	if err != nil {
+		writeError(response, request, bodyStatus(err, http.StatusBadRequest), err)
+		return
+	}
+	defer run.Close()
+	response.Header().Set("content-type", "application/x-ndjson")
+	report := idleWriter{w: response, rc: http.NewResponseController(response), idle: importConfig.IdleTimeout}
+	run.run(request.Context(), report, func(int) {})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+// importReport runs an import and splits the report into row results and
+// the summary.
+func importReport(t *testing.T, path, contentType string, body io.Reader) ([]importResult, importSummary) {
+	req, _ := http.NewRequest("POST", path, body)
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	ImportEmployeesEndpoint(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+
+	var results []importResult
+	var summary struct{ Summary importSummary }
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), `{"summary"`) {
+			json.Unmarshal(scanner.Bytes(), &summary)
+			continue
+		}
+		var result importResult
+		json.Unmarshal(scanner.Bytes(), &result)
+		results = append(results, result)
+	}
+	return results, summary.Summary
+}
+
+func TestImportEmployees(t *testing.T) {
+	mem := useMemoryStore(t)
+	existing := Employee{Firstname: "aditi", EmpID: 1200, Practice: "IBM"}
+	mem.Insert(context.Background(), &existing)
+
+	payload := "First Name,Surname,empid,salary,practice,notes\n" +
+		"aditi,patil,1200,300,IBM,known\n" +
+		"ravi,kumar,7,100,SAP,\n" +
+		"meera,rao,,200,IBM,no empid\n" +
+		"john,doe,8,lots,IBM,bad salary\n" +
+		"ravi,kumar,7,150,SAP,repeated\n"
+
+	t.Run("dry run reports without writing", func(t *testing.T) {
+		results, summary := importReport(t, "/employees/import?dry_run=true&map=Surname:lastname", "text/csv", strings.NewReader(payload))
+		assert.Equal(t, importSummary{DryRun: true, Created: 1, Updated: 2, Failed: 2}, summary)
+		assert.Equal(t, importResult{Row: 2, EmpID: 1200, Action: importUpdate, ID: existing.ID.Hex()}, results[0])
+		assert.Equal(t, importResult{Row: 3, EmpID: 7, Action: importCreate}, results[1])
+		assert.Equal(t, importResult{Row: 4, Error: "empid is required"}, results[2])
+		assert.Contains(t, results[3].Error, "salary")
+		assert.Equal(t, importResult{Row: 6, EmpID: 7, Action: importUpdate}, results[4])
+		assert.Len(t, mem.employees, 1)
+	})
+
+	t.Run("it creates and updates matched on empid", func(t *testing.T) {
+		_, summary := importReport(t, "/employees/import?map=Surname:lastname", "text/csv", strings.NewReader(payload))
+		assert.Equal(t, importSummary{Created: 1, Updated: 2, Failed: 2}, summary)
+		updated, _ := mem.FindByID(context.Background(), existing.ID.Hex())
+		assert.Equal(t, "patil", updated.Lastname)
+		assert.Equal(t, 300.0, updated.Salary)
+		ravi, _ := mem.Find(context.Background(), EmployeeQuery{EmpID: 7})
+		assert.Len(t, ravi, 1)
+		assert.Equal(t, Employee{ravi[0].ID, "ravi", "kumar", 7, 150, "SAP"}, ravi[0])
+	})
+
+	t.Run("it reads XLSX", func(t *testing.T) {
+		f := excelize.NewFile()
+		f.SetSheetRow("Sheet1", "A1", &[]interface{}{"empid", "firstname", "salary"})
+		f.SetSheetRow("Sheet1", "A2", &[]interface{}{42, "meera", 200.5})
+		var body bytes.Buffer
+		f.Write(&body)
+
+		results, summary := importReport(t, "/employees/import", xlsxMediaType, &body)
+		assert.Equal(t, importSummary{Created: 1}, summary)
+		created, _ := mem.FindByID(context.Background(), results[0].ID)
+		assert.Equal(t, Employee{created.ID, "meera", "", 42, 200.5, ""}, created)
+	})
+
+	t.Run("it limits how far workbooks unzip", func(t *testing.T) {
+		saved := importConfig
+		defer func() { importConfig = saved }()
+		importConfig.MaxBytes = 64 << 10
+		f := excelize.NewFile()
+		f.SetSheetRow("Sheet1", "A1", &[]interface{}{"empid", "firstname"})
+		for row := 2; row < 2000; row++ {
+			cell, _ := excelize.CoordinatesToCellName(1, row)
+			f.SetSheetRow("Sheet1", cell, &[]interface{}{100000 + row, strings.Repeat("a", 1000) + strconv.Itoa(row)})
+		}
+		var body bytes.Buffer
+		f.Write(&body)
+		assert.Less(t, body.Len(), importConfig.MaxBytes)
+
+		req, _ := http.NewRequest("POST", "/employees/import", &body)
+		req.Header.Set("Content-Type", xlsxMediaType)
+		rr := httptest.NewRecorder()
+		ImportEmployeesEndpoint(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "unzip size exceeds")
+	})
+
+	t.Run("it rejects files without an empid column", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/employees/import", strings.NewReader("firstname\naditi\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		rr := httptest.NewRecorder()
+		ImportEmployeesEndpoint(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("it refuses files over the limit", func(t *testing.T) {
+		saved := importConfig
+		defer func() { importConfig = saved }()
+		importConfig.MaxBytes = 16
+		for mediaType, body := range map[string]string{
+			"text/csv":    "empid,firstname,lastname,salary\n",
+			xlsxMediaType: strings.Repeat("x", 17),
+		} {
+			req, _ := http.NewRequest("POST", "/employees/import", strings.NewReader(body))
+			req.Header.Set("Content-Type", mediaType)
+			rr := httptest.NewRecorder()
+			ImportEmployeesEndpoint(rr, req)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, mediaType)
+		}
+	})
+
+	t.Run("it cuts off stalled uploads", func(t *testing.T) {
+		saved := importConfig
+		defer func() { importConfig = saved }()
+		importConfig.IdleTimeout = 50 * time.Millisecond
+		server := httptest.NewServer(http.HandlerFunc(ImportEmployeesEndpoint))
+		defer server.Close()
+
+		body, upload := io.Pipe()
+		defer upload.Close()
+		go upload.Write([]byte("empid,firstname\n9,asha\n"))
+		resp, err := http.Post(server.URL, "text/csv", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		report, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(report), `"created":1`)
+		assert.Contains(t, string(report), "i/o timeout")
+	})
+
+	t.Run("it rejects other media types", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/employees/import", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		ImportEmployeesEndpoint(rr, req)
+		if status := rr.Code; status != http.StatusUnsupportedMediaType {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusUnsupportedMediaType)
+		}
+	})
+}
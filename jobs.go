@@ -0,0 +1,796 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Job types.
+const (
+	jobImport = "import"
+	jobExport = "export"
+)
+
+// Job states. Queued and running jobs are picked up again after a restart.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// Job retries back off from jobBackoff, doubling up to jobMaxBackoff.
+const (
+	jobBackoff    = time.Second
+	jobMaxBackoff = time.Minute
+)
+
+// jobReapInterval is how often finished jobs are checked against the TTL.
+const jobReapInterval = time.Minute
+
+var (
+	errJobsUnavailable = errors.New("job runner is not running")
+	errJobFinished     = errors.New("job has already finished")
+	errJobNotDone      = errors.New("job has not succeeded")
+	errJobType         = errors.New("type must be import or export")
+)
+
+// JobsConfig locates the job records and sizes the worker pool. Finished
+// jobs are deleted, with their input and result, TTL after they finished; 0
+// keeps them.
+type JobsConfig struct {
+	Dir         string        `yaml:"dir" toml:"dir"`
+	Workers     int           `yaml:"workers" toml:"workers"`
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts"`
+	TTL         time.Duration `yaml:"ttl" toml:"ttl"`
+}
+
+// Job is a queued import or export. Params are the query parameters of the
+// request that submitted it; an import's file is kept beside the record.
+// Owner is the authenticated caller that submitted the job, if any.
+type Job struct {
+	ID         string     `json:"id"`
+	Owner      string     `json:"owner,omitempty"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	Params     url.Values `json:"params,omitempty"`
+	InputType  string     `json:"input_type,omitempty"`
+	ResultType string     `json:"result_type,omitempty"`
+	Progress   int        `json:"progress"`
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	Result     string     `json:"result,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	RunAfter   time.Time  `json:"run_after,omitzero"`
+}
+
+func (j Job) finished() bool {
+	return j.Status == jobSucceeded || j.Status == jobFailed || j.Status == jobCancelled
+}
+
+// permanentError marks a job failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// newJobID returns 128 random bits in hex, so that job ids cannot be guessed
+// from one another.
+func newJobID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// isJobID reports whether id is in the format of newJobID, and so safe to
+// use in a file name.
+func isJobID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return len(id) == 32 && err == nil
+}
+
+// jobFiles keeps each job as <id>.json, with its input and result beside it.
+// Records are replaced by rename so a crash never leaves half of one.
+type jobFiles struct {
+	dir string
+}
+
+func (f jobFiles) path(id, ext string) string {
+	return filepath.Join(f.dir, id+ext)
+}
+
+func (f jobFiles) save(job Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp := f.path(job.ID, ".json.tmp")
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(job.ID, ".json"))
+}
+
+func (f jobFiles) load(id string) (Job, error) {
+	var job Job
+	if !isJobID(id) {
+		return job, ErrNotFound
+	}
+	body, err := os.ReadFile(f.path(id, ".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return job, ErrNotFound
+	}
+	if err != nil {
+		return job, err
+	}
+	return job, json.Unmarshal(body, &job)
+}
+
+// list returns every job, oldest first. Files that are not job records are
+// logged and skipped, so that a stray file cannot keep the server from
+// starting.
+func (f jobFiles) list() ([]Job, error) {
+	paths, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(paths))
+	for _, path := range paths {
+		id := filepath.Base(path[:len(path)-len(".json")])
+		if !isJobID(id) {
+			logger.Warn("skipping file in jobs directory", "path", path)
+			continue
+		}
+		job, err := f.load(id)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.Is(err, ErrNotFound):
+			// Reaped since the directory was read.
+			continue
+		case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+			logger.Warn("skipping unreadable job", "path", path, "err", err)
+			continue
+		case err != nil:
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs, nil
+}
+
+// activeJob is a job a worker is running.
+type activeJob struct {
+	job       Job
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// jobRunner runs queued jobs on a pool of workers. Records on disk are
+// written under mu, which also guards active.
+type jobRunner struct {
+	files       jobFiles
+	workers     int
+	maxAttempts int
+	ttl         time.Duration
+	queue       chan string
+	done        chan struct{}
+	wg          sync.WaitGroup
+
+	mu     sync.Mutex
+	active map[string]*activeJob
+}
+
+// jobs is installed by run while the server is up.
+var jobs *jobRunner
+
+func newJobRunner(cfg JobsConfig) (*jobRunner, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &jobRunner{
+		files:       jobFiles{dir: cfg.Dir},
+		workers:     cfg.Workers,
+		maxAttempts: cfg.MaxAttempts,
+		ttl:         cfg.TTL,
+		queue:       make(chan string, 256),
+		done:        make(chan struct{}),
+		active:      make(map[string]*activeJob),
+	}, nil
+}
+
+// start requeues the jobs a previous process left unfinished and runs the
+// workers, and the reaper of expired jobs, until ctx is cancelled. Jobs
+// still running then are put back in the queue for the next start.
+func (r *jobRunner) start(ctx context.Context) error {
+	pending, err := r.files.list()
+	if err != nil {
+		return err
+	}
+	for _, job := range pending {
+		if job.finished() {
+			continue
+		}
+		if job.Status == jobRunning {
+			job.Status = jobQueued
+			if err := r.files.save(job); err != nil {
+				return err
+			}
+		}
+		r.schedule(job.ID, time.Until(job.RunAfter))
+	}
+	for range r.workers {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.work(ctx)
+		}()
+	}
+	if r.ttl > 0 {
+		go r.reapEvery(ctx, jobReapInterval)
+	}
+	go func() {
+		<-ctx.Done()
+		close(r.done)
+	}()
+	return nil
+}
+
+func (r *jobRunner) reapEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.reap(time.Now()); err != nil {
+			logger.Error("deleting expired jobs", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reap deletes the jobs that finished more than the TTL before now, with
+// their input and result.
+func (r *jobRunner) reap(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all, err := r.files.list()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, job := range all {
+		if !job.finished() || now.Sub(job.UpdatedAt) < r.ttl {
+			continue
+		}
+		for _, ext := range []string{".input", ".result", ".json"} {
+			if err := os.Remove(r.files.path(job.ID, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// wait blocks until the workers have stopped.
+func (r *jobRunner) wait() {
+	r.wg.Wait()
+}
+
+func (r *jobRunner) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-r.queue:
+			r.run(ctx, id)
+		}
+	}
+}
+
+// schedule queues id after delay without blocking the caller.
+func (r *jobRunner) schedule(id string, delay time.Duration) {
+	enqueue := func() {
+		select {
+		case r.queue <- id:
+		case <-r.done:
+		}
+	}
+	if delay > 0 {
+		time.AfterFunc(delay, enqueue)
+		return
+	}
+	select {
+	case r.queue <- id:
+	default:
+		go enqueue()
+	}
+}
+
+// submit records a new job and queues it. An import's input is written first
+// so that a recorded job always has it.
+func (r *jobRunner) submit(job Job, input io.Reader) (Job, error) {
+	job.ID = newJobID()
+	job.Status = jobQueued
+	job.CreatedAt = time.Now().UTC()
+	job.UpdatedAt = job.CreatedAt
+	if input != nil {
+		f, err := os.OpenFile(r.files.path(job.ID, ".input"), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+		if err != nil {
+			return job, err
+		}
+		_, err = io.Copy(f, input)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return job, err
+		}
+	}
+	r.mu.Lock()
+	err := r.files.save(job)
+	r.mu.Unlock()
+	if err != nil {
+		return job, err
+	}
+	r.schedule(job.ID, 0)
+	return job, nil
+}
+
+// get returns a job, with live progress if it is running.
+func (r *jobRunner) get(id string) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a, ok := r.active[id]; ok {
+		return a.job, nil
+	}
+	return r.files.load(id)
+}
+
+// cancel stops a queued or running job.
+func (r *jobRunner) cancel(id string) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a, ok := r.active[id]; ok {
+		a.cancelled = true
+		a.cancel()
+		return a.job, nil
+	}
+	job, err := r.files.load(id)
+	if err != nil {
+		return job, err
+	}
+	if job.finished() {
+		return job, errJobFinished
+	}
+	job.Status = jobCancelled
+	job.UpdatedAt = time.Now().UTC()
+	return job, r.files.save(job)
+}
+
+// update applies change to a job and saves it, reporting failures to save
+// since workers have no caller to return them to.
+func (r *jobRunner) update(job *Job, change func(job *Job)) {
+	change(job)
+	job.UpdatedAt = time.Now().UTC()
+	if err := r.files.save(*job); err != nil {
+		logger.Error("saving job", "job", job.ID, "err", err)
+	}
+}
+
+// run executes one attempt of a job and records its outcome.
+func (r *jobRunner) run(ctx context.Context, id string) {
+	r.mu.Lock()
+	job, err := r.files.load(id)
+	if err != nil || job.Status != jobQueued {
+		r.mu.Unlock()
+		return
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a := &activeJob{cancel: cancel}
+	r.update(&job, func(job *Job) {
+		job.Status, job.Attempts, job.Progress, job.Error = jobRunning, job.Attempts+1, 0, ""
+	})
+	a.job = job
+	r.active[id] = a
+	r.mu.Unlock()
+
+	lastSave := time.Now()
+	err = r.execute(jobCtx, job, func(rows int) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		a.job.Progress = rows
+		if time.Since(lastSave) > time.Second {
+			lastSave = time.Now()
+			r.update(&a.job, func(*Job) {})
+		}
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, id)
+	job = a.job
+	switch {
+	case a.cancelled:
+		r.update(&job, func(job *Job) { job.Status = jobCancelled })
+	case err != nil && ctx.Err() != nil:
+		// Shutting down: the attempt did not fail, so it is not counted.
+		r.update(&job, func(job *Job) { job.Status, job.Attempts = jobQueued, job.Attempts-1 })
+	case err == nil:
+		r.update(&job, func(job *Job) { job.Status = jobSucceeded })
+	case errors.As(err, new(permanentError)) || job.Attempts >= r.maxAttempts:
+		r.update(&job, func(job *Job) { job.Status, job.Error = jobFailed, err.Error() })
+	default:
+		delay := min(jobBackoff<<(job.Attempts-1), jobMaxBackoff)
+		r.update(&job, func(job *Job) {
+			job.Status, job.Error, job.RunAfter = jobQueued, err.Error(), time.Now().UTC().Add(delay)
+		})
+		r.schedule(id, delay)
+	}
+}
+
+// execute writes the result of job to a temporary file, renaming it into
+// place only once the job has succeeded. Imports match rows on empid, so an
+// attempt that failed part way through can safely be repeated.
+func (r *jobRunner) execute(ctx context.Context, job Job, progress func(rows int)) error {
+	if !storeReady.Load() {
+		return errStoreUnavailable
+	}
+	tmp := r.files.path(job.ID, ".result.tmp")
+	result, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer result.Close()
+
+	switch job.Type {
+	case jobImport:
+		err = r.runImport(ctx, job, result, progress)
+	case jobExport:
+		err = runExport(ctx, job, result, progress)
+	default:
+		err = permanentError{errJobType}
+	}
+	if err == nil {
+		err = result.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.files.path(job.ID, ".result"))
+}
+
+func (r *jobRunner) runImport(ctx context.Context, job Job, w io.Writer, progress func(rows int)) error {
+	input, err := os.Open(r.files.path(job.ID, ".input"))
+	if err != nil {
+		return permanentError{err}
+	}
+	defer input.Close()
+	dryRun, mapping, err := importOptions(job.Params)
+	if err != nil {
+		return permanentError{err}
+	}
+	run, err := newImport(input, job.InputType, dryRun, mapping)
+	if err != nil {
+		return permanentError{err}
+	}
+	defer run.Close()
+	return run.run(ctx, w, progress)
+}
+
+func runExport(ctx context.Context, job Job, w io.Writer, progress func(rows int)) error {
+	query, err := parseListQuery(job.Params)
+	if err != nil {
+		return permanentError{err}
+	}
+	it, err := store.Iter(ctx, query)
+	if err != nil {
+		return err
+	}
+	x, err := newExportWriter(exportFormat(job.Params), w)
+	if err != nil {
+		it.Close()
+		return err
+	}
+	if err := writeExport(it, x, exportColumns(query), progress); err != nil {
+		return err
+	}
+	// The iterator stops early, without error, when ctx is cancelled.
+	return ctx.Err()
+}
+
+// exportFormat returns the format parameter of an export, csv by default.
+func exportFormat(params url.Values) string {
+	if format := params.Get("format"); format != "" {
+		return format
+	}
+	return "csv"
+}
+
+// jobParams returns the query parameters to keep with a job.
+func jobParams(request *http.Request) url.Values {
+	params := request.URL.Query()
+	params.Del("async")
+	params.Del("type")
+	return params
+}
+
+// writeJob writes job as JSON with a link to its result once there is one.
+func writeJob(response http.ResponseWriter, status int, job Job) {
+	if job.Status == jobSucceeded {
+		job.Result = "/jobs/" + job.ID + "/result"
+	}
+	writeJSON(response, status, job)
+}
+
+// jobOwner is the verified identity of the caller, if any.
+func jobOwner(request *http.Request) string {
+	identity, _ := identityFrom(request.Context())
+	return identity.Subject
+}
+
+// requestedJob returns the job named by the request path, reporting one
+// owned by another caller as not found. A job submitted without an identity
+// is kept private by its unguessable id alone.
+func requestedJob(request *http.Request) (Job, error) {
+	job, err := jobs.get(mux.Vars(request)["id"])
+	if err == nil && job.Owner != "" && job.Owner != jobOwner(request) {
+		return Job{}, ErrNotFound
+	}
+	return job, err
+}
+
+// submitJob validates an import or export request and queues it, answering
+// 202 Accepted with the job and its location.
+func submitJob(response http.ResponseWriter, request *http.Request, jobType string) {
+	if jobs == nil {
+		writeError(response, request, http.StatusServiceUnavailable, errJobsUnavailable)
+		return
+	}
+	job := Job{Owner: jobOwner(request), Type: jobType, Params: jobParams(request)}
+	var input io.Reader
+	switch jobType {
+	case jobImport:
+		if _, _, err := importOptions(job.Params); err != nil {
+			writeError(response, request, http.StatusBadRequest, err)
+			return
+		}
+		job.InputType, _, _ = mime.ParseMediaType(request.Header.Get("Content-Type"))
+		if !importMediaTypes[job.InputType] {
+			writeError(response, request, http.StatusUnsupportedMediaType, errUnsupportedMediaType)
+			return
+		}
+		job.ResultType = "application/x-ndjson"
+		input = importBody(response, request)
+	case jobExport:
+		if _, err := parseListQuery(job.Params); err != nil {
+			writeError(response, request, http.StatusBadRequest, err)
+			return
+		}
+		mediaType, ok := exportFormats[exportFormat(job.Params)]
+		if !ok {
+			writeError(response, request, http.StatusBadRequest, fmt.Errorf("unknown format %q", exportFormat(job.Params)))
+			return
+		}
+		job.ResultType = mediaType
+	default:
+		writeError(response, request, http.StatusBadRequest, errJobType)
+		return
+	}
+
+	job, err := jobs.submit(job, input)
+	if err != nil {
+		writeError(response, request, bodyStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	response.Header().Set("Location", "/jobs/"+job.ID)
+	writeJob(response, http.StatusAccepted, job)
+}
+
+// CreateJobEndpoint queues an import or export job.
+func CreateJobEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation POST /jobs CreateJobEndpoint
+	//
+	// Queues an import or export job.
+	// Takes the parameters of POST /employees/import or GET /employees/export;
+	// an import sends its file as the body.
+	// ---
+	// consumes:
+	// - text/csv
+	// - application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: type
+	//   in: query
+	//   description: import or export
+	//   required: true
+	//   type: string
+	// responses:
+	//   '202':
+	//     description: job queued
+	//   '400':
+	//     description: bad request
+	//   '413':
+	//     description: file too large
+	//   '415':
+	//     description: unsupported media type
+	//   '503':
+	//     description: job runner not running
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	submitJob(response, request, request.URL.Query().Get("type"))
+}
+
+// GetJobEndpoint returns the status and progress of a job.
+func GetJobEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /jobs/{id} GetJobEndpoint
+	//
+	// Returns a job's status.
+	// Progress counts the rows processed by the current attempt.
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: job id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: job
+	//   '404':
+	//     description: not found
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	if jobs == nil {
+		writeError(response, request, http.StatusServiceUnavailable, errJobsUnavailable)
+		return
+	}
+	job, err := requestedJob(request)
+	if err != nil {
+		writeJobError(response, request, err)
+		return
+	}
+	writeJob(response, http.StatusOK, job)
+}
+
+// CancelJobEndpoint cancels a queued or running job.
+func CancelJobEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation DELETE /jobs/{id} CancelJobEndpoint
+	//
+	// Cancels a job.
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: job id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '202':
+	//     description: cancellation requested
+	//   '404':
+	//     description: not found
+	//   '409':
+	//     description: job already finished
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	if jobs == nil {
+		writeError(response, request, http.StatusServiceUnavailable, errJobsUnavailable)
+		return
+	}
+	job, err := requestedJob(request)
+	if err == nil {
+		job, err = jobs.cancel(job.ID)
+	}
+	if err != nil {
+		writeJobError(response, request, err)
+		return
+	}
+	writeJob(response, http.StatusAccepted, job)
+}
+
+// GetJobResultEndpoint downloads the result of a succeeded job: the report
+// of an import or the file of an export.
+func GetJobResultEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /jobs/{id}/result GetJobResultEndpoint
+	//
+	// Downloads a job's result.
+	// ---
+	// produces:
+	// - application/x-ndjson
+	// - text/csv
+	// - application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: job id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: job result
+	//   '404':
+	//     description: not found
+	//   '409':
+	//     description: job has not succeeded
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	if jobs == nil {
+		writeError(response, request, http.StatusServiceUnavailable, errJobsUnavailable)
+		return
+	}
+	job, err := requestedJob(request)
+	if err == nil && job.Status != jobSucceeded {
+		err = errJobNotDone
+	}
+	if err != nil {
+		writeJobError(response, request, err)
+		return
+	}
+	result, err := os.Open(jobs.files.path(job.ID, ".result"))
+	if err != nil {
+		writeError(response, request, http.StatusInternalServerError, err)
+		return
+	}
+	defer result.Close()
+
+	ext := "ndjson"
+	if job.Type == jobExport {
+		ext = exportFormat(job.Params)
+	}
+	filename := job.Type + "-" + job.ID + "." + ext
+	response.Header().Set("content-type", job.ResultType)
+	response.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	http.ServeContent(response, request, filename, job.UpdatedAt, result)
+}
+
+func writeJobError(response http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(response, request, http.StatusNotFound, err)
+	case errors.Is(err, errJobFinished), errors.Is(err, errJobNotDone):
+		writeError(response, request, http.StatusConflict, err)
+	default:
+		writeError(response, request, http.StatusInternalServerError, err)
+	}
+}
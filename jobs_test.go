@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// useJobRunner installs a runner keeping its jobs in a temporary directory,
+// with its workers running when start is set.
+func useJobRunner(t *testing.T, dir string, start bool) *jobRunner {
+	runner, err := newJobRunner(JobsConfig{Dir: dir, Workers: 2, MaxAttempts: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if start {
+		if err := runner.start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := jobs
+	jobs = runner
+	t.Cleanup(func() {
+		cancel()
+		runner.wait()
+		jobs = saved
+	})
+	return runner
+}
+
+func jobsRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs", CreateJobEndpoint).Methods("POST")
+	r.HandleFunc("/jobs/{id}", GetJobEndpoint).Methods("GET")
+	r.HandleFunc("/jobs/{id}", CancelJobEndpoint).Methods("DELETE")
+	r.HandleFunc("/jobs/{id}/result", GetJobResultEndpoint).Methods("GET")
+	r.HandleFunc("/employees/import", ImportEmployeesEndpoint).Methods("POST")
+	r.HandleFunc("/employees/export", ExportEmployeesEndpoint).Methods("GET")
+	return r
+}
+
+func decodeJob(t *testing.T, rr *httptest.ResponseRecorder) Job {
+	var job Job
+	if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+		t.Fatal(err, rr.Body.String())
+	}
+	return job
+}
+
+// awaitJob polls a job until it has finished.
+func awaitJob(t *testing.T, r http.Handler, id string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req, _ := http.NewRequest("GET", "/jobs/"+id, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		job := decodeJob(t, rr)
+		if job.finished() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still %s", id, job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	mem := useMemoryStore(t)
+	r := jobsRouter()
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("it answers 503 without a runner", func(t *testing.T) {
+		saved := jobs
+		jobs = nil
+		defer func() { jobs = saved }()
+		rr := serve("POST", "/jobs?type=export", "", "")
+		if status := rr.Code; status != http.StatusServiceUnavailable {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+		}
+	})
+
+	useJobRunner(t, t.TempDir(), true)
+
+	t.Run("it imports in the background", func(t *testing.T) {
+		rr := serve("POST", "/employees/import?async=true", "text/csv", "empid,firstname\n500,asha\n501,vikram\n")
+		if status := rr.Code; status != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+		}
+		job := decodeJob(t, rr)
+		assert.Equal(t, "/jobs/"+job.ID, rr.Header().Get("Location"))
+		assert.Equal(t, jobImport, job.Type)
+
+		job = awaitJob(t, r, job.ID)
+		assert.Equal(t, jobSucceeded, job.Status)
+		assert.Equal(t, 2, job.Progress)
+		assert.Equal(t, "/jobs/"+job.ID+"/result", job.Result)
+		employees, _ := mem.Find(context.Background(), EmployeeQuery{EmpID: 501})
+		assert.Len(t, employees, 1)
+
+		rr = serve("GET", job.Result, "", "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), `{"summary":{"dry_run":false,"created":2,"updated":0,"failed":0}}`)
+	})
+
+	t.Run("it refuses imports over the limit", func(t *testing.T) {
+		saved := importConfig
+		defer func() { importConfig = saved }()
+		importConfig.MaxBytes = 8
+		rr := serve("POST", "/employees/import?async=true", "text/csv", "empid,firstname\n500,asha\n")
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("it exports in the background", func(t *testing.T) {
+		rr := serve("POST", "/jobs?type=export&empid=500&fields=firstname", "", "")
+		if status := rr.Code; status != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+		}
+		job := awaitJob(t, r, decodeJob(t, rr).ID)
+		assert.Equal(t, jobSucceeded, job.Status)
+
+		rr = serve("GET", job.Result, "", "")
+		assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="export-`+job.ID+`.csv"`, rr.Header().Get("Content-Disposition"))
+		assert.Regexp(t, `^_id,firstname\n[0-9a-f]{24},asha\n$`, rr.Body.String())
+	})
+
+	t.Run("it rejects bad jobs up front", func(t *testing.T) {
+		for _, target := range []string{"/jobs", "/jobs?type=export&format=pdf", "/jobs?type=export&sort=salary2"} {
+			rr := serve("POST", target, "", "")
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("%s: handler returned wrong status code: got %v want %v", target, status, http.StatusBadRequest)
+			}
+		}
+		rr := serve("POST", "/jobs?type=import", "application/pdf", "")
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	})
+
+	t.Run("it fails imports without an empid column", func(t *testing.T) {
+		rr := serve("POST", "/jobs?type=import", "text/csv", "firstname\nasha\n")
+		job := awaitJob(t, r, decodeJob(t, rr).ID)
+		assert.Equal(t, jobFailed, job.Status)
+		assert.Equal(t, 1, job.Attempts)
+		assert.Equal(t, errImportNoEmpID.Error(), job.Error)
+
+		rr = serve("GET", "/jobs/"+job.ID+"/result", "", "")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("it answers 404 for unknown jobs", func(t *testing.T) {
+		for _, id := range []string{"5d5e2b1f9c4e8a3b2c1d0e9f", "config"} {
+			rr := serve("GET", "/jobs/"+id, "", "")
+			assert.Equal(t, http.StatusNotFound, rr.Code, id)
+		}
+	})
+}
+
+func TestJobCancellation(t *testing.T) {
+	useMemoryStore(t)
+	runner := useJobRunner(t, t.TempDir(), false)
+	r := jobsRouter()
+
+	job, err := runner.submit(Job{Type: jobExport, ResultType: csvMediaType}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("DELETE", "/jobs/"+job.ID, nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+	}
+	assert.Equal(t, jobCancelled, decodeJob(t, rr).Status)
+
+	// A worker picking the job up afterwards leaves it alone.
+	runner.run(context.Background(), job.ID)
+	job, _ = runner.get(job.ID)
+	assert.Equal(t, jobCancelled, job.Status)
+	assert.Zero(t, job.Attempts)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+}
+
+func TestJobRetries(t *testing.T) {
+	useMemoryStore(t)
+	runner := useJobRunner(t, t.TempDir(), false)
+	job, err := runner.submit(Job{Type: jobExport, ResultType: csvMediaType}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-runner.queue
+
+	storeReady.Store(false)
+	runner.run(context.Background(), job.ID)
+	job, _ = runner.get(job.ID)
+	assert.Equal(t, jobQueued, job.Status)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, errStoreUnavailable.Error(), job.Error)
+	assert.WithinDuration(t, time.Now().Add(jobBackoff), job.RunAfter, 500*time.Millisecond)
+
+	runner.run(context.Background(), job.ID)
+	job, _ = runner.get(job.ID)
+	assert.Equal(t, jobFailed, job.Status)
+	assert.Equal(t, 2, job.Attempts)
+}
+
+func TestJobsSurviveRestart(t *testing.T) {
+	useMemoryStore(t)
+	dir := t.TempDir()
+	stopped := useJobRunner(t, dir, false)
+	job, err := stopped.submit(Job{Type: jobExport, ResultType: csvMediaType}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The previous process died part way through the job.
+	job.Status = jobRunning
+	stopped.files.save(job)
+
+	useJobRunner(t, dir, true)
+	job = awaitJob(t, jobsRouter(), job.ID)
+	assert.Equal(t, jobSucceeded, job.Status)
+
+	t.Run("it skips files that are not job records", func(t *testing.T) {
+		os.WriteFile(filepath.Join(dir, "notes.json"), []byte("{}"), 0o600)
+		os.WriteFile(filepath.Join(dir, newJobID()+".json"), []byte("{truncated"), 0o600)
+		runner := useJobRunner(t, dir, true)
+		all, err := runner.files.list()
+		assert.NoError(t, err)
+		if assert.Len(t, all, 1) {
+			assert.Equal(t, job.ID, all[0].ID)
+		}
+	})
+}
+
+func TestJobOwnership(t *testing.T) {
+	useMemoryStore(t)
+	useJobRunner(t, t.TempDir(), true)
+	r := jobsRouter()
+	as := func(subject, method, target string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, target, nil)
+		if subject != "" {
+			req = req.WithContext(context.WithValue(req.Context(), identityKey{}, Identity{Subject: subject, Source: "mtls"}))
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	job := decodeJob(t, as("payroll-service", "POST", "/jobs?type=export"))
+	assert.Regexp(t, `^[0-9a-f]{32}$`, job.ID)
+	assert.Equal(t, "payroll-service", job.Owner)
+	assert.Eventually(t, func() bool {
+		job, _ := jobs.get(job.ID)
+		return job.finished()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, caller := range []string{"", "reporting-service"} {
+		assert.Equal(t, http.StatusNotFound, as(caller, "GET", "/jobs/"+job.ID).Code, caller)
+		assert.Equal(t, http.StatusNotFound, as(caller, "GET", "/jobs/"+job.ID+"/result").Code, caller)
+		assert.Equal(t, http.StatusNotFound, as(caller, "DELETE", "/jobs/"+job.ID).Code, caller)
+	}
+	assert.Equal(t, http.StatusOK, as("payroll-service", "GET", "/jobs/"+job.ID+"/result").Code)
+}
+
+func TestJobReaper(t *testing.T) {
+	useMemoryStore(t)
+	runner := useJobRunner(t, t.TempDir(), false)
+	runner.ttl = time.Hour
+	finished, _ := runner.submit(Job{Type: jobImport, InputType: csvMediaType}, strings.NewReader("empid\n1\n"))
+	queued, _ := runner.submit(Job{Type: jobExport}, nil)
+	finished.Status = jobSucceeded
+	runner.files.save(finished)
+	os.WriteFile(runner.files.path(finished.ID, ".result"), []byte("{}"), 0o600)
+
+	assert.NoError(t, runner.reap(time.Now()))
+	_, err := runner.get(finished.ID)
+	assert.NoError(t, err, "kept until the TTL has passed")
+
+	assert.NoError(t, runner.reap(time.Now().Add(time.Hour)))
+	_, err = runner.get(finished.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	leftovers, _ := filepath.Glob(runner.files.path(finished.ID, "*"))
+	assert.Empty(t, leftovers, "the input and result go too")
+	_, err = runner.get(queued.ID)
+	assert.NoError(t, err, "unfinished jobs are kept")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var logger = newLogger(slog.LevelInfo)
+
+func newLogger(level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+type requestIDKey struct{}
+
+// requestIDFrom returns the id assigned to the request by requestIDMiddleware.
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID bounds the caller's request ids, which end up in headers and
+// log lines.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestIDMiddleware propagates the caller's X-Request-ID, or generates one
+// when it is missing or malformed, and echoes it back on the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		id := request.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		response.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(request.Context(), requestIDKey{}, id)
+		next.ServeHTTP(response, request.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Hijack lets WebSocket upgrades take the connection over, which counts as a
+// 101 response.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+type routeKey struct{}
+
+// routeTemplate returns the mux path template of the route serving the
+// request, or an empty string if no route matched. Middleware wrapping the
+// router sees the template recorded by recordRoute.
+func routeTemplate(request *http.Request) string {
+	if route := mux.CurrentRoute(request); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	if holder, ok := request.Context().Value(routeKey{}).(*string); ok {
+		return *holder
+	}
+	return ""
+}
+
+// withRouteHolder gives recordRoute somewhere to store the matched template.
+func withRouteHolder(request *http.Request) *http.Request {
+	if _, ok := request.Context().Value(routeKey{}).(*string); ok {
+		return request
+	}
+	return request.WithContext(context.WithValue(request.Context(), routeKey{}, new(string)))
+}
+
+// recordRoute is router middleware that makes the matched template visible
+// to the middleware wrapping the router.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if holder, ok := request.Context().Value(routeKey{}).(*string); ok {
+			*holder = routeTemplate(request)
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// accessLogMiddleware logs one line per request. Server errors are logged at
+// error level and client errors at warn level.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		request = withRouteHolder(request)
+		recorder := &statusRecorder{ResponseWriter: response}
+		next.ServeHTTP(recorder, request)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case recorder.status >= 500:
+			level = slog.LevelError
+		case recorder.status >= 400:
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(request.Context(), level, "request",
+			slog.String("method", request.Method),
+			slog.String("route", routeTemplate(request)),
+			slog.String("path", request.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", recorder.bytes),
+			slog.String("request_id", requestIDFrom(request.Context())),
+		)
+	})
+}
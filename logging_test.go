@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	handler := requestIDMiddleware(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		writeError(response, request, http.StatusNotFound, errors.New("not found"))
+	}))
+
+	req, _ := http.NewRequest("GET", "/employee/1", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	generated := rr.Header().Get("X-Request-ID")
+	if generated == "" {
+		t.Errorf("request id was not generated")
+	}
+	var envelope errorEnvelope
+	json.Unmarshal(rr.Body.Bytes(), &envelope)
+	assert.Equal(t, generated, envelope.RequestID)
+	assert.Equal(t, "not found", envelope.Message)
+
+	t.Run("it echoes the caller's request id", func(t *testing.T) {
+		req.Header.Set("X-Request-ID", "abc-123")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, "abc-123", rr.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("it replaces malformed request ids", func(t *testing.T) {
+		for _, id := range []string{"abc\n{\"level\":\"ERROR\"}", strings.Repeat("a", 129)} {
+			req.Header.Set("X-Request-ID", id)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			echoed := rr.Header().Get("X-Request-ID")
+			assert.NotEqual(t, id, echoed)
+			assert.Len(t, echoed, 32)
+		}
+	})
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	defer func() {
+		logger = newLogger(slog.LevelInfo)
+	}()
+
+	handler := requestIDMiddleware(accessLogMiddleware(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("boom"))
+	})))
+	req, _ := http.NewRequest("DELETE", "/employee/1", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ERROR", line["level"])
+	assert.Equal(t, "DELETE", line["method"])
+	assert.Equal(t, float64(http.StatusInternalServerError), line["status"])
+	assert.Equal(t, float64(4), line["bytes"])
+	assert.Equal(t, "abc-123", line["request_id"])
+}
@@ -12,36 +12,42 @@
 //     Consumes:
 //     - application/json
 //     - application/xml
+//     - application/yaml
+//     - application/msgpack
+//     - application/cbor
+//     - application/x-protobuf
 //
 //     Produces:
 //     - application/json
 //     - application/xml
+//     - application/yaml
+//     - application/msgpack
+//     - application/cbor
+//     - application/x-protobuf
 //
 //
 // swagger:meta
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"flag"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-func init() {
-	session, err := mgo.Dial("localhost/muxgocrud")
-	if err != nil {
-		log.Fatal(err)
-	}
-	db = session.DB("muxgocrud")
-}
-
 // Employee represents body of employee response.
 type Employee struct {
 	ID        bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
@@ -54,8 +60,9 @@ type Employee struct {
 
 // EmployeeCollection holds collection of emp records and total count.
 type EmployeeCollection struct {
-	AllEmployees []Employee `json:"employees"`
-	Count        int        `json:"count"`
+	XMLName      xml.Name   `json:"-" xml:"employees"`
+	AllEmployees []Employee `json:"employees" xml:"employee"`
+	Count        int        `json:"count" xml:"count"`
 }
 
 var mockMarshal = json.Marshal
@@ -69,8 +76,18 @@ func CreateEmployeeEndpoint(response http.ResponseWriter, request *http.Request)
 	// ---
 	// produces:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// consumes:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// parameters:
 	// - in: body
 	//   name: employee
@@ -97,25 +114,37 @@ func CreateEmployeeEndpoint(response http.ResponseWriter, request *http.Request)
 	// responses:
 	//   '201':
 	//     description: employee response
+	//   '400':
+	//     description: malformed body
 	//   '500':
 	//     description: internal server error
+	//   '406':
+	//     description: not acceptable
+	//   '413':
+	//     description: body too large
+	//   '415':
+	//     description: unsupported media type
 	//   default:
 	//     description: unexpected error
 
-	setResponseHeader(response)
+	in, out, ok := negotiate(response, request)
+	if !ok {
+		return
+	}
 	var employee Employee
-	json.NewDecoder(request.Body).Decode(&employee)
-	collection := db.C("employee")
-	err := collection.Insert(employee)
+	if err := decodeBody(response, request, in, &employee); err != nil {
+		writeError(response, request, bodyStatus(err, http.StatusBadRequest), err)
+		return
+	}
+	err := store.Insert(request.Context(), &employee)
 	if err != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte(`{ "message": "` + err.Error() + `" }`))
+		writeError(response, request, http.StatusInternalServerError, err)
 		return
 	}
-	result, err := mockMarshal(&employee)
+	result, err := out.Marshal(&employee)
 	if err != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte(`{ "message": "` + err.Error() + `" }`))
+		writeError(response, request, http.StatusInternalServerError, err)
+		return
 	}
 	response.WriteHeader(http.StatusCreated)
 	response.Write(result)
@@ -131,34 +160,123 @@ func GetEmployeesEndpoint(response http.ResponseWriter, request *http.Request) {
 	// ---
 	// consumes:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// produces:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
+	// parameters:
+	// - name: limit
+	//   in: query
+	//   description: page size, 0 for all
+	//   type: integer
+	// - name: page
+	//   in: query
+	//   description: 1-based page number
+	//   type: integer
+	// - name: practice
+	//   in: query
+	//   description: only employees of this practice
+	//   type: string
+	// - name: lastname
+	//   in: query
+	//   description: only employees with this last name
+	//   type: string
+	// - name: empid
+	//   in: query
+	//   description: only the employee with this employee number
+	//   type: integer
+	// - name: sort
+	//   in: query
+	//   description: firstname, lastname, empid, salary or practice, prefixed with - for descending order
+	//   type: string
+	// - name: fields
+	//   in: query
+	//   description: comma separated fields to return besides the id
+	//   type: string
 	// responses:
 	//   '200':
 	//     description: employee response
+	//   '400':
+	//     description: bad request
 	//   '500':
 	//     description: internal server error
+	//   '406':
+	//     description: not acceptable
 	//   default:
 	//     description: unexpected error
 
-	setResponseHeader(response)
-	var employees []Employee
-	collection := db.C("employee")
-	limit, _ := strconv.Atoi(request.FormValue("limit"))
-	page, _ := strconv.Atoi(request.FormValue("page"))
-	skips := limit * (page - 1)
-	collection.Find(nil).Limit(limit).Skip(skips).All(&employees)
+	_, out, ok := negotiate(response, request)
+	if !ok {
+		return
+	}
+	query, err := listQuery(request)
+	if err != nil {
+		writeError(response, request, http.StatusBadRequest, err)
+		return
+	}
+	employees, err := store.Find(request.Context(), query)
+	if err != nil {
+		writeError(response, request, http.StatusInternalServerError, err)
+		return
+	}
 	employeeCollection := EmployeeCollection{AllEmployees: employees, Count: len(employees)}
-	result, err := mockMarshal(employeeCollection)
+	result, err := out.Marshal(employeeCollection)
 	if err != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte(`{ "message": "` + err.Error() + `" }`))
+		writeError(response, request, http.StatusInternalServerError, err)
 		return
 
 	}
 	response.Write(result)
 }
 
+// listQuery reads the paging, filter, sort and field selection parameters of
+// GET /employees.
+func listQuery(request *http.Request) (EmployeeQuery, error) {
+	request.ParseForm()
+	return parseListQuery(request.Form)
+}
+
+// parseListQuery reads the list parameters from values, so that queued
+// export jobs can replay them.
+func parseListQuery(values url.Values) (EmployeeQuery, error) {
+	limit, _ := strconv.Atoi(values.Get("limit"))
+	page, _ := strconv.Atoi(values.Get("page"))
+	query := pageQuery(limit, page)
+	query.Practice = values.Get("practice")
+	query.Lastname = values.Get("lastname")
+	query.EmpID, _ = strconv.Atoi(values.Get("empid"))
+	query.Sort = values.Get("sort")
+	if err := checkSort(query.Sort); err != nil {
+		return query, err
+	}
+	if fields := values.Get("fields"); fields != "" {
+		query.Fields = strings.Split(fields, ",")
+		for _, field := range query.Fields {
+			if !employeeFields[field] {
+				return query, fmt.Errorf("unknown field %q", field)
+			}
+		}
+	}
+	return query, nil
+}
+
+// checkSort rejects a sort other than an employee field, optionally
+// prefixed with - for descending order.
+func checkSort(sort string) error {
+	if sort != "" && !employeeFields[strings.TrimPrefix(sort, "-")] {
+		return fmt.Errorf("unknown sort field %q", sort)
+	}
+	return nil
+}
+
 //GetEmployeeEndpoint returns single employee record.
 func GetEmployeeEndpoint(response http.ResponseWriter, request *http.Request) {
 
@@ -169,8 +287,18 @@ func GetEmployeeEndpoint(response http.ResponseWriter, request *http.Request) {
 	// ---
 	// consumes:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// produces:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// parameters:
 	// - name: id
 	//   in: path
@@ -183,24 +311,28 @@ func GetEmployeeEndpoint(response http.ResponseWriter, request *http.Request) {
 	//     description: not found
 	//   '500':
 	//     description: internal server error
+	//   '406':
+	//     description: not acceptable
 	//   default:
 	//     description: unexpected error
 
-	setResponseHeader(response)
+	_, out, ok := negotiate(response, request)
+	if !ok {
+		return
+	}
 	params := mux.Vars(request)
-	id := bson.ObjectIdHex(params["id"])
-	var employee Employee
-	collection := db.C("employee")
-	err := collection.Find(bson.M{"_id": id}).One(&employee)
+	employee, err := store.FindByID(request.Context(), params["id"])
+	if err == ErrNotFound {
+		writeError(response, request, http.StatusNotFound, err)
+		return
+	}
 	if err != nil {
-		response.WriteHeader(http.StatusNotFound)
-		response.Write([]byte(`{ "message": "` + err.Error() + `" }`))
+		writeError(response, request, http.StatusInternalServerError, err)
 		return
 	}
-	result, er := mockMarshal(&employee)
+	result, er := out.Marshal(&employee)
 	if er != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte(`{ "message": "` + er.Error() + `" }`))
+		writeError(response, request, http.StatusInternalServerError, er)
 		return
 	}
 	response.Write(result)
@@ -216,8 +348,18 @@ func UpdateEmployeeEndpoint(response http.ResponseWriter, request *http.Request)
 	// ---
 	// consumes:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// produces:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// parameters:
 	// - name: id
 	//   in: path
@@ -248,30 +390,36 @@ func UpdateEmployeeEndpoint(response http.ResponseWriter, request *http.Request)
 	// responses:
 	//   '200':
 	//     description: employee response
+	//   '400':
+	//     description: malformed body
+	//   '406':
+	//     description: not acceptable
+	//   '413':
+	//     description: body too large
+	//   '415':
+	//     description: unsupported media type
 	//   default:
 	//     description: unexpected error
 
-	setResponseHeader(response)
+	in, out, ok := negotiate(response, request)
+	if !ok {
+		return
+	}
 	params := mux.Vars(request)
-	id := bson.ObjectIdHex(params["id"])
 	var employee Employee
-	err := json.NewDecoder(request.Body).Decode(&employee)
+	err := decodeBody(response, request, in, &employee)
 	if err != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte(`{ "message": "` + err.Error() + `" }`))
+		writeError(response, request, bodyStatus(err, http.StatusBadRequest), err)
 		return
 	}
-	collection := db.C("employee")
-	err = collection.Update(bson.M{"_id": id}, bson.M{"$set": employee})
+	err = store.Update(request.Context(), params["id"], employee)
 	if err != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte(`{ "message": "` + err.Error() + `" }`))
+		writeError(response, request, http.StatusInternalServerError, err)
 		return
 	}
-	result, err := mockMarshal(&employee)
+	result, err := out.Marshal(&employee)
 	if err != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte(`{ "message": "` + err.Error() + `" }`))
+		writeError(response, request, http.StatusInternalServerError, err)
 		return
 	}
 	response.Write(result)
@@ -287,8 +435,18 @@ func DeleteEmployeeEndpoint(response http.ResponseWriter, request *http.Request)
 	// ---
 	// consumes:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// produces:
 	// - application/json
+	// - application/xml
+	// - application/yaml
+	// - application/msgpack
+	// - application/cbor
+	// - application/x-protobuf
 	// parameters:
 	// - name: id
 	//   in: path
@@ -296,40 +454,108 @@ func DeleteEmployeeEndpoint(response http.ResponseWriter, request *http.Request)
 	//   required: true
 	//   type: string
 	// responses:
-	//   '200':
-	//     description: employee response
+	//   '204':
+	//     description: employee deleted
+	//   '406':
+	//     description: not acceptable
 	//   default:
 	//     description: unexpected error
 
-	setResponseHeader(response)
+	if _, _, ok := negotiate(response, request); !ok {
+		return
+	}
 	params := mux.Vars(request)
-	id := bson.ObjectIdHex(params["id"])
-	collection := db.C("employee")
-	err := collection.Remove(bson.M{"_id": id})
+	err := store.Remove(request.Context(), params["id"])
 	if err != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte(`{ "message": "` + err.Error() + `" }`))
+		writeError(response, request, http.StatusInternalServerError, err)
 		return
 	}
-	response.Write([]byte("Employee deleted successfully."))
+	response.WriteHeader(http.StatusNoContent)
 }
 
-var headers = handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
-var methods = handlers.AllowedMethods([]string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD"})
-var origins = handlers.AllowedOrigins([]string{"*"})
-
 // DefineRoute : collection of all routes.
 func DefineRoute() {
-	fmt.Println("Starting the application...")
-	router.HandleFunc("/employees", CreateEmployeeEndpoint).Methods("POST")
-	router.HandleFunc("/employees", GetEmployeesEndpoint).Methods("GET")
-	router.HandleFunc("/employee/{id}", GetEmployeeEndpoint).Methods("GET")
-	router.HandleFunc("/employee/{id}", UpdateEmployeeEndpoint).Methods("PUT")
-	router.HandleFunc("/employee/{id}", DeleteEmployeeEndpoint).Methods("DELETE")
+	router.HandleFunc("/employees", requireStore(CreateEmployeeEndpoint)).Methods("POST")
+	router.HandleFunc("/employees", requireStore(GetEmployeesEndpoint)).Methods("GET")
+	router.HandleFunc("/employees/import", requireStore(ImportEmployeesEndpoint)).Methods("POST")
+	router.HandleFunc("/employees/export", requireStore(ExportEmployeesEndpoint)).Methods("GET")
+	router.HandleFunc("/employees/events", EmployeeEventsEndpoint).Methods("GET")
+	router.HandleFunc("/employee/{id}", requireStore(GetEmployeeEndpoint)).Methods("GET")
+	router.HandleFunc("/employee/{id}", requireStore(UpdateEmployeeEndpoint)).Methods("PUT")
+	router.HandleFunc("/employee/{id}", requireStore(DeleteEmployeeEndpoint)).Methods("DELETE")
+	router.HandleFunc("/jobs", CreateJobEndpoint).Methods("POST")
+	router.HandleFunc("/jobs/{id}", GetJobEndpoint).Methods("GET")
+	router.HandleFunc("/jobs/{id}", CancelJobEndpoint).Methods("DELETE")
+	router.HandleFunc("/jobs/{id}/result", GetJobResultEndpoint).Methods("GET")
+	router.HandleFunc("/webhooks", requireStore(CreateWebhookEndpoint)).Methods("POST")
+	router.HandleFunc("/webhooks", requireStore(GetWebhooksEndpoint)).Methods("GET")
+	router.HandleFunc("/webhooks/deliveries", requireStore(GetWebhookDeliveriesEndpoint)).Methods("GET")
+	router.HandleFunc("/webhooks/deliveries/{id}", requireStore(GetWebhookDeliveryEndpoint)).Methods("GET")
+	router.HandleFunc("/webhooks/deliveries/{id}/replay", requireStore(ReplayWebhookDeliveryEndpoint)).Methods("POST")
+	router.HandleFunc("/webhooks/{id}", requireStore(GetWebhookEndpoint)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", requireStore(UpdateWebhookEndpoint)).Methods("PUT")
+	router.HandleFunc("/webhooks/{id}", requireStore(DeleteWebhookEndpoint)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", requireStore(GetWebhookDeliveriesEndpoint)).Methods("GET")
+	router.HandleFunc("/ws", requireStore(WebSocketEndpoint)).Methods("GET")
+	router.HandleFunc("/graphql", requireStore(graphqlHandler(graphqlConfig))).Methods("GET", "POST")
+	if graphqlConfig.GraphiQL {
+		router.HandleFunc("/graphiql", GraphiQLEndpoint).Methods("GET")
+	}
+	router.Handle("/metrics", metricsHandler()).Methods("GET")
+	router.HandleFunc("/healthz", LivenessEndpoint).Methods("GET")
+	router.HandleFunc("/readyz", ReadinessEndpoint).Methods("GET")
+	router.HandleFunc("/health", HealthEndpoint).Methods("GET")
+	router.Use(recordRoute)
+	router.Use(rateLimitMiddleware(rateLimiter, rateLimitPolicy))
 }
 
 // The main function.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err := migrateCommand(ctx, os.Args[2:], os.Getenv, os.Stdout)
+		stop()
+		if err != nil && err != flag.ErrHelp {
+			logger.Error("migrate failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	cfg, printOnly, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		logger.Error("invalid configuration", "err", err)
+		os.Exit(2)
+	}
+	if printOnly {
+		printConfig(os.Stdout, cfg)
+		return
+	}
+	logger = newLogger(cfg.logLevel())
+	rateLimitPolicy = cfg.RateLimit
+	graphqlConfig = cfg.GraphQL
+	importConfig = cfg.Import
+	wsConfig = cfg.WebSocket
+	wsAllowedOrigins = cfg.CORS.AllowedOrigins
+
 	DefineRoute()
-	http.ListenAndServe(":12345", handlers.CORS(headers, methods, origins)(router))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, cfg); err != nil {
+		logger.Error("server stopped", "err", err)
+		os.Exit(1)
+	}
+}
+
+// newHandler wraps the router with the middleware that must also see
+// requests no route matches.
+func newHandler(cfg Config) http.Handler {
+	cors := handlers.CORS(
+		handlers.AllowedHeaders(cfg.CORS.AllowedHeaders),
+		handlers.AllowedMethods(cfg.CORS.AllowedMethods),
+		handlers.AllowedOrigins(cfg.CORS.AllowedOrigins),
+	)
+	return requestIDMiddleware(clientCertMiddleware(tracingMiddleware(accessLogMiddleware(metricsMiddleware(cors(router))))))
 }
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -20,6 +25,16 @@ func (m *VarMock) dummyMarshal(v interface{}) ([]byte, error) {
 	return nil, m.err
 }
 
+func TestMain(m *testing.M) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	err := connectStore(ctx, defaultConfig())
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
 func TestMuxCrudAPI(t *testing.T) {
 	t.Run("TestUpdateEmployeeEndpoint", func(t *testing.T) {
 		testUpdateEmployeeEndpoint(t)
@@ -324,9 +339,9 @@ func testDeleteEmployeeEndpoint(t *testing.T) {
 	router.HandleFunc("/employee/{id}", DeleteEmployeeEndpoint).Methods("DELETE")
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusNoContent {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusNoContent)
 	}
 	deletedCount, _ := collection.Count()
 	if deletedCount == count {
@@ -354,3 +369,47 @@ func TestDefineRoute(t *testing.T) {
 		t.Errorf("Error in defining router!!!")
 	}
 }
+
+func TestListQuery(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/employees?limit=10&page=3&practice=IBM&empid=7&sort=-salary&fields=empid,salary", nil)
+	query, err := listQuery(req)
+	assert.NoError(t, err)
+	assert.Equal(t, EmployeeQuery{Limit: 10, Skip: 20, Practice: "IBM", EmpID: 7, Sort: "-salary", Fields: []string{"empid", "salary"}}, query)
+
+	req, _ = http.NewRequest("GET", "/employees?sort=password", nil)
+	_, err = listQuery(req)
+	assert.Error(t, err)
+
+	req, _ = http.NewRequest("GET", "/employees?fields=firstname,password", nil)
+	_, err = listQuery(req)
+	assert.Error(t, err)
+}
+
+func TestCORSOrigins(t *testing.T) {
+	saved, ready, savedRouter := store, storeReady.Load(), router
+	store = stubStore{}
+	storeReady.Store(true)
+	router = mux.NewRouter()
+	DefineRoute()
+	defer func() {
+		store, router = saved, savedRouter
+		storeReady.Store(ready)
+	}()
+
+	cfg := defaultConfig()
+	cfg.CORS.AllowedOrigins = []string{"https://hr.example.com"}
+	handler := newHandler(cfg)
+
+	req, _ := http.NewRequest("GET", "/employees", nil)
+	req.Header.Set("Origin", "https://hr.example.com")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "https://hr.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+
+	req, _ = http.NewRequest("GET", "/employees", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+}
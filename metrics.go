@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/mgo.v2"
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	storeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "store_operation_duration_seconds",
+		Help:    "Employee store latency by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	storeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "store_operation_errors_total",
+		Help: "Employee store errors by operation. Not-found results are not errors.",
+	}, []string{"operation"})
+
+	wsConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "websocket_connections",
+		Help: "Open /ws connections.",
+	})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "employee_cache_lookups_total",
+		Help: "Employee cache lookups by result: hit, negative_hit, miss or error.",
+	}, []string{"result"})
+
+	cacheLoads = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "employee_cache_loads_total",
+		Help: "Store reads made for employee cache misses. Concurrent misses of one id share a read.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpRequestDuration,
+		storeDuration,
+		storeErrors,
+		wsConnections,
+		cacheLookups,
+		cacheLoads,
+		employeeCollector{},
+	)
+}
+
+// metricsHandler serves the registry in the Prometheus exposition format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// metricsMiddleware records request counts and latencies. Requests that match
+// no route are labelled "unmatched" to keep label cardinality bounded.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		request = withRouteHolder(request)
+		recorder := &statusRecorder{ResponseWriter: response}
+		next.ServeHTTP(recorder, request)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		route := routeTemplate(request)
+		if route == "" {
+			route = "unmatched"
+		}
+		labels := prometheus.Labels{
+			"method": request.Method,
+			"route":  route,
+			"status": strconv.Itoa(recorder.status),
+		}
+		httpRequests.With(labels).Inc()
+		httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// instrumentedStore records latency and errors of every store operation.
+type instrumentedStore struct {
+	next EmployeeStore
+}
+
+func (s instrumentedStore) observe(operation string, start time.Time, err error) {
+	storeDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != ErrNotFound {
+		storeErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (s instrumentedStore) Insert(ctx context.Context, employee *Employee) (err error) {
+	defer func(start time.Time) { s.observe("Insert", start, err) }(time.Now())
+	return s.next.Insert(ctx, employee)
+}
+
+func (s instrumentedStore) FindByID(ctx context.Context, id string) (employee Employee, err error) {
+	defer func(start time.Time) { s.observe("FindByID", start, err) }(time.Now())
+	return s.next.FindByID(ctx, id)
+}
+
+func (s instrumentedStore) Find(ctx context.Context, query EmployeeQuery) (employees []Employee, err error) {
+	defer func(start time.Time) { s.observe("Find", start, err) }(time.Now())
+	return s.next.Find(ctx, query)
+}
+
+// Iter only times opening the cursor; reading it is up to the caller.
+func (s instrumentedStore) Iter(ctx context.Context, query EmployeeQuery) (it EmployeeIterator, err error) {
+	defer func(start time.Time) { s.observe("Iter", start, err) }(time.Now())
+	return s.next.Iter(ctx, query)
+}
+
+func (s instrumentedStore) Update(ctx context.Context, id string, employee Employee) (err error) {
+	defer func(start time.Time) { s.observe("Update", start, err) }(time.Now())
+	return s.next.Update(ctx, id, employee)
+}
+
+func (s instrumentedStore) Remove(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { s.observe("Remove", start, err) }(time.Now())
+	return s.next.Remove(ctx, id)
+}
+
+func (s instrumentedStore) CountByPractice(ctx context.Context) (counts map[string]int, err error) {
+	defer func(start time.Time) { s.observe("CountByPractice", start, err) }(time.Now())
+	return s.next.CountByPractice(ctx)
+}
+
+func (s instrumentedStore) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+var (
+	mgoSocketsAliveDesc = prometheus.NewDesc("mongo_sockets_alive", "Open sockets to the Mongo cluster.", nil, nil)
+	mgoSocketsInUseDesc = prometheus.NewDesc("mongo_sockets_in_use", "Sockets currently held by sessions.", nil, nil)
+	mgoSocketRefsDesc   = prometheus.NewDesc("mongo_socket_refs", "Session references to sockets.", nil, nil)
+	mgoSentOpsDesc      = prometheus.NewDesc("mongo_sent_ops_total", "Operations sent to Mongo.", nil, nil)
+	mgoReceivedOpsDesc  = prometheus.NewDesc("mongo_received_ops_total", "Replies received from Mongo.", nil, nil)
+)
+
+// mgoStatsCollector exposes the mgo driver's session pool statistics.
+type mgoStatsCollector struct{}
+
+// registerMgoStats starts collecting mgo statistics. It is called once the
+// mgo backend connects, as they mean nothing for the other backends.
+func registerMgoStats() {
+	mgo.SetStats(true)
+	if err := metricsRegistry.Register(mgoStatsCollector{}); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if !errors.As(err, &registered) {
+			panic(err)
+		}
+	}
+}
+
+func (mgoStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mgoSocketsAliveDesc
+	ch <- mgoSocketsInUseDesc
+	ch <- mgoSocketRefsDesc
+	ch <- mgoSentOpsDesc
+	ch <- mgoReceivedOpsDesc
+}
+
+func (mgoStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := mgo.GetStats()
+	ch <- prometheus.MustNewConstMetric(mgoSocketsAliveDesc, prometheus.GaugeValue, float64(stats.SocketsAlive))
+	ch <- prometheus.MustNewConstMetric(mgoSocketsInUseDesc, prometheus.GaugeValue, float64(stats.SocketsInUse))
+	ch <- prometheus.MustNewConstMetric(mgoSocketRefsDesc, prometheus.GaugeValue, float64(stats.SocketRefs))
+	ch <- prometheus.MustNewConstMetric(mgoSentOpsDesc, prometheus.CounterValue, float64(stats.SentOps))
+	ch <- prometheus.MustNewConstMetric(mgoReceivedOpsDesc, prometheus.CounterValue, float64(stats.ReceivedOps))
+}
+
+var employeesDesc = prometheus.NewDesc("employees", "Employees per practice.", []string{"practice"}, nil)
+
+const employeeCollectTimeout = 5 * time.Second
+
+// employeeCollector counts employees per practice at scrape time.
+type employeeCollector struct{}
+
+func (employeeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- employeesDesc
+}
+
+func (employeeCollector) Collect(ch chan<- prometheus.Metric) {
+	if !storeReady.Load() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), employeeCollectTimeout)
+	defer cancel()
+	counts, err := store.CountByPractice(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(employeesDesc, err)
+		return
+	}
+	for practice, count := range counts {
+		ch <- prometheus.MustNewConstMetric(employeesDesc, prometheus.GaugeValue, float64(count), practice)
+	}
+}
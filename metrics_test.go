@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubStore is an EmployeeStore whose operations all return err.
+type stubStore struct {
+	err error
+}
+
+func (s stubStore) Insert(ctx context.Context, employee *Employee) error { return s.err }
+func (s stubStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	return Employee{}, s.err
+}
+func (s stubStore) Find(ctx context.Context, query EmployeeQuery) ([]Employee, error) {
+	return nil, s.err
+}
+func (s stubStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	return nil, s.err
+}
+func (s stubStore) Update(ctx context.Context, id string, employee Employee) error { return s.err }
+func (s stubStore) Remove(ctx context.Context, id string) error                    { return s.err }
+func (s stubStore) CountByPractice(ctx context.Context) (map[string]int, error) {
+	return map[string]int{"IBM": 2}, s.err
+}
+func (s stubStore) Ping(ctx context.Context) error { return s.err }
+
+func TestMetricsMiddleware(t *testing.T) {
+	handler := metricsMiddleware(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusTeapot)
+	}))
+	req, _ := http.NewRequest("GET", "/no/such/route", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	count := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "unmatched", "418"))
+	assert.Equal(t, float64(1), count)
+
+	t.Run("it labels requests with the route template", func(t *testing.T) {
+		r := mux.NewRouter()
+		r.HandleFunc("/employee/{id}", func(response http.ResponseWriter, request *http.Request) {})
+		r.Use(metricsMiddleware)
+		req, _ := http.NewRequest("GET", "/employee/42", nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		count := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/employee/{id}", "200"))
+		assert.Equal(t, float64(1), count)
+	})
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	s := instrumentedStore{next: stubStore{err: ErrNotFound}}
+	s.FindByID(context.Background(), "42")
+	assert.Equal(t, float64(0), testutil.ToFloat64(storeErrors.WithLabelValues("FindByID")))
+
+	s = instrumentedStore{next: stubStore{err: errors.New("no reachable servers")}}
+	s.Remove(context.Background(), "42")
+	assert.Equal(t, float64(1), testutil.ToFloat64(storeErrors.WithLabelValues("Remove")))
+}
+
+func TestEmployeeCollector(t *testing.T) {
+	saved, ready := store, storeReady.Load()
+	store = stubStore{}
+	storeReady.Store(true)
+	defer func() {
+		store = saved
+		storeReady.Store(ready)
+	}()
+
+	expected := `
+# HELP employees Employees per practice.
+# TYPE employees gauge
+employees{practice="IBM"} 2
+`
+	if err := testutil.CollectAndCompare(employeeCollector{}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegisterMgoStats(t *testing.T) {
+	registerMgoStats()
+	registerMgoStats()
+	families, err := metricsRegistry.Gather()
+	assert.NoError(t, err)
+	var names []string
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	assert.Contains(t, names, "mongo_sockets_alive")
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// migrationLockTTL is how long a migration lock lasts unless renewed,
+	// after which another instance may take it over from a process that
+	// died holding it.
+	migrationLockTTL   = time.Minute
+	migrationLockRetry = time.Second
+)
+
+// migrationLockRenew is how often holders renew the migration lock.
+var migrationLockRenew = migrationLockTTL / 3
+
+var (
+	errMigrationsPending = errors.New("schema migrations are pending; run the migrate command or enable auto_migrate")
+	errMigrationLockLost = errors.New("migration lock was taken over")
+)
+
+// mongoIndexNotFound is the server's code for dropping a missing index.
+const mongoIndexNotFound = 27
+
+// schema is the migrator of the installed store, which readiness asks for
+// pending migrations. schemaPending is set while the store cannot connect
+// because of them.
+var (
+	schema        migrator
+	schemaPending atomic.Bool
+)
+
+// migration is one version of a backend's schema or data: new tables or
+// buckets, indexes, or a backfill of existing records. down undoes up.
+// Steps get the backend's transaction, or its database where it has none;
+// those must be safe to run again, as a crash may leave a step applied but
+// not recorded.
+type migration[T any] struct {
+	version  int
+	name     string
+	up, down func(ctx context.Context, conn T) error
+}
+
+// migrationLog records which migrations a backend has applied.
+type migrationLog[T any] interface {
+	// lock keeps other instances from migrating until unlock is called.
+	lock(ctx context.Context) (unlock func(), err error)
+	applied(ctx context.Context) (map[int]bool, error)
+	// apply runs the up or down step of m and records the outcome, in one
+	// transaction where the backend has them.
+	apply(ctx context.Context, m migration[T], up bool) error
+}
+
+// migrationStatus is whether one migration has been applied.
+type migrationStatus struct {
+	Version int
+	Name    string
+	Applied bool
+}
+
+// migrator moves a backend's schema between versions.
+type migrator interface {
+	latest() int
+	status(ctx context.Context) ([]migrationStatus, error)
+	// migrate applies the migrations up to target and reverts those above
+	// it, under the migration lock.
+	migrate(ctx context.Context, target int) error
+}
+
+// migrations are the steps of one backend, in version order.
+type migrations[T any] struct {
+	log   migrationLog[T]
+	steps []migration[T]
+}
+
+func (m migrations[T]) latest() int {
+	if len(m.steps) == 0 {
+		return 0
+	}
+	return m.steps[len(m.steps)-1].version
+}
+
+func (m migrations[T]) status(ctx context.Context) ([]migrationStatus, error) {
+	applied, err := m.log.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]migrationStatus, len(m.steps))
+	for i, step := range m.steps {
+		statuses[i] = migrationStatus{Version: step.version, Name: step.name, Applied: applied[step.version]}
+	}
+	return statuses, nil
+}
+
+func (m migrations[T]) migrate(ctx context.Context, target int) error {
+	unlock, err := m.log.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	applied, err := m.log.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for _, step := range m.steps {
+		if step.version > target || applied[step.version] {
+			continue
+		}
+		if err := m.log.apply(ctx, step, true); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", step.version, step.name, err)
+		}
+		logger.Info("applied migration", "version", step.version, "name", step.name)
+	}
+	for i := len(m.steps) - 1; i >= 0; i-- {
+		step := m.steps[i]
+		if step.version <= target || !applied[step.version] {
+			continue
+		}
+		if err := m.log.apply(ctx, step, false); err != nil {
+			return fmt.Errorf("reverting migration %d (%s): %w", step.version, step.name, err)
+		}
+		logger.Info("reverted migration", "version", step.version, "name", step.name)
+	}
+	return nil
+}
+
+// prepareSchema brings the schema up to date when autoMigrate is set, and
+// otherwise checks that it is, so that the store never runs on a schema it
+// does not know.
+func prepareSchema(ctx context.Context, m migrator, autoMigrate bool) error {
+	if autoMigrate {
+		return m.migrate(ctx, m.latest())
+	}
+	statuses, err := m.status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			return errMigrationsPending
+		}
+	}
+	return nil
+}
+
+// lockOwner identifies this process in migration locks.
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return host + ":" + strconv.Itoa(os.Getpid())
+}
+
+// acquireLock calls try until it takes the lock or ctx is done. try removes
+// a lock whose holder let it expire before claiming it.
+func acquireLock(ctx context.Context, try func(now time.Time) (bool, error)) error {
+	for {
+		ok, err := try(time.Now())
+		if ok || err != nil {
+			return err
+		}
+		logger.Info("waiting for the migration lock")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockRetry):
+		}
+	}
+}
+
+// renewLock calls renew with a new expiry every migrationLockRenew until the
+// returned stop is called, so that a lock held through a long migration
+// does not expire under it.
+func renewLock(renew func(expires time.Time) error) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(migrationLockRenew)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := renew(now.Add(migrationLockTTL)); err != nil {
+					logger.Error("renewing the migration lock", "err", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// mongoMigrationLog records migrations in the "migrations" collection and
+// locks with a document in "migrations_lock". Mongo steps run outside of
+// transactions.
+type mongoMigrationLog struct {
+	db *mongo.Database
+}
+
+func (l mongoMigrationLog) lock(ctx context.Context) (func(), error) {
+	locks := l.db.Collection("migrations_lock")
+	owner := lockOwner()
+	err := acquireLock(ctx, func(now time.Time) (bool, error) {
+		_, err := locks.DeleteOne(ctx, mongobson.M{"_id": "lock", "expires_at": mongobson.M{"$lt": now}})
+		if err != nil {
+			return false, err
+		}
+		_, err = locks.InsertOne(ctx, mongobson.M{"_id": "lock", "owner": owner, "expires_at": now.Add(migrationLockTTL)})
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	stop := renewLock(func(expires time.Time) error {
+		result, err := locks.UpdateOne(context.Background(), mongobson.M{"_id": "lock", "owner": owner}, mongobson.M{"$set": mongobson.M{"expires_at": expires}})
+		if err == nil && result.MatchedCount == 0 {
+			err = errMigrationLockLost
+		}
+		return err
+	})
+	return func() {
+		stop()
+		locks.DeleteOne(context.Background(), mongobson.M{"_id": "lock", "owner": owner})
+	}, nil
+}
+
+func (l mongoMigrationLog) applied(ctx context.Context) (map[int]bool, error) {
+	cursor, err := l.db.Collection("migrations").Find(ctx, mongobson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []struct {
+		Version int `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(records))
+	for _, r := range records {
+		applied[r.Version] = true
+	}
+	return applied, nil
+}
+
+func (l mongoMigrationLog) apply(ctx context.Context, m migration[*mongo.Database], up bool) error {
+	records := l.db.Collection("migrations")
+	if !up {
+		if err := m.down(ctx, l.db); err != nil {
+			return err
+		}
+		_, err := records.DeleteOne(ctx, mongobson.M{"_id": m.version})
+		return err
+	}
+	if err := m.up(ctx, l.db); err != nil {
+		return err
+	}
+	_, err := records.InsertOne(ctx, mongobson.M{"_id": m.version, "name": m.name, "applied_at": time.Now()})
+	return err
+}
+
+// mongoIndexes returns the steps creating and dropping indexes, each made of
+// the keys in ascending order, on collection. Dropping skips indexes that
+// are already gone, as a step may be reverted again after a crash.
+func mongoIndexes(collection string, indexes ...mongobson.D) (up, down func(ctx context.Context, db *mongo.Database) error) {
+	names := make([]string, len(indexes))
+	models := make([]mongo.IndexModel, len(indexes))
+	for i, keys := range indexes {
+		for _, key := range keys {
+			if names[i] != "" {
+				names[i] += "_"
+			}
+			names[i] += fmt.Sprintf("%s_%v", key.Key, key.Value)
+		}
+		models[i] = mongo.IndexModel{Keys: keys}
+	}
+	up = func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).Indexes().CreateMany(ctx, models)
+		return err
+	}
+	down = func(ctx context.Context, db *mongo.Database) error {
+		for _, name := range names {
+			_, err := db.Collection(collection).Indexes().DropOne(ctx, name)
+			var command mongo.CommandError
+			if errors.As(err, &command) && command.Code == mongoIndexNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return up, down
+}
+
+// mongoMigrations are the steps of the mongo backend, whichever driver
+// serves it.
+func mongoMigrations() []migration[*mongo.Database] {
+	employeeUp, employeeDown := mongoIndexes("employee",
+		mongobson.D{{Key: "empid", Value: 1}},
+		mongobson.D{{Key: "practice", Value: 1}},
+		mongobson.D{{Key: "lastname", Value: 1}},
+	)
+	deliveriesUp, deliveriesDown := mongoIndexes("webhook_deliveries",
+		mongobson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}},
+		mongobson.D{{Key: "subscription_id", Value: 1}},
+	)
+	outboxUp, outboxDown := mongoIndexes("outbox",
+		mongobson.D{{Key: "relayed", Value: 1}, {Key: "_id", Value: 1}},
+		mongobson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}},
+	)
+	return []migration[*mongo.Database]{
+		{1, "index employee filters", employeeUp, employeeDown},
+		{2, "index webhook deliveries", deliveriesUp, deliveriesDown},
+		{3, "index outbox", outboxUp, outboxDown},
+	}
+}
+
+func mongoMigrator(db *mongo.Database) migrator {
+	return migrations[*mongo.Database]{log: mongoMigrationLog{db: db}, steps: mongoMigrations()}
+}
+
+// prepareMongoSchema migrates the mongo backend for the mgo store over a
+// connection of the official driver, which it returns for later status
+// checks.
+func prepareMongoSchema(ctx context.Context, cfg MongoConfig, autoMigrate bool) (*mongo.Client, error) {
+	client, err := dialMongo(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareSchema(ctx, mongoMigrator(client.Database(cfg.Database)), autoMigrate); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+}
+
+// openMigrator connects to the configured backend for the migrate command.
+func openMigrator(ctx context.Context, cfg Config) (migrator, func(), error) {
+	switch cfg.Backend {
+	case "sqlite", "postgres":
+		database, err := openSQL(ctx, cfg.Backend, cfg.SQL)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sqlMigrator(database, sqlDialects[cfg.Backend]), func() { database.Close() }, nil
+	case "bolt":
+		if err := os.MkdirAll(cfg.Bolt.Dir, 0o700); err != nil {
+			return nil, nil, err
+		}
+		database, err := openBolt(boltPath(cfg.Bolt))
+		if err != nil {
+			return nil, nil, err
+		}
+		return boltMigrator(database), func() { database.Close() }, nil
+	}
+	client, err := dialMongo(ctx, cfg.Mongo)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mongoMigrator(client.Database(cfg.Mongo.Database)), func() { client.Disconnect(context.Background()) }, nil
+}
+
+// migrateCommand runs "migrate [status | up [version] | down [version]]
+// [flags]". up defaults to the latest version and down to the one before
+// the newest applied migration.
+func migrateCommand(ctx context.Context, args []string, getenv func(string) string, w io.Writer) error {
+	action := "status"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+	target := -1
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		version, err := strconv.Atoi(args[0])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		target, args = version, args[1:]
+	}
+	cfg, _, err := loadConfig(args, getenv)
+	if err != nil {
+		return err
+	}
+	m, closeMigrator, err := openMigrator(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeMigrator()
+
+	statuses, err := m.status(ctx)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "status":
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(w, "%4d  %-8s  %s\n", s.Version, state, s.Name)
+		}
+		return nil
+	case "up":
+		if target < 0 {
+			target = m.latest()
+		}
+	case "down":
+		if target < 0 {
+			target = 0
+			for _, s := range statuses {
+				if s.Applied {
+					target = max(target, previousVersion(statuses, s.Version))
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q: want status, up or down", action)
+	}
+	return m.migrate(ctx, target)
+}
+
+// previousVersion returns the version before version in statuses, or 0.
+func previousVersion(statuses []migrationStatus, version int) int {
+	previous := 0
+	for _, s := range statuses {
+		if s.Version < version {
+			previous = s.Version
+		}
+	}
+	return previous
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMigrationLog keeps the applied versions in memory and records the
+// steps run, as "+1" for up and "-1" for down.
+type fakeMigrationLog struct {
+	versions map[int]bool
+	ran      []string
+}
+
+func (l *fakeMigrationLog) lock(ctx context.Context) (func(), error) { return func() {}, nil }
+
+func (l *fakeMigrationLog) applied(ctx context.Context) (map[int]bool, error) {
+	applied := make(map[int]bool)
+	for v := range l.versions {
+		applied[v] = true
+	}
+	return applied, nil
+}
+
+func (l *fakeMigrationLog) apply(ctx context.Context, m migration[*fakeMigrationLog], up bool) error {
+	step := m.down
+	if up {
+		step = m.up
+	}
+	if err := step(ctx, l); err != nil {
+		return err
+	}
+	if up {
+		l.versions[m.version] = true
+	} else {
+		delete(l.versions, m.version)
+	}
+	return nil
+}
+
+func fakeMigrations(versions ...int) (migrations[*fakeMigrationLog], *fakeMigrationLog) {
+	log := &fakeMigrationLog{versions: make(map[int]bool)}
+	m := migrations[*fakeMigrationLog]{log: log}
+	for _, v := range versions {
+		m.steps = append(m.steps, migration[*fakeMigrationLog]{
+			version: v,
+			name:    "step",
+			up: func(ctx context.Context, l *fakeMigrationLog) error {
+				l.ran = append(l.ran, "+"+strconv.Itoa(v))
+				return nil
+			},
+			down: func(ctx context.Context, l *fakeMigrationLog) error {
+				l.ran = append(l.ran, "-"+strconv.Itoa(v))
+				return nil
+			},
+		})
+	}
+	return m, log
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	m, log := fakeMigrations(1, 2, 3)
+	assert.Equal(t, 3, m.latest())
+
+	assert.NoError(t, m.migrate(ctx, 2))
+	assert.NoError(t, m.migrate(ctx, 3))
+	assert.NoError(t, m.migrate(ctx, 3))
+	assert.NoError(t, m.migrate(ctx, 1))
+	assert.Equal(t, []string{"+1", "+2", "+3", "-3", "-2"}, log.ran, "up in order, down in reverse, once each")
+
+	statuses, err := m.status(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []migrationStatus{{1, "step", true}, {2, "step", false}, {3, "step", false}}, statuses)
+
+	m.steps[1].up = func(ctx context.Context, l *fakeMigrationLog) error { return errors.New("boom") }
+	assert.ErrorContains(t, m.migrate(ctx, 3), "migration 2 (step): boom")
+	assert.Equal(t, map[int]bool{1: true}, log.versions, "later steps wait for the failed one")
+}
+
+func TestPrepareSchema(t *testing.T) {
+	ctx := context.Background()
+	m, log := fakeMigrations(1, 2)
+	assert.ErrorIs(t, prepareSchema(ctx, m, false), errMigrationsPending)
+	assert.Empty(t, log.ran)
+
+	assert.NoError(t, prepareSchema(ctx, m, true))
+	assert.NoError(t, prepareSchema(ctx, m, false))
+}
+
+func TestSQLMigrationLock(t *testing.T) {
+	database, dialect := openSQLTest(t, "sqlite")
+	l := sqlMigrationLog{db: database, dialect: dialect}
+	ctx := context.Background()
+
+	unlock, err := l.lock(ctx)
+	assert.NoError(t, err)
+	waiting, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = l.lock(waiting)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the lock is held")
+
+	unlock()
+	unlock, err = l.lock(ctx)
+	assert.NoError(t, err)
+	unlock()
+
+	_, err = database.Exec(`INSERT INTO schema_migrations_lock (id, owner, expires_at) VALUES (1, 'gone', ?)`, time.Now().Add(-time.Second).UnixNano())
+	assert.NoError(t, err)
+	unlock, err = l.lock(ctx)
+	assert.NoError(t, err, "an expired lock is taken over")
+	unlock()
+
+	t.Run("it renews the lock while held", func(t *testing.T) {
+		saved := migrationLockRenew
+		migrationLockRenew = 10 * time.Millisecond
+		defer func() { migrationLockRenew = saved }()
+		expires := func() (at int64) {
+			database.QueryRow(`SELECT expires_at FROM schema_migrations_lock WHERE id = 1`).Scan(&at)
+			return at
+		}
+		unlock, err := l.lock(ctx)
+		assert.NoError(t, err)
+		taken := expires()
+		assert.Eventually(t, func() bool { return expires() > taken }, time.Second, 5*time.Millisecond)
+		unlock()
+		assert.Zero(t, expires(), "unlocking stops renewing and releases the lock")
+	})
+}
+
+func TestMigrateCommand(t *testing.T) {
+	ctx := context.Background()
+	args := []string{"--backend", "sqlite", "--sql-dsn", filepath.Join(t.TempDir(), "employees.db")}
+	run := func(command ...string) (string, error) {
+		var out bytes.Buffer
+		err := migrateCommand(ctx, append(command, args...), func(string) string { return "" }, &out)
+		return out.String(), err
+	}
+
+	out, err := run()
+	assert.NoError(t, err)
+	assert.Equal(t, "   1  pending   create employee\n   2  pending   create outbox\n   3  pending   create webhooks\n   4  pending   keep relayed outbox entries\n   5  pending   create outbox lease\n", out)
+
+	_, err = run("up", "2")
+	assert.NoError(t, err)
+	out, _ = run("status")
+	assert.Contains(t, out, "   2  applied   create outbox\n   3  pending ")
+
+	_, err = run("up")
+	assert.NoError(t, err)
+	_, err = run("down")
+	assert.NoError(t, err)
+	out, _ = run("status")
+	assert.Contains(t, out, "   4  applied   keep relayed outbox entries\n   5  pending ", "down reverts the newest")
+
+	_, err = run("down", "0")
+	assert.NoError(t, err)
+	out, _ = run("status")
+	assert.NotContains(t, out, "applied")
+
+	_, err = run("sideways")
+	assert.ErrorContains(t, err, "unknown migrate command")
+	_, err = run("up", "two")
+	assert.ErrorContains(t, err, "invalid version")
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mongoClient is the official driver's client, when mongo.driver is
+// "mongo".
+var mongoClient *mongo.Client
+
+// schemaClient is the official driver's client the mgo store checks its
+// schema with.
+var schemaClient *mongo.Client
+
+var objectIDType = reflect.TypeOf(bson.ObjectId(""))
+
+// mongoRegistry is the driver's default registry plus a codec storing mgo's
+// bson.ObjectId as an ObjectId, so that Employee and the other documents
+// keep their types and read the data mgo wrote.
+func mongoRegistry() *bsoncodec.Registry {
+	registry := mongobson.NewRegistry()
+	registry.RegisterTypeEncoder(objectIDType, bsoncodec.ValueEncoderFunc(func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, v reflect.Value) error {
+		id := v.Interface().(bson.ObjectId)
+		if !id.Valid() {
+			return fmt.Errorf("invalid ObjectId %q", string(id))
+		}
+		var oid primitive.ObjectID
+		copy(oid[:], id)
+		return vw.WriteObjectID(oid)
+	}))
+	registry.RegisterTypeDecoder(objectIDType, bsoncodec.ValueDecoderFunc(func(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, v reflect.Value) error {
+		switch vr.Type() {
+		case bsontype.ObjectID:
+			oid, err := vr.ReadObjectID()
+			if err != nil {
+				return err
+			}
+			v.SetString(string(oid[:]))
+			return nil
+		case bsontype.Null:
+			v.SetString("")
+			return vr.ReadNull()
+		}
+		return fmt.Errorf("cannot decode %v into an ObjectId", vr.Type())
+	}))
+	return registry
+}
+
+// mongoURL adds the scheme the driver requires to the bare host lists mgo
+// accepts.
+func mongoURL(url string) string {
+	if strings.Contains(url, "://") {
+		return url
+	}
+	return "mongodb://" + url
+}
+
+// dialMongo connects the official driver and checks the primary answers.
+func dialMongo(ctx context.Context, cfg MongoConfig) (*mongo.Client, error) {
+	opts := options.Client().
+		ApplyURI(mongoURL(cfg.URL)).
+		SetRegistry(mongoRegistry()).
+		SetMaxPoolSize(uint64(cfg.MaxPoolSize)).
+		SetMinPoolSize(uint64(cfg.MinPoolSize)).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetServerSelectionTimeout(cfg.ConnectTimeout).
+		SetTimeout(cfg.OperationTimeout)
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+}
+
+// connectMongo installs the stores on the official driver.
+func connectMongo(ctx context.Context, cfg MongoConfig, autoMigrate bool) error {
+	client, err := dialMongo(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	database := client.Database(cfg.Database)
+	err = prepareSchema(ctx, mongoMigrator(database), autoMigrate)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return err
+	}
+	employees, err := newMongoStore(ctx, database)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return err
+	}
+	mongoClient = client
+	schema = mongoMigrator(database)
+	store = newTracedStore(instrumentedStore{next: employees}, semconv.DBSystemNameMongoDB, "employee")
+	outbox = mongoOutbox{db: database}
+	webhookStore = mongoWebhookStore{db: database}
+	return nil
+}
+
+// newMongoStore returns the mongoStore on database, with transactions
+// where the server runs them.
+func newMongoStore(ctx context.Context, database *mongo.Database) (*mongoStore, error) {
+	transactions, err := supportsTransactions(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	if !transactions {
+		logger.Warn("mongo server is not a replica set, recording events after their writes")
+	}
+	return &mongoStore{db: database, transactions: transactions}, nil
+}
+
+// supportsTransactions reports whether the server is a replica set member
+// or mongos, the deployments that run multi-document transactions.
+func supportsTransactions(ctx context.Context, database *mongo.Database) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := database.RunCommand(ctx, mongobson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	return hello.SetName != "" || hello.Msg == "isdbgrid", err
+}
+
+func mongoError(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// mongoStore is the EmployeeStore on the official MongoDB driver. Each write
+// records its event in the "outbox" collection, in the same transaction
+// where the server runs them. Every call is bounded by the client's
+// operation timeout as well as ctx.
+type mongoStore struct {
+	db           *mongo.Database
+	transactions bool
+}
+
+func (s *mongoStore) collection() *mongo.Collection {
+	return s.db.Collection("employee")
+}
+
+// write runs apply, which returns the employee for the event, together with
+// the outbox insert. Without transactions the entry is inserted after the
+// write, which stands if that fails, so an event may be lost.
+func (s *mongoStore) write(ctx context.Context, eventType string, apply func(ctx context.Context) (Employee, error)) error {
+	record := func(ctx context.Context, employee Employee) error {
+		entry := OutboxEntry{ID: bson.NewObjectId(), Type: eventType, Employee: employee, Time: time.Now()}
+		_, err := s.db.Collection("outbox").InsertOne(ctx, entry)
+		return err
+	}
+	if !s.transactions {
+		employee, err := apply(ctx)
+		if err != nil {
+			return err
+		}
+		if err := record(context.WithoutCancel(ctx), employee); err != nil {
+			logger.Error("recording employee event", "event", eventType, "err", err)
+		}
+		notifyRelay()
+		return nil
+	}
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+		employee, err := apply(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return nil, record(ctx, employee)
+	})
+	if err == nil {
+		notifyRelay()
+	}
+	return err
+}
+
+func (s *mongoStore) Insert(ctx context.Context, employee *Employee) error {
+	if employee.ID == "" {
+		employee.ID = bson.NewObjectId()
+	}
+	err := s.write(ctx, EventEmployeeCreated, func(ctx context.Context) (Employee, error) {
+		_, err := s.collection().InsertOne(ctx, employee)
+		return *employee, err
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return errEmployeeExists
+	}
+	return err
+}
+
+func (s *mongoStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	var employee Employee
+	oid, err := objectID(id)
+	if err != nil {
+		return employee, err
+	}
+	err = s.collection().FindOne(ctx, mongobson.M{"_id": oid}).Decode(&employee)
+	return employee, mongoError(err)
+}
+
+// find runs query with mgoStore's semantics: equality filters, the sort
+// field with ties broken by ascending id, and Fields plus the id.
+func (s *mongoStore) find(ctx context.Context, query EmployeeQuery) (*mongo.Cursor, error) {
+	filter := mongobson.M{}
+	if query.Practice != "" {
+		filter["practice"] = query.Practice
+	}
+	if query.Lastname != "" {
+		filter["lastname"] = query.Lastname
+	}
+	if query.EmpID != 0 {
+		filter["empid"] = query.EmpID
+	}
+	opts := options.Find().SetLimit(int64(query.Limit)).SetSkip(int64(query.Skip))
+	if query.Sort != "" {
+		field, order := strings.TrimPrefix(query.Sort, "-"), 1
+		if field != query.Sort {
+			order = -1
+		}
+		opts.SetSort(mongobson.D{{Key: field, Value: order}, {Key: "_id", Value: 1}})
+	}
+	if len(query.Fields) > 0 {
+		projection := mongobson.D{}
+		for _, field := range query.Fields {
+			projection = append(projection, mongobson.E{Key: field, Value: 1})
+		}
+		opts.SetProjection(projection)
+	}
+	return s.collection().Find(ctx, filter, opts)
+}
+
+func (s *mongoStore) Find(ctx context.Context, query EmployeeQuery) ([]Employee, error) {
+	cursor, err := s.find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var employees []Employee
+	err = cursor.All(ctx, &employees)
+	return employees, err
+}
+
+// Iter streams the results in batches from a cursor.
+func (s *mongoStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	cursor, err := s.find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &mongoIter{ctx: ctx, cursor: cursor}, nil
+}
+
+type mongoIter struct {
+	ctx    context.Context
+	cursor *mongo.Cursor
+	err    error
+}
+
+func (it *mongoIter) Next(employee *Employee) bool {
+	*employee = Employee{}
+	if it.err != nil || !it.cursor.Next(it.ctx) {
+		return false
+	}
+	it.err = it.cursor.Decode(employee)
+	return it.err == nil
+}
+
+func (it *mongoIter) Close() error {
+	err := it.cursor.Err()
+	if closeErr := it.cursor.Close(it.ctx); err == nil {
+		err = closeErr
+	}
+	if it.err != nil {
+		return it.err
+	}
+	return err
+}
+
+func (s *mongoStore) Update(ctx context.Context, id string, employee Employee) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	employee.ID = ""
+	return mongoError(s.write(ctx, EventEmployeeUpdated, func(ctx context.Context) (Employee, error) {
+		var updated Employee
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := s.collection().FindOneAndUpdate(ctx, mongobson.M{"_id": oid}, mongobson.M{"$set": employee}, opts).Decode(&updated)
+		return updated, err
+	}))
+}
+
+func (s *mongoStore) Remove(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	return mongoError(s.write(ctx, EventEmployeeDeleted, func(ctx context.Context) (Employee, error) {
+		var removed Employee
+		err := s.collection().FindOneAndDelete(ctx, mongobson.M{"_id": oid}).Decode(&removed)
+		return removed, err
+	}))
+}
+
+func (s *mongoStore) CountByPractice(ctx context.Context) (map[string]int, error) {
+	pipeline := mongo.Pipeline{{{Key: "$group", Value: mongobson.M{"_id": "$practice", "count": mongobson.M{"$sum": 1}}}}}
+	cursor, err := s.collection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Practice string `bson:"_id"`
+		Count    int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(groups))
+	for _, g := range groups {
+		counts[g.Practice] = g.Count
+	}
+	return counts, nil
+}
+
+// Ping checks the primary; the driver reconnects by itself.
+func (s *mongoStore) Ping(ctx context.Context) error {
+	return s.db.Client().Ping(ctx, readpref.Primary())
+}
+
+// mongoOutbox is the "outbox" collection the mongoStore writes in its
+// transactions.
+type mongoOutbox struct {
+	db *mongo.Database
+}
+
+func (o mongoOutbox) find(ctx context.Context, filter any, opts *options.FindOptions) ([]OutboxEntry, error) {
+	cursor, err := o.db.Collection("outbox").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var entries []OutboxEntry
+	return entries, cursor.All(ctx, &entries)
+}
+
+func (o mongoOutbox) Pending(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	opts := options.Find().SetSort(mongobson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	return o.find(ctx, mongobson.M{"relayed": mongobson.M{"$ne": true}}, opts)
+}
+
+func (o mongoOutbox) Ack(ctx context.Context, ids []bson.ObjectId) error {
+	_, err := o.db.Collection("outbox").UpdateMany(ctx, mongobson.M{"_id": mongobson.M{"$in": ids}}, mongobson.M{"$set": mongobson.M{"relayed": true}})
+	return err
+}
+
+func (o mongoOutbox) Since(ctx context.Context, t time.Time, id bson.ObjectId, limit int) ([]OutboxEntry, error) {
+	filter := mongobson.M{"time": mongobson.M{"$gte": t}}
+	if id != "" {
+		filter = mongobson.M{"$or": mongobson.A{
+			mongobson.M{"time": mongobson.M{"$gt": t}},
+			mongobson.M{"time": t, "_id": mongobson.M{"$gt": id}},
+		}}
+	}
+	opts := options.Find().SetSort(mongobson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	return o.find(ctx, filter, opts)
+}
+
+func (o mongoOutbox) Purge(ctx context.Context, t time.Time) error {
+	_, err := o.db.Collection("outbox").DeleteMany(ctx, mongobson.M{"relayed": true, "time": mongobson.M{"$lt": t}})
+	return err
+}
+
+// Lease keeps the lease in "outbox_lease". Upserting a lease held by
+// another owner fails on the duplicate _id.
+func (o mongoOutbox) Lease(ctx context.Context, owner string, now time.Time) (bool, error) {
+	filter := mongobson.M{"_id": "relay", "$or": mongobson.A{mongobson.M{"owner": owner}, mongobson.M{"expires_at": mongobson.M{"$lt": now}}}}
+	update := mongobson.M{"$set": mongobson.M{"owner": owner, "expires_at": now.Add(outboxLeaseTTL)}}
+	_, err := o.db.Collection("outbox_lease").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// mongoWebhookStore is mgoWebhookStore on the official driver.
+type mongoWebhookStore struct {
+	db *mongo.Database
+}
+
+func (s mongoWebhookStore) subscriptions() *mongo.Collection {
+	return s.db.Collection("webhooks")
+}
+
+func (s mongoWebhookStore) deliveries() *mongo.Collection {
+	return s.db.Collection("webhook_deliveries")
+}
+
+// replace overwrites the document with doc's id.
+func replace(ctx context.Context, c *mongo.Collection, id bson.ObjectId, doc any) error {
+	result, err := c.ReplaceOne(ctx, mongobson.M{"_id": id}, doc)
+	if err == nil && result.MatchedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+func (s mongoWebhookStore) InsertSubscription(ctx context.Context, sub *Subscription) error {
+	_, err := s.subscriptions().InsertOne(ctx, sub)
+	return err
+}
+
+func (s mongoWebhookStore) FindSubscription(ctx context.Context, id string) (Subscription, error) {
+	var sub Subscription
+	oid, err := objectID(id)
+	if err != nil {
+		return sub, err
+	}
+	return sub, mongoError(s.subscriptions().FindOne(ctx, mongobson.M{"_id": oid}).Decode(&sub))
+}
+
+func (s mongoWebhookStore) FindSubscriptions(ctx context.Context) ([]Subscription, error) {
+	cursor, err := s.subscriptions().Find(ctx, mongobson.M{}, options.Find().SetSort(mongobson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	subs := []Subscription{}
+	return subs, cursor.All(ctx, &subs)
+}
+
+func (s mongoWebhookStore) UpdateSubscription(ctx context.Context, sub Subscription) error {
+	return replace(ctx, s.subscriptions(), sub.ID, sub)
+}
+
+func (s mongoWebhookStore) RemoveSubscription(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	result, err := s.subscriptions().DeleteOne(ctx, mongobson.M{"_id": oid})
+	if err == nil && result.DeletedCount == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+func (s mongoWebhookStore) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	_, err := s.deliveries().InsertOne(ctx, delivery)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (s mongoWebhookStore) FindDelivery(ctx context.Context, id string) (Delivery, error) {
+	var delivery Delivery
+	oid, err := objectID(id)
+	if err != nil {
+		return delivery, err
+	}
+	return delivery, mongoError(s.deliveries().FindOne(ctx, mongobson.M{"_id": oid}).Decode(&delivery))
+}
+
+func (s mongoWebhookStore) FindDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	filter := mongobson.M{}
+	if query.SubscriptionID != "" {
+		oid, err := objectID(query.SubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		filter["subscription_id"] = oid
+	}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+	opts := options.Find().SetSort(mongobson.D{{Key: "_id", Value: -1}}).SetLimit(int64(query.Limit))
+	cursor, err := s.deliveries().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []Delivery{}
+	return deliveries, cursor.All(ctx, &deliveries)
+}
+
+func (s mongoWebhookStore) DueDeliveries(ctx context.Context, now time.Time, exclude []bson.ObjectId, limit int) ([]Delivery, error) {
+	filter := mongobson.M{"status": deliveryPending, "next_attempt": mongobson.M{"$lte": now}}
+	if len(exclude) > 0 {
+		filter["subscription_id"] = mongobson.M{"$nin": exclude}
+	}
+	opts := options.Find().SetSort(mongobson.D{{Key: "next_attempt", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := s.deliveries().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []Delivery
+	return deliveries, cursor.All(ctx, &deliveries)
+}
+
+func (s mongoWebhookStore) ClaimDelivery(ctx context.Context, id bson.ObjectId, now, until time.Time) (Delivery, error) {
+	var delivery Delivery
+	filter := mongobson.M{"_id": id, "status": deliveryPending, "next_attempt": mongobson.M{"$lte": now}}
+	update := mongobson.M{"$set": mongobson.M{"next_attempt": until}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	return delivery, mongoError(s.deliveries().FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery))
+}
+
+func (s mongoWebhookStore) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	return replace(ctx, s.deliveries(), delivery.ID, delivery)
+}
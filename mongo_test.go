@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMongoRegistry(t *testing.T) {
+	employee := Employee{ID: bson.NewObjectId(), Firstname: "ravi", EmpID: 3, Salary: 30.5, Practice: "SAP"}
+
+	t.Run("it reads what mgo writes", func(t *testing.T) {
+		data, err := bson.Marshal(employee)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Employee
+		assert.NoError(t, mongobson.UnmarshalWithRegistry(mongoRegistry(), data, &got))
+		assert.Equal(t, employee, got)
+	})
+
+	t.Run("it writes what mgo reads", func(t *testing.T) {
+		data, err := mongobson.MarshalWithRegistry(mongoRegistry(), employee)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Employee
+		assert.NoError(t, bson.Unmarshal(data, &got))
+		assert.Equal(t, employee, got)
+	})
+
+	t.Run("it rejects invalid ids", func(t *testing.T) {
+		_, err := mongobson.MarshalWithRegistry(mongoRegistry(), Employee{ID: "nope"})
+		assert.Error(t, err)
+	})
+}
+
+func TestMongoURL(t *testing.T) {
+	assert.Equal(t, "mongodb://localhost:27017", mongoURL("localhost:27017"))
+	assert.Equal(t, "mongodb+srv://cluster.example.com", mongoURL("mongodb+srv://cluster.example.com"))
+}
+
+// openMongoTest returns the empty scratch database of the Mongo tests,
+// skipping them when no server is running.
+func openMongoTest(t *testing.T) *mongo.Database {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	opts := options.Client().
+		ApplyURI(mongoURL(defaultConfig().Mongo.URL)).
+		SetRegistry(mongoRegistry()).
+		SetServerSelectionTimeout(time.Second)
+	client, err := mongo.Connect(ctx, opts)
+	if err == nil {
+		err = client.Ping(ctx, nil)
+	}
+	if err != nil {
+		t.Skip("mongo unavailable:", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	database := client.Database(storeTestDatabase)
+	database.Drop(ctx)
+	t.Cleanup(func() { database.Drop(context.Background()) })
+	return database
+}
+
+func TestMongoStore(t *testing.T) {
+	database := openMongoTest(t)
+	transactions, err := supportsTransactions(context.Background(), database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEmployeeStore(t, &mongoStore{db: database, transactions: transactions})
+}
+
+func TestMongoMigrations(t *testing.T) {
+	database := openMongoTest(t)
+	ctx := context.Background()
+	m := migrations[*mongo.Database]{log: mongoMigrationLog{db: database}, steps: append(mongoMigrations(),
+		migration[*mongo.Database]{100, "double salaries", func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("employee").UpdateMany(ctx, mongobson.M{}, mongobson.M{"$mul": mongobson.M{"salary": 2}})
+			return err
+		}, func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("employee").UpdateMany(ctx, mongobson.M{}, mongobson.M{"$mul": mongobson.M{"salary": 0.5}})
+			return err
+		}},
+	)}
+	assert.NoError(t, m.migrate(ctx, 2))
+	s := &mongoStore{db: database}
+	ravi := Employee{Firstname: "ravi", Salary: 100}
+	assert.NoError(t, s.Insert(ctx, &ravi))
+
+	assert.NoError(t, m.migrate(ctx, 100))
+	got, _ := s.FindByID(ctx, ravi.ID.Hex())
+	assert.Equal(t, 200.0, got.Salary)
+	assert.NoError(t, m.migrate(ctx, 2))
+	got, _ = s.FindByID(ctx, ravi.ID.Hex())
+	assert.Equal(t, 100.0, got.Salary, "down reverts the backfill")
+
+	t.Run("down skips indexes already dropped", func(t *testing.T) {
+		_, err := database.Collection("employee").Indexes().DropOne(ctx, "practice_1")
+		assert.NoError(t, err)
+		assert.NoError(t, m.migrate(ctx, 0))
+	})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	outboxPoll       = time.Second
+	outboxBatch      = 100
+	outboxBackoff    = 100 * time.Millisecond
+	outboxMaxBackoff = 30 * time.Second
+	// outboxCommitLag bounds how long after its time an entry may commit:
+	// longer than a write transaction runs, plus clock skew between
+	// instances.
+	outboxCommitLag = 30 * time.Second
+	// Relayed entries are kept for outboxRetention, for every instance to
+	// fan them out, and purged every outboxPurgeInterval.
+	outboxRetention     = 10 * time.Minute
+	outboxPurgeInterval = time.Minute
+	// outboxLeaseTTL is how long an instance keeps draining the outbox
+	// unless it renews its lease, after which another instance takes over.
+	outboxLeaseTTL = 30 * time.Second
+)
+
+// OutboxEntry is an employee event recorded in the same transaction as the
+// change it describes, or right after it on a Mongo server without
+// transactions. Its id and time are taken before the transaction
+// commits, so entries can become visible out of order. Relayed is set once
+// the durable publisher has accepted it.
+type OutboxEntry struct {
+	ID       bson.ObjectId `bson:"_id"`
+	Type     string        `bson:"type"`
+	Employee Employee      `bson:"employee"`
+	Time     time.Time     `bson:"time"`
+	Relayed  bool          `bson:"relayed"`
+}
+
+func (e OutboxEntry) event() EmployeeEvent {
+	return EmployeeEvent{ID: e.ID.Hex(), Type: e.Type, Employee: e.Employee, Time: e.Time}
+}
+
+// Outbox holds recorded events until the durable publisher has accepted
+// them and every instance has had outboxRetention to fan them out.
+type Outbox interface {
+	// Pending returns up to limit entries not relayed yet, by id.
+	Pending(ctx context.Context, limit int) ([]OutboxEntry, error)
+	// Ack marks entries relayed.
+	Ack(ctx context.Context, ids []bson.ObjectId) error
+	// Since returns up to limit entries after the one with time t and id,
+	// by time and then id. An empty id starts at t.
+	Since(ctx context.Context, t time.Time, id bson.ObjectId, limit int) ([]OutboxEntry, error)
+	// Purge removes relayed entries from before t.
+	Purge(ctx context.Context, t time.Time) error
+	// Lease takes or renews the lease on draining the outbox for owner
+	// until now plus outboxLeaseTTL, reporting false while another owner
+	// holds it.
+	Lease(ctx context.Context, owner string, now time.Time) (bool, error)
+}
+
+// Publisher delivers events beyond the store. The relay only acks an entry
+// once its durable publisher returns nil, so an event may be published
+// again after a crash.
+type Publisher interface {
+	Publish(ctx context.Context, event EmployeeEvent) error
+}
+
+// busPublisher publishes to an in-process eventBus, which feeds the event
+// streams and the cache.
+type busPublisher struct {
+	bus *eventBus
+}
+
+func (p busPublisher) Publish(ctx context.Context, event EmployeeEvent) error {
+	p.bus.Publish(event)
+	return nil
+}
+
+// employeePublisher is the relay's durable and local publishing in one, for
+// events that do not go through the outbox: it stores the webhook
+// deliveries, once the dispatcher runs, and then publishes on bus.
+type employeePublisher struct {
+	bus *eventBus
+}
+
+func (p employeePublisher) Publish(ctx context.Context, event EmployeeEvent) error {
+	if webhooks != nil {
+		if err := webhooks.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	p.bus.Publish(event)
+	return nil
+}
+
+// outbox is installed with store once Mongo is connected.
+var outbox Outbox
+
+// relay is installed by run while the server is up.
+var relay *outboxRelay
+
+// notifyRelay lets the relay know that entries were written.
+func notifyRelay() {
+	if relay != nil {
+		relay.notify()
+	}
+}
+
+// outboxRelay moves entries from the outbox to the publishers. Entries are
+// acked once the durable publisher, shared by all instances, accepts them;
+// it is retried with backoff, so no event is skipped. Only the instance
+// holding the outbox lease publishes to it, so that entries go out in
+// order and, short of a lease lapsing mid-batch, once. Every instance also
+// fans entries out to its local publisher from a cursor of its own, so that
+// its streams see the writes made on other instances.
+type outboxRelay struct {
+	owner   string
+	durable Publisher
+	local   Publisher
+	wake    chan struct{}
+	cursor  outboxCursor
+	purged  time.Time
+}
+
+// outboxCursor is how far an instance has fanned the outbox out. As entries
+// can become visible after later ones, it reads from outboxCommitLag before
+// the newest entry seen and skips the entries it has published.
+type outboxCursor struct {
+	newest time.Time
+	seen   map[bson.ObjectId]time.Time
+}
+
+func newOutboxRelay(durable, local Publisher) *outboxRelay {
+	return &outboxRelay{
+		owner:   lockOwner() + "/" + bson.NewObjectId().Hex(),
+		durable: durable,
+		local:   local,
+		wake:    make(chan struct{}, 1),
+		cursor:  outboxCursor{newest: time.Now(), seen: make(map[bson.ObjectId]time.Time)},
+	}
+}
+
+func (r *outboxRelay) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run relays entries whenever woken, and at least every outboxPoll, until
+// ctx is cancelled.
+func (r *outboxRelay) run(ctx context.Context) {
+	delay := outboxBackoff
+	for {
+		wait := outboxPoll
+		if storeReady.Load() {
+			if err := errors.Join(r.drain(ctx), r.fanOut(ctx), r.purge(ctx, time.Now())); err != nil {
+				logger.Error("relaying outbox", "err", err, "retry_in", delay)
+				wait, delay = delay, min(delay*2, outboxMaxBackoff)
+			} else {
+				delay = outboxBackoff
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// drain publishes pending entries to the durable publisher until none are
+// left, renewing the lease before each batch. Without the lease it leaves
+// them to the instance holding it.
+func (r *outboxRelay) drain(ctx context.Context) error {
+	for {
+		held, err := outbox.Lease(ctx, r.owner, time.Now())
+		if err != nil || !held {
+			return err
+		}
+		pending, err := outbox.Pending(ctx, outboxBatch)
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+		published := make([]bson.ObjectId, 0, len(pending))
+		for _, entry := range pending {
+			if err = r.durable.Publish(ctx, entry.event()); err != nil {
+				break
+			}
+			published = append(published, entry.ID)
+		}
+		if len(published) > 0 {
+			if ackErr := outbox.Ack(ctx, published); ackErr != nil {
+				return ackErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fanOut publishes the entries this instance has not seen to the local
+// publisher.
+func (r *outboxRelay) fanOut(ctx context.Context) error {
+	t, id := r.cursor.newest.Add(-outboxCommitLag), bson.ObjectId("")
+	for {
+		entries, err := outbox.Since(ctx, t, id, outboxBatch)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			t, id = entry.Time, entry.ID
+			if _, ok := r.cursor.seen[entry.ID]; ok {
+				continue
+			}
+			if err := r.local.Publish(ctx, entry.event()); err != nil {
+				return err
+			}
+			r.cursor.seen[entry.ID] = entry.Time
+			if entry.Time.After(r.cursor.newest) {
+				r.cursor.newest = entry.Time
+			}
+		}
+		if len(entries) < outboxBatch {
+			break
+		}
+	}
+	horizon := r.cursor.newest.Add(-outboxCommitLag)
+	for id, t := range r.cursor.seen {
+		if t.Before(horizon) {
+			delete(r.cursor.seen, id)
+		}
+	}
+	return nil
+}
+
+// purge removes the relayed entries older than outboxRetention, every
+// outboxPurgeInterval.
+func (r *outboxRelay) purge(ctx context.Context, now time.Time) error {
+	if now.Sub(r.purged) < outboxPurgeInterval {
+		return nil
+	}
+	if err := outbox.Purge(ctx, now.Add(-outboxRetention)); err != nil {
+		return err
+	}
+	r.purged = now
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memoryOutbox is an in-memory Outbox for tests.
+type memoryOutbox struct {
+	mu      sync.Mutex
+	entries []OutboxEntry
+	acks    int
+	owner   string
+	expires time.Time
+}
+
+func (o *memoryOutbox) add(eventType string, employee Employee) {
+	o.addAt(eventType, employee, time.Now())
+}
+
+func (o *memoryOutbox) addAt(eventType string, employee Employee, t time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries = append(o.entries, OutboxEntry{ID: bson.NewObjectId(), Type: eventType, Employee: employee, Time: t})
+}
+
+func (o *memoryOutbox) Pending(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var pending []OutboxEntry
+	for _, e := range o.entries {
+		if !e.Relayed && len(pending) < limit {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+func (o *memoryOutbox) Ack(ctx context.Context, ids []bson.ObjectId) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.acks++
+	for i, e := range o.entries {
+		if slices.Contains(ids, e.ID) {
+			o.entries[i].Relayed = true
+		}
+	}
+	return nil
+}
+
+func (o *memoryOutbox) Since(ctx context.Context, t time.Time, id bson.ObjectId, limit int) ([]OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var entries []OutboxEntry
+	for _, e := range o.entries {
+		if e.Time.After(t) || e.Time.Equal(t) && e.ID > id {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b OutboxEntry) int {
+		return cmp.Or(a.Time.Compare(b.Time), strings.Compare(string(a.ID), string(b.ID)))
+	})
+	return entries[:min(limit, len(entries))], nil
+}
+
+func (o *memoryOutbox) Purge(ctx context.Context, t time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries = slices.DeleteFunc(o.entries, func(e OutboxEntry) bool { return e.Relayed && e.Time.Before(t) })
+	return nil
+}
+
+// pending counts the entries not relayed yet.
+func (o *memoryOutbox) Lease(ctx context.Context, owner string, now time.Time) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.owner != owner && !o.expires.Before(now) {
+		return false, nil
+	}
+	o.owner, o.expires = owner, now.Add(outboxLeaseTTL)
+	return true, nil
+}
+
+func (o *memoryOutbox) pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, e := range o.entries {
+		if !e.Relayed {
+			n++
+		}
+	}
+	return n
+}
+
+// flakyPublisher fails every publish while failing is set.
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failing   bool
+	published []EmployeeEvent
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event EmployeeEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func useMemoryOutbox(t *testing.T) *memoryOutbox {
+	saved := outbox
+	mem := &memoryOutbox{}
+	outbox = mem
+	t.Cleanup(func() { outbox = saved })
+	return mem
+}
+
+func TestOutboxRelay(t *testing.T) {
+	useMemoryStore(t)
+	mem := useMemoryOutbox(t)
+	publisher := &flakyPublisher{}
+	r := newOutboxRelay(publisher, &flakyPublisher{})
+	ctx := context.Background()
+
+	for i := 1; i <= outboxBatch+5; i++ {
+		mem.add(EventEmployeeCreated, Employee{EmpID: i})
+	}
+
+	t.Run("it publishes in order and acks", func(t *testing.T) {
+		assert.NoError(t, r.drain(ctx))
+		assert.Zero(t, mem.pending())
+		assert.Equal(t, 2, mem.acks)
+		if assert.Len(t, publisher.published, outboxBatch+5) {
+			for i, event := range publisher.published {
+				assert.Equal(t, i+1, event.Employee.EmpID)
+			}
+		}
+	})
+
+	t.Run("it keeps entries until they are published", func(t *testing.T) {
+		publisher.failing = true
+		mem.add(EventEmployeeUpdated, Employee{EmpID: 1})
+		mem.add(EventEmployeeDeleted, Employee{EmpID: 1})
+		assert.Error(t, r.drain(ctx))
+		assert.Equal(t, 2, mem.pending())
+
+		publisher.failing = false
+		assert.NoError(t, r.drain(ctx))
+		n := len(publisher.published)
+		assert.Equal(t, EventEmployeeUpdated, publisher.published[n-2].Type)
+		assert.Equal(t, EventEmployeeDeleted, publisher.published[n-1].Type)
+	})
+}
+
+func TestOutboxRelayRun(t *testing.T) {
+	useMemoryStore(t)
+	mem := useMemoryOutbox(t)
+	bus := newEventBus()
+	ch, unsubscribe := bus.Subscribe(4)
+	defer unsubscribe()
+	r := newOutboxRelay(&flakyPublisher{}, busPublisher{bus: bus})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		r.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	mem.add(EventEmployeeCreated, Employee{Firstname: "aditi"})
+	r.notify()
+	select {
+	case event := <-ch:
+		assert.Equal(t, EventEmployeeCreated, event.Type)
+		assert.Equal(t, "aditi", event.Employee.Firstname)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not relayed")
+	}
+}
+
+func TestOutboxFanOut(t *testing.T) {
+	useMemoryStore(t)
+	mem := useMemoryOutbox(t)
+	ctx := context.Background()
+	// Two instances relaying the same outbox.
+	durable := &flakyPublisher{}
+	local1, local2 := &flakyPublisher{}, &flakyPublisher{}
+	r1, r2 := newOutboxRelay(durable, local1), newOutboxRelay(durable, local2)
+
+	for i := 1; i <= outboxBatch+5; i++ {
+		mem.add(EventEmployeeCreated, Employee{EmpID: i})
+	}
+	assert.NoError(t, r1.drain(ctx))
+	assert.NoError(t, r1.fanOut(ctx))
+	assert.NoError(t, r2.fanOut(ctx))
+	assert.Len(t, durable.published, outboxBatch+5, "entries are acked once")
+	assert.Len(t, local1.published, outboxBatch+5)
+	assert.Len(t, local2.published, outboxBatch+5, "acked entries still reach every instance")
+
+	t.Run("it picks up entries committed late and skips those it has seen", func(t *testing.T) {
+		mem.addAt(EventEmployeeUpdated, Employee{EmpID: 1}, time.Now().Add(-outboxCommitLag/2))
+		assert.NoError(t, r1.fanOut(ctx))
+		assert.NoError(t, r1.fanOut(ctx))
+		if assert.Len(t, local1.published, outboxBatch+6) {
+			assert.Equal(t, EventEmployeeUpdated, local1.published[outboxBatch+5].Type)
+		}
+	})
+
+	t.Run("only the lease holder drains", func(t *testing.T) {
+		mem.add(EventEmployeeUpdated, Employee{EmpID: 2})
+		pending := mem.pending()
+		assert.NoError(t, r2.drain(ctx))
+		assert.Equal(t, pending, mem.pending(), "r1 holds the lease")
+		assert.NoError(t, r1.drain(ctx))
+		assert.Zero(t, mem.pending())
+
+		mem.expires = time.Now().Add(-time.Second)
+		mem.add(EventEmployeeUpdated, Employee{EmpID: 2})
+		assert.NoError(t, r2.drain(ctx))
+		assert.Zero(t, mem.pending(), "r2 takes over a lapsed lease")
+	})
+
+	t.Run("a failing durable publisher does not hold up the streams", func(t *testing.T) {
+		durable.failing = true
+		mem.add(EventEmployeeDeleted, Employee{EmpID: 2})
+		assert.Error(t, r2.drain(ctx))
+		assert.NoError(t, r2.fanOut(ctx))
+		assert.Equal(t, EventEmployeeDeleted, local2.published[len(local2.published)-1].Type)
+		durable.failing = false
+	})
+
+	t.Run("it purges relayed entries after the retention", func(t *testing.T) {
+		old := time.Now().Add(-2 * outboxRetention)
+		mem.addAt(EventEmployeeCreated, Employee{EmpID: 3}, old)
+		mem.addAt(EventEmployeeCreated, Employee{EmpID: 4}, old)
+		pending, _ := mem.Pending(ctx, outboxBatch)
+		assert.NoError(t, mem.Ack(ctx, []bson.ObjectId{pending[len(pending)-1].ID}))
+		before := len(mem.entries)
+		assert.NoError(t, r1.purge(ctx, time.Now()))
+		assert.Len(t, mem.entries, before-1, "unrelayed entries are kept")
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RateLimit describes a token bucket: Rate tokens are added per second up to
+// a maximum of Burst.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate" toml:"rate"`
+	Burst int     `yaml:"burst" toml:"burst"`
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimiter takes tokens from the bucket identified by key. Implementations
+// backed by a shared store (redis, memcached...) can be plugged in so that
+// several API instances enforce one quota.
+type RateLimiter interface {
+	Allow(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitPolicy maps routes to limits. Routes are keyed by method and mux
+// path template, e.g. "GET /employees"; unlisted routes use Default.
+type RateLimitPolicy struct {
+	Default RateLimit            `yaml:"default" toml:"default"`
+	Routes  map[string]RateLimit `yaml:"routes" toml:"routes"`
+}
+
+var rateLimitPolicy = defaultConfig().RateLimit
+
+var rateLimiter RateLimiter = newMemoryRateLimiter()
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// limitFor returns the limit that applies to the given method and route.
+func (p RateLimitPolicy) limitFor(method, template string) RateLimit {
+	if limit, ok := p.Routes[method+" "+template]; ok {
+		return limit
+	}
+	return p.Default
+}
+
+// tokenBucket keeps the limit it was last taken from, so that sweeps judge
+// each bucket by its own route's limit.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+// memoryRateLimiter is an in-process RateLimiter. Buckets that have been idle
+// long enough to refill completely are dropped on the next sweep.
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+const rateLimitSweepInterval = time.Minute
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+func (m *memoryRateLimiter) Allow(key string, limit RateLimit) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	burst := float64(limit.Burst)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	b.limit = limit
+
+	result := RateLimitResult{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / limit.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsToDuration((burst - b.tokens) / limit.Rate)
+	return result, nil
+}
+
+// sweep drops buckets that would be full by now. It runs at most once per
+// rateLimitSweepInterval so that Allow stays cheap.
+func (m *memoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// rateLimitKey identifies the caller: its identity once authentication has
+// verified it, otherwise the client IP. Unverified credentials are ignored,
+// as callers could pick a fresh bucket for every request by making them up.
+func rateLimitKey(request *http.Request) string {
+	if identity, ok := identityFrom(request.Context()); ok {
+		return "id:" + identity.Subject
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimitMiddleware enforces policy for every route matched by the router.
+// Limiter errors fail open so that an unavailable shared backend does not
+// take the API down with it.
+func rateLimitMiddleware(limiter RateLimiter, policy RateLimitPolicy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			template := request.URL.Path
+			if route := mux.CurrentRoute(request); route != nil {
+				if t, err := route.GetPathTemplate(); err == nil {
+					template = t
+				}
+			}
+			key := request.Method + " " + template + " " + rateLimitKey(request)
+			result, err := limiter.Allow(key, policy.limitFor(request.Method, template))
+			if err != nil {
+				logger.WarnContext(request.Context(), "rate limiter unavailable", "err", err)
+				next.ServeHTTP(response, request)
+				return
+			}
+			setRateLimitHeaders(response, result)
+			if !result.Allowed {
+				setResponseHeader(response)
+				response.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				writeError(response, request, http.StatusTooManyRequests, errRateLimited)
+				return
+			}
+			next.ServeHTTP(response, request)
+		})
+	}
+}
+
+func setRateLimitHeaders(response http.ResponseWriter, result RateLimitResult) {
+	response.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	response.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	response.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
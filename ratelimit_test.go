@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(key string, limit RateLimit) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("backend unavailable")
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := RateLimit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		result, _ := limiter.Allow("client", limit)
+		if !result.Allowed {
+			t.Fatalf("request %d was rejected within burst", i)
+		}
+	}
+	result, _ := limiter.Allow("client", limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	t.Run("it keeps buckets per key", func(t *testing.T) {
+		result, _ := limiter.Allow("other", limit)
+		assert.True(t, result.Allowed)
+	})
+
+	t.Run("it refills over time", func(t *testing.T) {
+		now = now.Add(time.Second)
+		result, _ := limiter.Allow("client", limit)
+		assert.True(t, result.Allowed)
+	})
+
+	t.Run("it drops idle buckets", func(t *testing.T) {
+		now = now.Add(rateLimitSweepInterval)
+		limiter.Allow("client", limit)
+		assert.Len(t, limiter.buckets, 1)
+	})
+
+	t.Run("it sweeps each bucket by its own limit", func(t *testing.T) {
+		slow := RateLimit{Rate: 0.001, Burst: 10}
+		limiter.Allow("slow", slow)
+		now = now.Add(rateLimitSweepInterval)
+		limiter.Allow("client", limit)
+		assert.Contains(t, limiter.buckets, "slow", "a slow bucket still refilling is kept")
+	})
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/employees", func(response http.ResponseWriter, request *http.Request) {}).Methods("GET")
+	policy := RateLimitPolicy{
+		Default: RateLimit{Rate: 100, Burst: 100},
+		Routes:  map[string]RateLimit{"GET /employees": {Rate: 1, Burst: 1}},
+	}
+	r.Use(rateLimitMiddleware(newMemoryRateLimiter(), policy))
+
+	req, _ := http.NewRequest("GET", "/employees", nil)
+	req.RemoteAddr = "10.0.0.1:4000"
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusTooManyRequests)
+	}
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	t.Run("it fails open when the limiter errors", func(t *testing.T) {
+		r := mux.NewRouter()
+		r.HandleFunc("/employees", func(response http.ResponseWriter, request *http.Request) {})
+		r.Use(rateLimitMiddleware(failingLimiter{}, policy))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestRateLimitKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/employees", nil)
+	req.RemoteAddr = "10.0.0.1:4000"
+	assert.Equal(t, "ip:10.0.0.1", rateLimitKey(req))
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"jdoe"}`))
+	req.Header.Set("Authorization", "Bearer header."+payload+".signature")
+	req.Header.Set("X-API-Key", "secret")
+	assert.Equal(t, "ip:10.0.0.1", rateLimitKey(req), "unverified credentials are ignored")
+
+	req = req.WithContext(context.WithValue(req.Context(), identityKey{}, Identity{Subject: "payroll-service", Source: "mtls"}))
+	assert.Equal(t, "id:payroll-service", rateLimitKey(req))
+}
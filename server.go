@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errDraining = errors.New("shutting down")
+
+// draining is set once shutdown starts so that load balancers stop routing
+// new requests here while in-flight ones finish.
+var draining atomic.Bool
+
+func init() {
+	registerHealthCheck("server", func(ctx context.Context) error {
+		if draining.Load() {
+			return errDraining
+		}
+		return nil
+	})
+}
+
+// newServer returns an http.Server with timeouts so that slow clients cannot
+// hold connections forever.
+func newServer(cfg Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+}
+
+// run serves the API until ctx is cancelled, then shuts down gracefully.
+func run(ctx context.Context, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	shutdownTracing, err := setupTracing(ctx, cfg.TraceExporter)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	redirected := make(chan error, 1)
+	var tlsConfig, grpcTLSConfig *tls.Config
+	if cfg.TLS.enabled() {
+		reloader, err := newCertReloader(cfg.TLS)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		go reloader.watch(ctx)
+		tlsConfig = reloader.tlsConfig()
+		grpcTLSConfig = reloader.tlsConfig("h2")
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	grpcDone := make(chan error, 1)
+	if cfg.GRPCAddr != "" {
+		grpcListener, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		go func() {
+			grpcDone <- serveGRPC(ctx, newGRPCServer(grpcTLSConfig, ctx.Done()), grpcListener, cfg.Server.ShutdownTimeout)
+		}()
+		logger.Info("starting gRPC", "addr", grpcListener.Addr().String())
+	} else {
+		grpcDone <- nil
+	}
+	if cfg.TLS.RedirectAddr != "" {
+		redirectCfg := cfg
+		redirectCfg.Addr = cfg.TLS.RedirectAddr
+		redirectListener, err := net.Listen("tcp", redirectCfg.Addr)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		go func() {
+			redirected <- serve(ctx, redirectCfg, newServer(redirectCfg, redirectToHTTPS(cfg.Addr)), redirectListener)
+		}()
+	} else {
+		redirected <- nil
+	}
+
+	runner, err := newJobRunner(cfg.Jobs)
+	if err == nil {
+		err = runner.start(ctx)
+	}
+	if err != nil {
+		listener.Close()
+		cancel()
+		return errors.Join(err, <-grpcDone, <-redirected)
+	}
+	jobs = runner
+	// background holds what uses the store, so that it is closed only once
+	// they have stopped.
+	var background sync.WaitGroup
+	goBackground := func(f func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			f()
+		}()
+	}
+	webhooks = newWebhookDispatcher(cfg.Webhooks)
+	goBackground(func() { webhooks.run(ctx) })
+	relay = newOutboxRelay(webhooks, busPublisher{bus: events})
+	goBackground(func() { relay.run(ctx) })
+	if cfg.Watch.Mode != "off" {
+		watcher := newEmployeeWatcher(cfg.Watch, cfg.Mongo, employeePublisher{bus: events})
+		goBackground(func() { watcher.run(ctx) })
+	}
+
+	go func() {
+		<-ctx.Done()
+		closeStreams()
+	}()
+	registerStoreChecks(cfg.Backend)
+	goBackground(func() { connectStore(ctx, cfg) })
+	logger.Info("starting the application", "addr", listener.Addr().String(), "tls", cfg.TLS.enabled())
+	err = serve(ctx, cfg, newServer(cfg, newHandler(cfg)), listener)
+	cancel()
+	err = errors.Join(err, <-grpcDone, <-redirected)
+	runner.wait()
+	background.Wait()
+	closeStore()
+	return err
+}
+
+// serve runs server on listener until ctx is cancelled. Readiness then fails
+// for the drain delay so that load balancers notice before the listener
+// closes, and in-flight requests get up to the shutdown timeout to finish.
+func serve(ctx context.Context, cfg Config, server *http.Server, listener net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", "drain_delay", cfg.Server.DrainDelay)
+	draining.Store(true)
+	time.Sleep(cfg.Server.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; err != http.ErrServerClosed {
+		return err
+	}
+	logger.Info("server stopped")
+	return nil
+}
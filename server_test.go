@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	defer draining.Store(false)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cfg := defaultConfig()
+	cfg.Server.DrainDelay = 10 * time.Millisecond
+	server := newServer(cfg, http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		close(started)
+		<-release
+		response.Write([]byte("done"))
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, cfg, server, listener)
+	}()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			t.Error(err)
+		}
+		responses <- resp
+	}()
+	<-started
+	cancel()
+
+	time.Sleep(5 * time.Millisecond)
+	report := runHealthChecks(context.Background())
+	assert.Equal(t, "down", report.Checks["server"].Status)
+
+	close(release)
+	resp := <-responses
+	if resp != nil {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.NoError(t, <-served)
+}
@@ -0,0 +1,813 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"gopkg.in/mgo.v2/bson"
+	_ "modernc.org/sqlite"
+)
+
+// SQLConfig locates the database of the sqlite and postgres backends. The
+// DSN is a file name or "file:" URI for SQLite and a URL or key=value
+// string for PostgreSQL.
+type SQLConfig struct {
+	DSN          string `yaml:"dsn" toml:"dsn"`
+	MaxOpenConns int    `yaml:"max_open_conns" toml:"max_open_conns"`
+}
+
+// sqlDB is the database of the SQL backends, when one is configured.
+var sqlDB *sql.DB
+
+// sqlDialect holds what differs between the SQL backends.
+type sqlDialect struct {
+	name   string
+	driver string
+	// system is the db.system.name of traces.
+	system attribute.KeyValue
+	// text is the column type of sortable strings. PostgreSQL sorts by
+	// byte, as Mongo and SQLite do, under the "C" collation.
+	text string
+	// noLimit is the LIMIT of a query with an OFFSET but no limit.
+	noLimit string
+}
+
+var sqlDialects = map[string]sqlDialect{
+	"sqlite":   {name: "sqlite", driver: "sqlite", system: semconv.DBSystemNameSQLite, text: "TEXT", noLimit: "-1"},
+	"postgres": {name: "postgres", driver: "pgx", system: semconv.DBSystemNamePostgreSQL, text: `TEXT COLLATE "C"`, noLimit: "ALL"},
+}
+
+// rebind rewrites the $n placeholders queries are written with for the
+// dialect. SQLite numbers $n parameters in order of appearance, so they
+// become ?n.
+func (d sqlDialect) rebind(query string) string {
+	if d.name == "sqlite" {
+		return strings.ReplaceAll(query, "$", "?")
+	}
+	return query
+}
+
+// dsn adds the busy timeout and write-ahead log that let SQLite serve
+// concurrent requests, unless the DSN sets pragmas itself.
+func (d sqlDialect) dsn(dsn string) string {
+	if d.name != "sqlite" || strings.Contains(dsn, "_pragma=") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+
+// sqlTx is the transaction SQL migrations run in.
+type sqlTx struct {
+	*sql.Tx
+	dialect sqlDialect
+}
+
+// sqlExec returns a migration step running statements, in which {text}
+// stands for the dialect's sortable string type.
+func sqlExec(statements ...string) func(ctx context.Context, tx sqlTx) error {
+	return func(ctx context.Context, tx sqlTx) error {
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, strings.ReplaceAll(statement, "{text}", tx.dialect.text)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// sqlMigrations are the steps of the SQL backends. Released migrations
+// must not change; add a new version instead.
+var sqlMigrations = []migration[sqlTx]{
+	{1, "create employee", sqlExec(
+		`CREATE TABLE employee (
+			id TEXT PRIMARY KEY,
+			firstname {text} NOT NULL DEFAULT '',
+			lastname {text} NOT NULL DEFAULT '',
+			empid BIGINT NOT NULL DEFAULT 0,
+			salary DOUBLE PRECISION NOT NULL DEFAULT 0,
+			practice {text} NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX employee_practice ON employee (practice)`,
+		`CREATE INDEX employee_lastname ON employee (lastname)`,
+		`CREATE INDEX employee_empid ON employee (empid)`,
+	), sqlExec(`DROP TABLE employee`)},
+	{2, "create outbox", sqlExec(
+		`CREATE TABLE outbox (
+			id TEXT PRIMARY KEY,
+			type TEXT NOT NULL,
+			employee TEXT NOT NULL,
+			time BIGINT NOT NULL
+		)`,
+	), sqlExec(`DROP TABLE outbox`)},
+	{3, "create webhooks", sqlExec(
+		`CREATE TABLE webhooks (
+			id TEXT PRIMARY KEY,
+			doc TEXT NOT NULL
+		)`,
+		`CREATE TABLE webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			subscription_id TEXT NOT NULL,
+			status TEXT NOT NULL,
+			next_attempt BIGINT NOT NULL,
+			doc TEXT NOT NULL
+		)`,
+		`CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt)`,
+		`CREATE INDEX webhook_deliveries_subscription ON webhook_deliveries (subscription_id)`,
+	), sqlExec(`DROP TABLE webhook_deliveries`, `DROP TABLE webhooks`)},
+	{4, "keep relayed outbox entries", sqlExec(
+		`ALTER TABLE outbox ADD COLUMN relayed BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE INDEX outbox_relayed ON outbox (relayed, id)`,
+		`CREATE INDEX outbox_time ON outbox (time, id)`,
+	), sqlExec(`DROP INDEX outbox_time`, `DROP INDEX outbox_relayed`, `ALTER TABLE outbox DROP COLUMN relayed`)},
+	{5, "create outbox lease", sqlExec(
+		`CREATE TABLE outbox_lease (
+			id INTEGER PRIMARY KEY,
+			owner TEXT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+	), sqlExec(`DROP TABLE outbox_lease`)},
+}
+
+// sqlMigrationLog records migrations in schema_migrations and locks with
+// the row of schema_migrations_lock.
+type sqlMigrationLog struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func sqlMigrator(database *sql.DB, d sqlDialect) migrator {
+	return migrations[sqlTx]{log: sqlMigrationLog{db: database, dialect: d}, steps: sqlMigrations}
+}
+
+func (l sqlMigrationLog) createTables(ctx context.Context) error {
+	_, err := l.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err == nil {
+		_, err = l.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+			id INTEGER PRIMARY KEY,
+			owner TEXT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`)
+	}
+	return err
+}
+
+func (l sqlMigrationLog) lock(ctx context.Context) (func(), error) {
+	if err := l.createTables(ctx); err != nil {
+		return nil, err
+	}
+	owner := lockOwner()
+	err := acquireLock(ctx, func(now time.Time) (bool, error) {
+		_, err := l.db.ExecContext(ctx, l.dialect.rebind(`DELETE FROM schema_migrations_lock WHERE id = 1 AND expires_at < $1`), now.UnixNano())
+		if err != nil {
+			return false, err
+		}
+		result, err := l.db.ExecContext(ctx, l.dialect.rebind(`INSERT INTO schema_migrations_lock (id, owner, expires_at)
+			VALUES (1, $1, $2) ON CONFLICT (id) DO NOTHING`), owner, now.Add(migrationLockTTL).UnixNano())
+		if err != nil {
+			return false, err
+		}
+		n, err := result.RowsAffected()
+		return n == 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	stop := renewLock(func(expires time.Time) error {
+		result, err := l.db.ExecContext(context.Background(), l.dialect.rebind(`UPDATE schema_migrations_lock SET expires_at = $1 WHERE id = 1 AND owner = $2`),
+			expires.UnixNano(), owner)
+		if err == nil {
+			var n int64
+			if n, err = result.RowsAffected(); err == nil && n == 0 {
+				err = errMigrationLockLost
+			}
+		}
+		return err
+	})
+	return func() {
+		stop()
+		l.db.ExecContext(context.Background(), l.dialect.rebind(`DELETE FROM schema_migrations_lock WHERE id = 1 AND owner = $1`), owner)
+	}, nil
+}
+
+func (l sqlMigrationLog) applied(ctx context.Context) (map[int]bool, error) {
+	if err := l.createTables(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := l.db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (l sqlMigrationLog) apply(ctx context.Context, m migration[sqlTx], up bool) error {
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if up {
+		err = m.up(ctx, sqlTx{tx, l.dialect})
+		if err == nil {
+			_, err = tx.ExecContext(ctx, l.dialect.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`),
+				m.version, m.name, time.Now().Unix())
+		}
+	} else {
+		err = m.down(ctx, sqlTx{tx, l.dialect})
+		if err == nil {
+			_, err = tx.ExecContext(ctx, l.dialect.rebind(`DELETE FROM schema_migrations WHERE version = $1`), m.version)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// openSQL opens and checks the database of backend.
+func openSQL(ctx context.Context, backend string, cfg SQLConfig) (*sql.DB, error) {
+	dialect := sqlDialects[backend]
+	database, err := sql.Open(dialect.driver, dialect.dsn(cfg.DSN))
+	if err != nil {
+		return nil, err
+	}
+	database.SetMaxOpenConns(cfg.MaxOpenConns)
+	if err := database.PingContext(ctx); err != nil {
+		database.Close()
+		return nil, err
+	}
+	return database, nil
+}
+
+// connectSQL opens the database of backend, prepares its schema and
+// installs the stores.
+func connectSQL(ctx context.Context, backend string, cfg SQLConfig, autoMigrate bool) error {
+	dialect := sqlDialects[backend]
+	database, err := openSQL(ctx, backend, cfg)
+	if err != nil {
+		return err
+	}
+	m := sqlMigrator(database, dialect)
+	if err := prepareSchema(ctx, m, autoMigrate); err != nil {
+		database.Close()
+		return err
+	}
+	sqlDB = database
+	schema = m
+	store = newTracedStore(instrumentedStore{next: &sqlStore{db: database, dialect: dialect}}, dialect.system, "employee")
+	outbox = sqlOutbox{db: database, dialect: dialect}
+	webhookStore = sqlWebhookStore{db: database, dialect: dialect}
+	return nil
+}
+
+// sqlStore is the EmployeeStore on the "employee" table. Ids are the hex of
+// an ObjectId, as with Mongo, and each write records its event in the
+// "outbox" table in the same transaction.
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// employeeSQLColumns are the columns of a whole employee, in scan order.
+var employeeSQLColumns = []string{"id", "firstname", "lastname", "empid", "salary", "practice"}
+
+// scanEmployee reads a row holding columns.
+func scanEmployee(row interface{ Scan(dest ...any) error }, columns []string) (Employee, error) {
+	var employee Employee
+	var id string
+	dest := make([]any, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			dest[i] = &id
+		case "firstname":
+			dest[i] = &employee.Firstname
+		case "lastname":
+			dest[i] = &employee.Lastname
+		case "empid":
+			dest[i] = &employee.EmpID
+		case "salary":
+			dest[i] = &employee.Salary
+		case "practice":
+			dest[i] = &employee.Practice
+		}
+	}
+	if err := row.Scan(dest...); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		return employee, err
+	}
+	employee.ID = bson.ObjectIdHex(id)
+	return employee, nil
+}
+
+// write runs apply, which returns the employee for the event, in a
+// transaction together with the outbox insert.
+func (s *sqlStore) write(ctx context.Context, eventType string, apply func(tx *sql.Tx) (Employee, error)) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	employee, err := apply(tx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(employee)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO outbox (id, type, employee, time) VALUES ($1, $2, $3, $4)`),
+		bson.NewObjectId().Hex(), eventType, string(data), time.Now().UnixNano())
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err == nil {
+		notifyRelay()
+	}
+	return err
+}
+
+func (s *sqlStore) Insert(ctx context.Context, employee *Employee) error {
+	if employee.ID == "" {
+		employee.ID = bson.NewObjectId()
+	}
+	return s.write(ctx, EventEmployeeCreated, func(tx *sql.Tx) (Employee, error) {
+		result, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO employee (id, firstname, lastname, empid, salary, practice)
+			VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (id) DO NOTHING`),
+			employee.ID.Hex(), employee.Firstname, employee.Lastname, employee.EmpID, employee.Salary, employee.Practice)
+		if err != nil {
+			return *employee, err
+		}
+		n, err := result.RowsAffected()
+		if err == nil && n == 0 {
+			err = errEmployeeExists
+		}
+		return *employee, err
+	})
+}
+
+func (s *sqlStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return Employee{}, err
+	}
+	row := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT `+strings.Join(employeeSQLColumns, ", ")+` FROM employee WHERE id = $1`), oid.Hex())
+	return scanEmployee(row, employeeSQLColumns)
+}
+
+// query builds the SELECT for query with mgoStore's semantics: equality
+// filters, the sort field with ties broken by ascending id, and Fields plus
+// the id. It returns the selected columns with the statement.
+func (s *sqlStore) query(query EmployeeQuery) (string, []any, []string, error) {
+	columns := employeeSQLColumns
+	if len(query.Fields) > 0 {
+		columns = []string{"id"}
+		for _, field := range query.Fields {
+			if !employeeFields[field] {
+				return "", nil, nil, fmt.Errorf("unknown field %q", field)
+			}
+			columns = append(columns, field)
+		}
+	}
+	var where []string
+	var args []any
+	filter := func(column string, value any) {
+		args = append(args, value)
+		where = append(where, column+" = $"+strconv.Itoa(len(args)))
+	}
+	if query.Practice != "" {
+		filter("practice", query.Practice)
+	}
+	if query.Lastname != "" {
+		filter("lastname", query.Lastname)
+	}
+	if query.EmpID != 0 {
+		filter("empid", query.EmpID)
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT " + strings.Join(columns, ", ") + " FROM employee")
+	if len(where) > 0 {
+		b.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	b.WriteString(" ORDER BY ")
+	if query.Sort != "" {
+		field, order := strings.TrimPrefix(query.Sort, "-"), "ASC"
+		if field != query.Sort {
+			order = "DESC"
+		}
+		if !employeeFields[field] {
+			return "", nil, nil, fmt.Errorf("unknown sort field %q", field)
+		}
+		b.WriteString(field + " " + order + ", ")
+	}
+	b.WriteString("id ASC")
+	if query.Limit > 0 {
+		b.WriteString(" LIMIT " + strconv.Itoa(query.Limit))
+	} else if query.Skip > 0 {
+		b.WriteString(" LIMIT " + s.dialect.noLimit)
+	}
+	if query.Skip > 0 {
+		b.WriteString(" OFFSET " + strconv.Itoa(query.Skip))
+	}
+	return s.dialect.rebind(b.String()), args, columns, nil
+}
+
+func (s *sqlStore) Find(ctx context.Context, query EmployeeQuery) ([]Employee, error) {
+	it, err := s.Iter(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var employees []Employee
+	var employee Employee
+	for it.Next(&employee) {
+		employees = append(employees, employee)
+	}
+	return employees, it.Close()
+}
+
+// Iter streams the results from the open rows.
+func (s *sqlStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	statement, args, columns, err := s.query(query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlIter{rows: rows, columns: columns}, nil
+}
+
+type sqlIter struct {
+	rows    *sql.Rows
+	columns []string
+	err     error
+}
+
+func (it *sqlIter) Next(employee *Employee) bool {
+	*employee = Employee{}
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	*employee, it.err = scanEmployee(it.rows, it.columns)
+	return it.err == nil
+}
+
+func (it *sqlIter) Close() error {
+	err := it.rows.Err()
+	if closeErr := it.rows.Close(); err == nil {
+		err = closeErr
+	}
+	if it.err != nil {
+		return it.err
+	}
+	return err
+}
+
+func (s *sqlStore) Update(ctx context.Context, id string, employee Employee) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	args := []any{oid.Hex()}
+	var set []string
+	column := func(name string, value any) {
+		args = append(args, value)
+		set = append(set, name+" = $"+strconv.Itoa(len(args)))
+	}
+	if employee.Firstname != "" {
+		column("firstname", employee.Firstname)
+	}
+	if employee.Lastname != "" {
+		column("lastname", employee.Lastname)
+	}
+	if employee.EmpID != 0 {
+		column("empid", employee.EmpID)
+	}
+	if employee.Salary != 0 {
+		column("salary", employee.Salary)
+	}
+	if employee.Practice != "" {
+		column("practice", employee.Practice)
+	}
+	if len(set) == 0 {
+		set = []string{"id = id"}
+	}
+	statement := s.dialect.rebind(`UPDATE employee SET ` + strings.Join(set, ", ") + ` WHERE id = $1 RETURNING ` + strings.Join(employeeSQLColumns, ", "))
+	return s.write(ctx, EventEmployeeUpdated, func(tx *sql.Tx) (Employee, error) {
+		return scanEmployee(tx.QueryRowContext(ctx, statement, args...), employeeSQLColumns)
+	})
+}
+
+func (s *sqlStore) Remove(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	statement := s.dialect.rebind(`DELETE FROM employee WHERE id = $1 RETURNING ` + strings.Join(employeeSQLColumns, ", "))
+	return s.write(ctx, EventEmployeeDeleted, func(tx *sql.Tx) (Employee, error) {
+		return scanEmployee(tx.QueryRowContext(ctx, statement, oid.Hex()), employeeSQLColumns)
+	})
+}
+
+func (s *sqlStore) CountByPractice(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT practice, COUNT(*) FROM employee GROUP BY practice`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var practice string
+		var count int
+		if err := rows.Scan(&practice, &count); err != nil {
+			return nil, err
+		}
+		counts[practice] = count
+	}
+	return counts, rows.Err()
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// sqlOutbox is the "outbox" table the sqlStore writes in its transactions.
+type sqlOutbox struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// find returns the entries selected by the clauses following FROM outbox.
+func (o sqlOutbox) find(ctx context.Context, clauses string, args ...any) ([]OutboxEntry, error) {
+	rows, err := o.db.QueryContext(ctx, o.dialect.rebind(`SELECT id, type, employee, time, relayed FROM outbox `+clauses), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []OutboxEntry
+	for rows.Next() {
+		var id, data string
+		var nanos int64
+		var entry OutboxEntry
+		if err := rows.Scan(&id, &entry.Type, &data, &nanos, &entry.Relayed); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &entry.Employee); err != nil {
+			return nil, err
+		}
+		entry.ID = bson.ObjectIdHex(id)
+		entry.Time = time.Unix(0, nanos)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (o sqlOutbox) Pending(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	return o.find(ctx, `WHERE NOT relayed ORDER BY id LIMIT $1`, limit)
+}
+
+func (o sqlOutbox) Since(ctx context.Context, t time.Time, id bson.ObjectId, limit int) ([]OutboxEntry, error) {
+	return o.find(ctx, `WHERE time > $1 OR (time = $1 AND id > $2) ORDER BY time, id LIMIT $3`, t.UnixNano(), id.Hex(), limit)
+}
+
+func (o sqlOutbox) Purge(ctx context.Context, t time.Time) error {
+	_, err := o.db.ExecContext(ctx, o.dialect.rebind(`DELETE FROM outbox WHERE relayed AND time < $1`), t.UnixNano())
+	return err
+}
+
+func (o sqlOutbox) Ack(ctx context.Context, ids []bson.ObjectId) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		args[i] = id.Hex()
+	}
+	_, err := o.db.ExecContext(ctx, o.dialect.rebind(`UPDATE outbox SET relayed = TRUE WHERE id IN (`+strings.Join(placeholders, ", ")+`)`), args...)
+	return err
+}
+
+// Lease keeps the lease in the one row of outbox_lease. The conditional
+// upsert changes no row while another owner holds it.
+func (o sqlOutbox) Lease(ctx context.Context, owner string, now time.Time) (bool, error) {
+	result, err := o.db.ExecContext(ctx, o.dialect.rebind(`INSERT INTO outbox_lease (id, owner, expires_at) VALUES (1, $1, $2)
+		ON CONFLICT (id) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE outbox_lease.owner = excluded.owner OR outbox_lease.expires_at < $3`),
+		owner, now.Add(outboxLeaseTTL).UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// sqlWebhookStore keeps subscriptions in "webhooks" and deliveries in
+// "webhook_deliveries" as JSON documents, with the columns deliveries are
+// looked up by alongside.
+type sqlWebhookStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// unixNanos is t as stored in the next_attempt column; the zero time is 0.
+func unixNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// sqlExpectRow reports ErrNotFound when a statement changed no row.
+func sqlExpectRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// findDocs decodes the doc column of every row into a new T.
+func findDocs[T any](ctx context.Context, db *sql.DB, query string, args ...any) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	docs := []T{}
+	for rows.Next() {
+		var data string
+		var doc T
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// findDoc decodes the doc column of the row with id.
+func findDoc[T any](ctx context.Context, db *sql.DB, query string, id string) (T, error) {
+	var doc T
+	oid, err := objectID(id)
+	if err != nil {
+		return doc, err
+	}
+	var data string
+	if err := db.QueryRowContext(ctx, query, oid.Hex()).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		return doc, err
+	}
+	return doc, json.Unmarshal([]byte(data), &doc)
+}
+
+func (s sqlWebhookStore) InsertSubscription(ctx context.Context, sub *Subscription) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO webhooks (id, doc) VALUES ($1, $2)`), sub.ID.Hex(), string(data))
+	return err
+}
+
+func (s sqlWebhookStore) FindSubscription(ctx context.Context, id string) (Subscription, error) {
+	return findDoc[Subscription](ctx, s.db, s.dialect.rebind(`SELECT doc FROM webhooks WHERE id = $1`), id)
+}
+
+func (s sqlWebhookStore) FindSubscriptions(ctx context.Context) ([]Subscription, error) {
+	return findDocs[Subscription](ctx, s.db, `SELECT doc FROM webhooks ORDER BY id`)
+}
+
+func (s sqlWebhookStore) UpdateSubscription(ctx context.Context, sub Subscription) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return sqlExpectRow(s.db.ExecContext(ctx, s.dialect.rebind(`UPDATE webhooks SET doc = $2 WHERE id = $1`), sub.ID.Hex(), string(data)))
+}
+
+func (s sqlWebhookStore) RemoveSubscription(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	return sqlExpectRow(s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM webhooks WHERE id = $1`), oid.Hex()))
+}
+
+func (s sqlWebhookStore) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO webhook_deliveries (id, subscription_id, status, next_attempt, doc)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO NOTHING`),
+		delivery.ID.Hex(), delivery.SubscriptionID.Hex(), delivery.Status, unixNanos(delivery.NextAttempt), string(data))
+	return err
+}
+
+func (s sqlWebhookStore) FindDelivery(ctx context.Context, id string) (Delivery, error) {
+	return findDoc[Delivery](ctx, s.db, s.dialect.rebind(`SELECT doc FROM webhook_deliveries WHERE id = $1`), id)
+}
+
+func (s sqlWebhookStore) FindDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	var where []string
+	var args []any
+	if query.SubscriptionID != "" {
+		oid, err := objectID(query.SubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, oid.Hex())
+		where = append(where, "subscription_id = $"+strconv.Itoa(len(args)))
+	}
+	if query.Status != "" {
+		args = append(args, query.Status)
+		where = append(where, "status = $"+strconv.Itoa(len(args)))
+	}
+	statement := `SELECT doc FROM webhook_deliveries`
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += " ORDER BY id DESC"
+	if query.Limit > 0 {
+		statement += " LIMIT " + strconv.Itoa(query.Limit)
+	}
+	return findDocs[Delivery](ctx, s.db, s.dialect.rebind(statement), args...)
+}
+
+func (s sqlWebhookStore) DueDeliveries(ctx context.Context, now time.Time, exclude []bson.ObjectId, limit int) ([]Delivery, error) {
+	statement := `SELECT doc FROM webhook_deliveries WHERE status = $1 AND next_attempt <= $2`
+	args := []any{deliveryPending, now.UnixNano()}
+	if len(exclude) > 0 {
+		params := make([]string, len(exclude))
+		for i, id := range exclude {
+			args = append(args, id.Hex())
+			params[i] = "$" + strconv.Itoa(len(args))
+		}
+		statement += ` AND subscription_id NOT IN (` + strings.Join(params, ", ") + `)`
+	}
+	args = append(args, limit)
+	statement += ` ORDER BY next_attempt, id LIMIT $` + strconv.Itoa(len(args))
+	return findDocs[Delivery](ctx, s.db, s.dialect.rebind(statement), args...)
+}
+
+// ClaimDelivery only moves the next_attempt column; the document keeps the
+// attempt that was due until the claimant saves its outcome.
+func (s sqlWebhookStore) ClaimDelivery(ctx context.Context, id bson.ObjectId, now, until time.Time) (Delivery, error) {
+	statement := `UPDATE webhook_deliveries SET next_attempt = $4 WHERE id = $1 AND status = $2 AND next_attempt <= $3`
+	err := sqlExpectRow(s.db.ExecContext(ctx, s.dialect.rebind(statement), id.Hex(), deliveryPending, now.UnixNano(), unixNanos(until)))
+	if err != nil {
+		return Delivery{}, err
+	}
+	delivery, err := s.FindDelivery(ctx, id.Hex())
+	delivery.NextAttempt = until
+	return delivery, err
+}
+
+func (s sqlWebhookStore) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	statement := `UPDATE webhook_deliveries SET subscription_id = $2, status = $3, next_attempt = $4, doc = $5 WHERE id = $1`
+	return sqlExpectRow(s.db.ExecContext(ctx, s.dialect.rebind(statement),
+		delivery.ID.Hex(), delivery.SubscriptionID.Hex(), delivery.Status, unixNanos(delivery.NextAttempt), string(data)))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// openSQLTest returns an empty, migrated database of backend. PostgreSQL
+// tests run against MUXCRUD_TEST_POSTGRES_DSN and are skipped without it.
+func openSQLTest(t *testing.T, backend string) (*sql.DB, sqlDialect) {
+	dsn := filepath.Join(t.TempDir(), "employees.db")
+	if backend == "postgres" {
+		dsn = os.Getenv("MUXCRUD_TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("MUXCRUD_TEST_POSTGRES_DSN not set")
+		}
+	}
+	dialect := sqlDialects[backend]
+	database, err := sql.Open(dialect.driver, dialect.dsn(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	ctx := context.Background()
+	for _, table := range []string{"schema_migrations", "schema_migrations_lock", "employee", "outbox", "webhooks", "webhook_deliveries", "outbox_lease"} {
+		if _, err := database.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sqlMigrator(database, dialect).migrate(ctx, sqlMigrations[len(sqlMigrations)-1].version); err != nil {
+		t.Fatal(err)
+	}
+	return database, dialect
+}
+
+func TestSQLiteStore(t *testing.T) {
+	database, dialect := openSQLTest(t, "sqlite")
+	testEmployeeStore(t, &sqlStore{db: database, dialect: dialect})
+}
+
+func TestPostgresStore(t *testing.T) {
+	database, dialect := openSQLTest(t, "postgres")
+	testEmployeeStore(t, &sqlStore{db: database, dialect: dialect})
+}
+
+func TestSQLMigrations(t *testing.T) {
+	database, dialect := openSQLTest(t, "sqlite")
+	m := sqlMigrator(database, dialect)
+	ctx := context.Background()
+
+	assert.NoError(t, m.migrate(ctx, m.latest()), "migrating again")
+	assert.NoError(t, m.migrate(ctx, 1))
+	statuses, err := m.status(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []migrationStatus{{1, "create employee", true}, {2, "create outbox", false}, {3, "create webhooks", false}, {4, "keep relayed outbox entries", false}, {5, "create outbox lease", false}}, statuses)
+	_, err = database.ExecContext(ctx, "SELECT 1 FROM webhooks")
+	assert.Error(t, err, "down dropped the table")
+
+	assert.NoError(t, m.migrate(ctx, m.latest()))
+	_, err = database.ExecContext(ctx, "SELECT 1 FROM webhooks")
+	assert.NoError(t, err)
+}
+
+func TestSQLDataMigration(t *testing.T) {
+	for _, backend := range []string{"sqlite", "postgres"} {
+		t.Run(backend, func(t *testing.T) {
+			database, dialect := openSQLTest(t, backend)
+			s := &sqlStore{db: database, dialect: dialect}
+			ctx := context.Background()
+			ravi := Employee{Firstname: "ravi", Salary: 100}
+			assert.NoError(t, s.Insert(ctx, &ravi))
+
+			m := migrations[sqlTx]{log: sqlMigrationLog{db: database, dialect: dialect}, steps: append(sqlMigrations[:len(sqlMigrations):len(sqlMigrations)],
+				migration[sqlTx]{100, "double salaries", sqlExec(`UPDATE employee SET salary = salary * 2`), sqlExec(`UPDATE employee SET salary = salary / 2`)},
+			)}
+			assert.NoError(t, m.migrate(ctx, 100))
+			got, _ := s.FindByID(ctx, ravi.ID.Hex())
+			assert.Equal(t, 200.0, got.Salary)
+
+			assert.NoError(t, m.migrate(ctx, 3))
+			got, _ = s.FindByID(ctx, ravi.ID.Hex())
+			assert.Equal(t, 100.0, got.Salary, "down reverts the backfill")
+		})
+	}
+}
+
+func TestSQLOutbox(t *testing.T) {
+	database, dialect := openSQLTest(t, "sqlite")
+	s := &sqlStore{db: database, dialect: dialect}
+	o := sqlOutbox{db: database, dialect: dialect}
+	ctx := context.Background()
+
+	employee := Employee{Firstname: "ravi", Salary: 30}
+	assert.NoError(t, s.Insert(ctx, &employee))
+	assert.NoError(t, s.Update(ctx, employee.ID.Hex(), Employee{Salary: 35}))
+	assert.ErrorIs(t, s.Remove(ctx, bson.NewObjectId().Hex()), ErrNotFound)
+
+	entries, err := o.Pending(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2, "failed writes record nothing") {
+		assert.Equal(t, EventEmployeeCreated, entries[0].Type)
+		assert.Equal(t, employee, entries[0].Employee)
+		assert.Equal(t, EventEmployeeUpdated, entries[1].Type)
+		assert.Equal(t, 35.0, entries[1].Employee.Salary)
+		assert.WithinDuration(t, time.Now(), entries[1].Time, time.Minute)
+	}
+
+	assert.NoError(t, o.Ack(ctx, []bson.ObjectId{entries[0].ID}))
+	entries, err = o.Pending(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	entries, err = o.Since(ctx, time.Now().Add(-time.Minute), "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2, "relayed entries stay for the other instances") {
+		assert.True(t, entries[0].Relayed)
+		assert.False(t, entries[1].Relayed)
+		after, err := o.Since(ctx, entries[0].Time, entries[0].ID, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, entries[1:], after)
+	}
+	assert.NoError(t, o.Purge(ctx, time.Now().Add(time.Second)))
+	entries, err = o.Since(ctx, time.Unix(0, 0), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "only relayed entries are purged")
+
+	now := time.Now()
+	held, err := o.Lease(ctx, "a", now)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	held, _ = o.Lease(ctx, "b", now)
+	assert.False(t, held, "the lease is taken")
+	held, _ = o.Lease(ctx, "a", now.Add(time.Second))
+	assert.True(t, held, "the holder renews")
+	held, _ = o.Lease(ctx, "b", now.Add(outboxLeaseTTL+2*time.Second))
+	assert.True(t, held, "a lapsed lease is taken over")
+}
+
+func TestSQLWebhookStore(t *testing.T) {
+	database, dialect := openSQLTest(t, "sqlite")
+	s := sqlWebhookStore{db: database, dialect: dialect}
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	sub := Subscription{ID: bson.NewObjectId(), URL: "https://hooks.example.com", Secret: "s3cret", Active: true, CreatedAt: now}
+	assert.NoError(t, s.InsertSubscription(ctx, &sub))
+	sub.Active = false
+	assert.NoError(t, s.UpdateSubscription(ctx, sub))
+	got, err := s.FindSubscription(ctx, sub.ID.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, sub, got)
+
+	due := Delivery{ID: bson.NewObjectId(), SubscriptionID: sub.ID, Event: EventEmployeeCreated, Payload: []byte(`{}`),
+		Status: deliveryPending, NextAttempt: now.Add(-time.Second), CreatedAt: now}
+	later := due
+	later.ID, later.NextAttempt = bson.NewObjectId(), now.Add(time.Hour)
+	assert.NoError(t, s.InsertDelivery(ctx, &due))
+	assert.NoError(t, s.InsertDelivery(ctx, &later))
+	again := due
+	again.Status = deliveryDead
+	assert.NoError(t, s.InsertDelivery(ctx, &again), "inserting again is not an error")
+
+	deliveries, err := s.DueDeliveries(ctx, now, nil, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Delivery{due}, deliveries, "and changes nothing")
+	deliveries, err = s.DueDeliveries(ctx, now, []bson.ObjectId{bson.NewObjectId(), sub.ID}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries, "excluded subscriptions are left out")
+
+	deliveries, err = s.FindDeliveries(ctx, DeliveryQuery{SubscriptionID: sub.ID.Hex()})
+	assert.NoError(t, err)
+	assert.Equal(t, []Delivery{later, due}, deliveries, "newest first")
+
+	claimed, err := s.ClaimDelivery(ctx, due.ID, now, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute), claimed.NextAttempt)
+	_, err = s.ClaimDelivery(ctx, due.ID, now, now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrNotFound, "claimed deliveries are not due")
+	deliveries, err = s.DueDeliveries(ctx, now.Add(time.Minute), nil, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Delivery{due}, deliveries, "until the claim lapses")
+
+	assert.NoError(t, s.RemoveSubscription(ctx, sub.ID.Hex()))
+	_, err = s.FindSubscription(ctx, sub.ID.Hex())
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, s.UpdateDelivery(ctx, Delivery{ID: bson.NewObjectId()}), ErrNotFound)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseHeartbeat is how often an idle event stream gets a comment, keeping
+// proxies from timing it out.
+var sseHeartbeat = 15 * time.Second
+
+// streamsDone is closed when shutdown starts, ending event streams that
+// would otherwise hold the server open until the shutdown timeout. Clients
+// reconnect elsewhere.
+var (
+	streamsDone  = make(chan struct{})
+	closeStreams = sync.OnceFunc(func() { close(streamsDone) })
+)
+
+// writeSSE writes event as a server-sent event named after its type, with
+// the employee as JSON data.
+func writeSSE(w io.Writer, event EmployeeEvent) error {
+	data, err := json.Marshal(event.Employee)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// EmployeeEventsEndpoint streams employee changes as server-sent events.
+func EmployeeEventsEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /employees/events EmployeeEventsEndpoint
+	//
+	// Streams employee changes as server-sent events.
+	// Events are named employee.created, employee.updated and
+	// employee.deleted, carry the employee as data and have ids to resume
+	// from with Last-Event-ID. A reset event means changes may have been
+	// missed, so clients should reload what they show.
+	// ---
+	// produces:
+	// - text/event-stream
+	// parameters:
+	// - name: practice
+	//   in: query
+	//   description: only send changes to employees of this practice
+	//   type: string
+	// - name: Last-Event-ID
+	//   in: header
+	//   description: id of the last event received, to replay the events since
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: event stream
+	//   default:
+	//     description: unexpected error
+
+	practice := request.FormValue("practice")
+	replayed, complete, ch, unsubscribe := events.SubscribeSince(request.Header.Get("Last-Event-ID"), watchBuffer)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(response)
+	rc.SetWriteDeadline(time.Time{})
+	response.Header().Set("content-type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the stream.
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+
+	send := func(event EmployeeEvent) error {
+		if practice != "" && event.Employee.Practice != practice {
+			return nil
+		}
+		return writeSSE(response, event)
+	}
+	if !complete {
+		fmt.Fprint(response, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range replayed {
+		if send(event) != nil {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-request.Context().Done():
+			return
+		case <-streamsDone:
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(response, ": heartbeat\n\n")
+		case event, ok := <-ch:
+			if !ok {
+				// Dropped for falling behind; the client resumes from
+				// the last id it got.
+				return
+			}
+			err = send(event)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
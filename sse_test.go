@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sseStream reads the server-sent events of a response as raw blocks.
+type sseStream struct {
+	lines *bufio.Scanner
+}
+
+// next returns the next event or comment block, without its blank line.
+func (s sseStream) next(t *testing.T) string {
+	var block []string
+	for s.lines.Scan() {
+		if s.lines.Text() == "" {
+			return strings.Join(block, "\n")
+		}
+		block = append(block, s.lines.Text())
+	}
+	t.Fatal("stream ended", s.lines.Err())
+	return ""
+}
+
+func openEvents(t *testing.T, server *httptest.Server, query, lastID string) sseStream {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/employees/events"+query, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if status := resp.StatusCode; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return sseStream{lines: bufio.NewScanner(resp.Body)}
+}
+
+func TestEmployeeEvents(t *testing.T) {
+	useMemoryStore(t)
+	saved := sseHeartbeat
+	sseHeartbeat = 50 * time.Millisecond
+	t.Cleanup(func() { sseHeartbeat = saved })
+	server := httptest.NewServer(http.HandlerFunc(EmployeeEventsEndpoint))
+	// Cleanups run last first, so the server closes after the streams.
+	t.Cleanup(server.Close)
+	ctx := context.Background()
+
+	stream := openEvents(t, server, "?practice=IBM", "")
+	ravi := Employee{Firstname: "ravi", Practice: "SAP"}
+	aditi := Employee{Firstname: "aditi", Practice: "IBM"}
+	store.Insert(ctx, &ravi)
+	store.Insert(ctx, &aditi)
+
+	var created string
+	t.Run("it streams changes of the practice", func(t *testing.T) {
+		block := stream.next(t)
+		for block == ": heartbeat" {
+			block = stream.next(t)
+		}
+		assert.Regexp(t, `^id: \w+-\d+\nevent: employee.created\ndata: \{"_id":"`+aditi.ID.Hex()+`","firstname":"aditi","practice":"IBM"\}$`, block)
+		created = strings.TrimPrefix(strings.SplitN(block, "\n", 2)[0], "id: ")
+	})
+
+	t.Run("it sends heartbeats", func(t *testing.T) {
+		assert.Equal(t, ": heartbeat", stream.next(t))
+	})
+
+	t.Run("it resumes from Last-Event-ID", func(t *testing.T) {
+		store.Update(ctx, aditi.ID.Hex(), Employee{Salary: 20})
+		store.Remove(ctx, ravi.ID.Hex())
+		resumed := openEvents(t, server, "", created)
+		assert.Contains(t, resumed.next(t), "event: employee.updated\n")
+		assert.Contains(t, resumed.next(t), "event: employee.deleted\n")
+	})
+
+	t.Run("it asks clients to reset after a gap", func(t *testing.T) {
+		resumed := openEvents(t, server, "", "0-1")
+		assert.Equal(t, "event: reset\ndata: {}", resumed.next(t))
+	})
+}
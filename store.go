@@ -0,0 +1,398 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrNotFound is returned by an EmployeeStore when no employee matches.
+var ErrNotFound = errors.New("not found")
+
+// errEmployeeExists is returned when inserting an employee whose id is taken.
+var errEmployeeExists = errors.New("employee already exists")
+
+// errStoreUnavailable is reported while the store is not connected yet.
+var errStoreUnavailable = errors.New("store unavailable")
+
+// EmployeeQuery selects a page of employees. A zero Limit means no limit and
+// empty filters match every employee. Sort names a field, prefixed with "-"
+// for descending order; ties are broken by id. Fields, when set, restricts
+// the returned employees to these fields and the id.
+type EmployeeQuery struct {
+	Limit    int
+	Skip     int
+	Practice string
+	Lastname string
+	EmpID    int
+	Sort     string
+	Fields   []string
+}
+
+// matches reports whether employee passes the query's filters.
+func (q EmployeeQuery) matches(employee Employee) bool {
+	return (q.Practice == "" || employee.Practice == q.Practice) &&
+		(q.Lastname == "" || employee.Lastname == q.Lastname) &&
+		(q.EmpID == 0 || employee.EmpID == q.EmpID)
+}
+
+// EmployeeIterator walks the results of a query one employee at a time.
+// Next reports false at the end or on error; Close returns the error.
+type EmployeeIterator interface {
+	Next(employee *Employee) bool
+	Close() error
+}
+
+// sliceIter iterates over employees already in memory.
+type sliceIter struct {
+	employees []Employee
+}
+
+func (it *sliceIter) Next(employee *Employee) bool {
+	if len(it.employees) == 0 {
+		return false
+	}
+	*employee, it.employees = it.employees[0], it.employees[1:]
+	return true
+}
+
+func (it *sliceIter) Close() error { return nil }
+
+// employeeFields are the fields EmployeeQuery.Sort and Fields accept.
+var employeeFields = map[string]bool{
+	"firstname": true,
+	"lastname":  true,
+	"empid":     true,
+	"salary":    true,
+	"practice":  true,
+}
+
+// mergeEmployee sets the non-zero fields of update on employee, as an
+// update does.
+func mergeEmployee(employee *Employee, update Employee) {
+	if update.Firstname != "" {
+		employee.Firstname = update.Firstname
+	}
+	if update.Lastname != "" {
+		employee.Lastname = update.Lastname
+	}
+	if update.EmpID != 0 {
+		employee.EmpID = update.EmpID
+	}
+	if update.Salary != 0 {
+		employee.Salary = update.Salary
+	}
+	if update.Practice != "" {
+		employee.Practice = update.Practice
+	}
+}
+
+// pageEmployees applies the sort, skip, limit and fields of query to the
+// employees matching it, for stores that cannot do so themselves. Without a
+// sort, matched keeps its order.
+func pageEmployees(matched []Employee, query EmployeeQuery) []Employee {
+	if query.Sort != "" {
+		field, desc := strings.TrimPrefix(query.Sort, "-"), strings.HasPrefix(query.Sort, "-")
+		sort.SliceStable(matched, func(i, j int) bool {
+			c := compareField(matched[i], matched[j], field)
+			if c == 0 {
+				return matched[i].ID < matched[j].ID
+			}
+			return (c < 0) != desc
+		})
+	}
+	matched = matched[min(query.Skip, len(matched)):]
+	if query.Limit > 0 && query.Limit < len(matched) {
+		matched = matched[:query.Limit]
+	}
+	if len(query.Fields) > 0 {
+		for i, e := range matched {
+			matched[i] = Employee{ID: e.ID}
+			for _, field := range query.Fields {
+				copyField(&matched[i], e, field)
+			}
+		}
+	}
+	return matched
+}
+
+func compareField(a, b Employee, field string) int {
+	switch field {
+	case "firstname":
+		return cmp.Compare(a.Firstname, b.Firstname)
+	case "lastname":
+		return cmp.Compare(a.Lastname, b.Lastname)
+	case "empid":
+		return cmp.Compare(a.EmpID, b.EmpID)
+	case "salary":
+		return cmp.Compare(a.Salary, b.Salary)
+	case "practice":
+		return cmp.Compare(a.Practice, b.Practice)
+	}
+	return 0
+}
+
+func copyField(dst *Employee, src Employee, field string) {
+	switch field {
+	case "firstname":
+		dst.Firstname = src.Firstname
+	case "lastname":
+		dst.Lastname = src.Lastname
+	case "empid":
+		dst.EmpID = src.EmpID
+	case "salary":
+		dst.Salary = src.Salary
+	case "practice":
+		dst.Practice = src.Practice
+	}
+}
+
+// pageQuery returns the query for a 1-based page of limit employees.
+func pageQuery(limit, page int) EmployeeQuery {
+	skip := limit * (page - 1)
+	if skip < 0 {
+		skip = 0
+	}
+	return EmployeeQuery{Limit: limit, Skip: skip}
+}
+
+// EmployeeStore persists employee records. Handlers only talk to the store
+// through this interface so that it can be decorated (metrics, tracing) or
+// swapped for another backend.
+type EmployeeStore interface {
+	Insert(ctx context.Context, employee *Employee) error
+	FindByID(ctx context.Context, id string) (Employee, error)
+	Find(ctx context.Context, query EmployeeQuery) ([]Employee, error)
+	Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error)
+	Update(ctx context.Context, id string, employee Employee) error
+	Remove(ctx context.Context, id string) error
+	CountByPractice(ctx context.Context) (map[string]int, error)
+	Ping(ctx context.Context) error
+}
+
+const (
+	connectBackoffMin = 500 * time.Millisecond
+	connectBackoffMax = 30 * time.Second
+)
+
+// connectStore connects to the configured backend until it succeeds or ctx
+// is done, backing off exponentially with jitter between attempts, then
+// installs the store behind the cache.
+func connectStore(ctx context.Context, cfg Config) error {
+	connect := func(ctx context.Context) error { return connectMgo(ctx, cfg.Mongo, cfg.AutoMigrate) }
+	switch {
+	case cfg.Backend == "bolt":
+		connect = func(ctx context.Context) error { return connectBolt(ctx, cfg.Bolt, cfg.AutoMigrate) }
+	case cfg.Backend == "sqlite" || cfg.Backend == "postgres":
+		connect = func(ctx context.Context) error { return connectSQL(ctx, cfg.Backend, cfg.SQL, cfg.AutoMigrate) }
+	case cfg.Mongo.Driver == "mongo":
+		connect = func(ctx context.Context) error { return connectMongo(ctx, cfg.Mongo, cfg.AutoMigrate) }
+	}
+	delay := connectBackoffMin
+	for attempt := 1; ; attempt++ {
+		err := connect(ctx)
+		schemaPending.Store(errors.Is(err, errMigrationsPending))
+		if err == nil {
+			cacheStore(ctx, cfg.Cache, events)
+			storeReady.Store(true)
+			logger.Info("connected to the store", "backend", cfg.Backend, "attempt", attempt)
+			return nil
+		}
+		wait := delay + rand.N(delay/2)
+		logger.Warn("connecting to the store", "backend", cfg.Backend, "attempt", attempt, "retry_in", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, connectBackoffMax)
+	}
+}
+
+// connectMgo prepares the schema and installs the stores on an mgo session.
+// Of the pool and timeout settings, mgo only takes the connect timeout.
+func connectMgo(ctx context.Context, cfg MongoConfig, autoMigrate bool) error {
+	client, err := prepareMongoSchema(ctx, cfg, autoMigrate)
+	if err != nil {
+		return err
+	}
+	session, err := mgo.DialWithTimeout(cfg.URL, cfg.ConnectTimeout)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return err
+	}
+	registerMgoStats()
+	writes, err := newMongoStore(ctx, client.Database(cfg.Database))
+	if err != nil {
+		session.Close()
+		client.Disconnect(context.Background())
+		return err
+	}
+	schemaClient = client
+	schema = mongoMigrator(client.Database(cfg.Database))
+	db = session.DB(cfg.Database)
+	store = newTracedStore(instrumentedStore{next: &mgoStore{db: db, writes: writes}}, semconv.DBSystemNameMongoDB, "employee")
+	outbox = mongoOutbox{db: client.Database(cfg.Database)}
+	webhookStore = mgoWebhookStore{db: db}
+	return nil
+}
+
+// closeStore marks the store unavailable and closes the connection.
+func closeStore() {
+	if !storeReady.Swap(false) {
+		return
+	}
+	switch {
+	case boltDB != nil:
+		boltDB.Close()
+	case sqlDB != nil:
+		sqlDB.Close()
+	case mongoClient != nil:
+		mongoClient.Disconnect(context.Background())
+	default:
+		db.Session.Close()
+		schemaClient.Disconnect(context.Background())
+	}
+}
+
+// mgoStore is the EmployeeStore backed by the "employee" collection. It
+// reads through mgo and writes through the official driver's mongoStore,
+// which records each write's event in the "outbox" collection in a Mongo
+// transaction and leaves the documents as any other client writes them.
+type mgoStore struct {
+	db     *mgo.Database
+	writes *mongoStore
+}
+
+func (s *mgoStore) collection() *mgo.Collection {
+	return s.db.C("employee")
+}
+
+// objectID converts a hex id, reporting ErrNotFound for ids that cannot
+// exist instead of letting bson.ObjectIdHex panic.
+func objectID(id string) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(id) {
+		return "", ErrNotFound
+	}
+	return bson.ObjectIdHex(id), nil
+}
+
+func mgoError(err error) error {
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *mgoStore) Insert(ctx context.Context, employee *Employee) error {
+	return s.writes.Insert(ctx, employee)
+}
+
+func (s *mgoStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	var employee Employee
+	oid, err := objectID(id)
+	if err != nil {
+		return employee, err
+	}
+	err = s.collection().FindId(oid).One(&employee)
+	return employee, mgoError(err)
+}
+
+func (s *mgoStore) query(query EmployeeQuery) *mgo.Query {
+	filter := bson.M{}
+	if query.Practice != "" {
+		filter["practice"] = query.Practice
+	}
+	if query.Lastname != "" {
+		filter["lastname"] = query.Lastname
+	}
+	if query.EmpID != 0 {
+		filter["empid"] = query.EmpID
+	}
+	q := s.collection().Find(filter)
+	if query.Sort != "" {
+		q = q.Sort(query.Sort, "_id")
+	}
+	if len(query.Fields) > 0 {
+		selector := bson.M{}
+		for _, field := range query.Fields {
+			selector[field] = 1
+		}
+		q = q.Select(selector)
+	}
+	return q.Limit(query.Limit).Skip(query.Skip)
+}
+
+func (s *mgoStore) Find(ctx context.Context, query EmployeeQuery) ([]Employee, error) {
+	var employees []Employee
+	err := s.query(query).All(&employees)
+	return employees, err
+}
+
+// Iter streams the results in batches from a cursor.
+func (s *mgoStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	return mgoIter{s.query(query).Iter()}, nil
+}
+
+type mgoIter struct {
+	iter *mgo.Iter
+}
+
+func (it mgoIter) Next(employee *Employee) bool {
+	*employee = Employee{}
+	return it.iter.Next(employee)
+}
+
+func (it mgoIter) Close() error {
+	return it.iter.Close()
+}
+
+func (s *mgoStore) Update(ctx context.Context, id string, employee Employee) error {
+	return s.writes.Update(ctx, id, employee)
+}
+
+func (s *mgoStore) Remove(ctx context.Context, id string) error {
+	return s.writes.Remove(ctx, id)
+}
+
+// Ping checks the session. mgo does not reconnect a session whose socket
+// died, so a failed ping refreshes it for the next call.
+func (s *mgoStore) Ping(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.db.Session.Ping()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			s.db.Session.Refresh()
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *mgoStore) CountByPractice(ctx context.Context) (map[string]int, error) {
+	var groups []struct {
+		Practice string `bson:"_id"`
+		Count    int    `bson:"count"`
+	}
+	pipeline := []bson.M{{"$group": bson.M{"_id": "$practice", "count": bson.M{"$sum": 1}}}}
+	if err := s.collection().Pipe(pipeline).All(&groups); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(groups))
+	for _, g := range groups {
+		counts[g.Practice] = g.Count
+	}
+	return counts, nil
+}
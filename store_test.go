@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memoryStore is an in-memory EmployeeStore for tests that need working
+// reads and writes without Mongo. It keeps insertion order.
+type memoryStore struct {
+	mu        sync.Mutex
+	employees []Employee
+}
+
+func (s *memoryStore) index(id string) int {
+	for i, e := range s.employees {
+		if e.ID.Hex() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *memoryStore) Insert(ctx context.Context, employee *Employee) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if employee.ID == "" {
+		employee.ID = bson.NewObjectId()
+	}
+	if s.index(employee.ID.Hex()) >= 0 {
+		return errEmployeeExists
+	}
+	s.employees = append(s.employees, *employee)
+	return nil
+}
+
+func (s *memoryStore) FindByID(ctx context.Context, id string) (Employee, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(id)
+	if i < 0 {
+		return Employee{}, ErrNotFound
+	}
+	return s.employees[i], nil
+}
+
+func (s *memoryStore) Find(ctx context.Context, query EmployeeQuery) ([]Employee, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matched []Employee
+	for _, e := range s.employees {
+		if query.matches(e) {
+			matched = append(matched, e)
+		}
+	}
+	return pageEmployees(matched, query), nil
+}
+
+func (s *memoryStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	employees, err := s.Find(ctx, query)
+	return &sliceIter{employees: employees}, err
+}
+
+// Update overwrites the non-zero fields, like the $set of mgoStore.
+func (s *memoryStore) Update(ctx context.Context, id string, employee Employee) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	mergeEmployee(&s.employees[i], employee)
+	return nil
+}
+
+func (s *memoryStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.employees = append(s.employees[:i], s.employees[i+1:]...)
+	return nil
+}
+
+func (s *memoryStore) CountByPractice(ctx context.Context) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, e := range s.employees {
+		counts[e.Practice]++
+	}
+	return counts, nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error { return nil }
+
+// useMemoryStore installs an empty memoryStore, publishing to events, for
+// the duration of the test.
+func useMemoryStore(t *testing.T) *memoryStore {
+	saved, ready := store, storeReady.Load()
+	mem := &memoryStore{}
+	store = notifyingStore{next: mem, publisher: employeePublisher{bus: events}}
+	storeReady.Store(true)
+	t.Cleanup(func() {
+		store = saved
+		storeReady.Store(ready)
+	})
+	return mem
+}
+
+// testEmployeeStore checks the behaviour every EmployeeStore shares, starting
+// from an empty store.
+func testEmployeeStore(t *testing.T, s EmployeeStore) {
+	ctx := context.Background()
+	employees := []Employee{
+		{Firstname: "ravi", Lastname: "kumar", EmpID: 3, Salary: 30, Practice: "SAP"},
+		{Firstname: "aditi", Lastname: "rao", EmpID: 1, Salary: 20, Practice: "IBM"},
+		{Firstname: "sam", Lastname: "kumar", EmpID: 2, Salary: 20, Practice: "SAP"},
+	}
+	for i := range employees {
+		if err := s.Insert(ctx, &employees[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ravi, aditi, sam := employees[0], employees[1], employees[2]
+
+	t.Run("Insert assigns ids and rejects taken ones", func(t *testing.T) {
+		assert.True(t, ravi.ID.Valid())
+		taken := Employee{ID: ravi.ID, Firstname: "copy"}
+		assert.ErrorIs(t, s.Insert(ctx, &taken), errEmployeeExists)
+	})
+
+	t.Run("FindByID", func(t *testing.T) {
+		got, err := s.FindByID(ctx, ravi.ID.Hex())
+		assert.NoError(t, err)
+		assert.Equal(t, ravi, got)
+		for _, id := range []string{bson.NewObjectId().Hex(), "nope"} {
+			_, err := s.FindByID(ctx, id)
+			assert.ErrorIs(t, err, ErrNotFound, id)
+		}
+	})
+
+	t.Run("Find filters", func(t *testing.T) {
+		tests := []struct {
+			query EmployeeQuery
+			want  []Employee
+		}{
+			{EmployeeQuery{}, employees},
+			{EmployeeQuery{Practice: "SAP"}, []Employee{ravi, sam}},
+			{EmployeeQuery{Lastname: "kumar", Practice: "SAP"}, []Employee{ravi, sam}},
+			{EmployeeQuery{EmpID: 1}, []Employee{aditi}},
+			{EmployeeQuery{Practice: "none"}, nil},
+		}
+		for _, tt := range tests {
+			got, err := s.Find(ctx, tt.query)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, got, "%+v", tt.query)
+		}
+	})
+
+	t.Run("Find sorts with ties broken by id", func(t *testing.T) {
+		got, err := s.Find(ctx, EmployeeQuery{Sort: "salary"})
+		assert.NoError(t, err)
+		assert.Equal(t, []Employee{aditi, sam, ravi}, got)
+		got, err = s.Find(ctx, EmployeeQuery{Sort: "-salary"})
+		assert.NoError(t, err)
+		assert.Equal(t, []Employee{ravi, aditi, sam}, got)
+	})
+
+	t.Run("Find pages and selects fields", func(t *testing.T) {
+		got, err := s.Find(ctx, EmployeeQuery{Sort: "empid", Skip: 1, Limit: 1})
+		assert.NoError(t, err)
+		assert.Equal(t, []Employee{sam}, got)
+		got, err = s.Find(ctx, EmployeeQuery{Sort: "empid", Fields: []string{"firstname"}, Limit: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, []Employee{{ID: aditi.ID, Firstname: "aditi"}, {ID: sam.ID, Firstname: "sam"}}, got)
+	})
+
+	t.Run("Iter", func(t *testing.T) {
+		it, err := s.Iter(ctx, EmployeeQuery{Sort: "empid"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []Employee
+		var employee Employee
+		for it.Next(&employee) {
+			got = append(got, employee)
+		}
+		assert.NoError(t, it.Close())
+		assert.Equal(t, []Employee{aditi, sam, ravi}, got)
+	})
+
+	t.Run("CountByPractice", func(t *testing.T) {
+		counts, err := s.CountByPractice(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"SAP": 2, "IBM": 1}, counts)
+	})
+
+	t.Run("Update sets the non-zero fields", func(t *testing.T) {
+		assert.NoError(t, s.Update(ctx, sam.ID.Hex(), Employee{Salary: 25}))
+		got, err := s.FindByID(ctx, sam.ID.Hex())
+		assert.NoError(t, err)
+		sam.Salary = 25
+		assert.Equal(t, sam, got)
+		assert.ErrorIs(t, s.Update(ctx, bson.NewObjectId().Hex(), Employee{Salary: 1}), ErrNotFound)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		assert.NoError(t, s.Remove(ctx, aditi.ID.Hex()))
+		_, err := s.FindByID(ctx, aditi.ID.Hex())
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, s.Remove(ctx, aditi.ID.Hex()), ErrNotFound)
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		assert.NoError(t, s.Ping(ctx))
+	})
+}
+
+// storeTestDatabase is the scratch database the Mongo store tests use.
+const storeTestDatabase = "muxgocrud_storetest"
+
+func TestMemoryStore(t *testing.T) {
+	testEmployeeStore(t, &memoryStore{})
+}
+
+func TestMgoStore(t *testing.T) {
+	session, err := mgo.DialWithTimeout(defaultConfig().Mongo.URL, time.Second)
+	if err != nil {
+		t.Skip("mongo unavailable:", err)
+	}
+	t.Cleanup(session.Close)
+	writes, err := newMongoStore(context.Background(), openMongoTest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &mgoStore{db: session.DB(storeTestDatabase), writes: writes}
+	testEmployeeStore(t, s)
+
+	t.Run("it leaves documents as other clients write them", func(t *testing.T) {
+		employee := Employee{Firstname: "ravi"}
+		assert.NoError(t, s.Insert(context.Background(), &employee))
+		assert.NoError(t, s.Update(context.Background(), employee.ID.Hex(), Employee{Salary: 10}))
+		var doc bson.M
+		assert.NoError(t, s.collection().FindId(employee.ID).One(&doc))
+		assert.Equal(t, bson.M{"_id": employee.ID, "firstname": "ravi", "salary": 10.0}, doc)
+	})
+}
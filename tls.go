@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig enables HTTPS and, with a client CA, mutual TLS.
+type TLSConfig struct {
+	CertFile       string        `yaml:"cert_file" toml:"cert_file"`
+	KeyFile        string        `yaml:"key_file" toml:"key_file"`
+	ClientCAFile   string        `yaml:"client_ca_file" toml:"client_ca_file"`
+	ClientAuth     string        `yaml:"client_auth" toml:"client_auth"`
+	RedirectAddr   string        `yaml:"redirect_addr" toml:"redirect_addr"`
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != ""
+}
+
+// clientAuthTypes maps the client_auth setting to the tls package.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+func (c TLSConfig) validate() error {
+	var errs []error
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	if _, ok := clientAuthTypes[c.ClientAuth]; !ok {
+		errs = append(errs, fmt.Errorf("tls.client_auth: unknown mode %q", c.ClientAuth))
+	}
+	if c.ClientAuth != "none" && c.ClientCAFile == "" {
+		errs = append(errs, errors.New("tls.client_ca_file: required to verify client certificates"))
+	}
+	if !c.enabled() && (c.ClientCAFile != "" || c.RedirectAddr != "") {
+		errs = append(errs, errors.New("tls: client_ca_file and redirect_addr require cert_file"))
+	}
+	if c.ReloadInterval <= 0 {
+		errs = append(errs, errors.New("tls.reload_interval: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// certReloader serves the certificate and client CAs currently on disk,
+// reloading them when their files change so that rotated certificates are
+// picked up without a restart.
+type certReloader struct {
+	cfg TLSConfig
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTime   time.Time
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	r := &certReloader{cfg: cfg}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns the newest modification time of the watched files.
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload reads the files if they changed since the last load. A failed
+// reload keeps serving the previous certificate.
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clientCAs, r.modTime = &cert, clientCAs, modTime
+	return nil
+}
+
+// watch polls the files until ctx is done.
+func (r *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				logger.Error("reloading TLS certificates", "err", err)
+			}
+		}
+	}
+}
+
+// tlsConfig returns a server configuration that always uses the latest
+// certificate and client CAs, negotiating nextProtos over ALPN. The config
+// of each connection replaces this one, so it carries them too.
+func (r *certReloader) tlsConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientCAs:    r.clientCAs,
+				ClientAuth:   clientAuthTypes[r.cfg.ClientAuth],
+			}, nil
+		},
+	}
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Subject string
+	Source  string
+}
+
+type identityKey struct{}
+
+// identityFrom returns the caller identity established for the request, if
+// any.
+func identityFrom(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// clientCertMiddleware maps a verified client certificate to an Identity
+// whose subject is the certificate's common name.
+func clientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+			cn := request.TLS.VerifiedChains[0][0].Subject.CommonName
+			ctx := context.WithValue(request.Context(), identityKey{}, Identity{Subject: cn, Source: "mtls"})
+			request = request.WithContext(ctx)
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same path on httpsAddr.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		host, _, err := net.SplitHostPort(request.Host)
+		if err != nil {
+			host = request.Host
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(response, request, "https://"+host+request.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
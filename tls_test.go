@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA issues certificates for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM encoded certificate and key for cn.
+func (ca *testCA) issue(t *testing.T, cn string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, modTime, modTime)
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	cfg := TLSConfig{
+		CertFile:       filepath.Join(dir, "server.crt"),
+		KeyFile:        filepath.Join(dir, "server.key"),
+		ClientCAFile:   filepath.Join(dir, "ca.crt"),
+		ClientAuth:     "require",
+		ReloadInterval: time.Second,
+	}
+	certPEM, keyPEM := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	loaded := time.Now().Add(-time.Minute)
+	writeFile(t, cfg.CertFile, certPEM, loaded)
+	writeFile(t, cfg.KeyFile, keyPEM, loaded)
+	writeFile(t, cfg.ClientCAFile, ca.pem, loaded)
+
+	reloader, err := newCertReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(clientCertMiddleware(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		identity, _ := identityFrom(request.Context())
+		response.Write([]byte(identity.Subject))
+	})))
+	server.TLS = reloader.tlsConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	clientCertPEM, clientKeyPEM := ca.issue(t, "payroll-service", 3, x509.ExtKeyUsageClientAuth)
+	clientCert, _ := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert},
+	}}}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, 64)
+	n, _ := resp.Body.Read(body)
+	resp.Body.Close()
+	assert.Equal(t, "payroll-service", string(body[:n]))
+	assert.Equal(t, big.NewInt(2), resp.TLS.PeerCertificates[0].SerialNumber)
+
+	t.Run("it rejects clients without a certificate", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+		_, err := client.Get(server.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("it serves rotated certificates", func(t *testing.T) {
+		certPEM, keyPEM := ca.issue(t, "server", 4, x509.ExtKeyUsageServerAuth)
+		writeFile(t, cfg.CertFile, certPEM, time.Now())
+		writeFile(t, cfg.KeyFile, keyPEM, time.Now())
+		if err := reloader.reload(); err != nil {
+			t.Fatal(err)
+		}
+		client.Transport.(*http.Transport).CloseIdleConnections()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, big.NewInt(4), resp.TLS.PeerCertificates[0].SerialNumber)
+	})
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://hr.example.com:8080/employees?page=2", nil)
+	rr := httptest.NewRecorder()
+	redirectToHTTPS(":8443").ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusPermanentRedirect, rr.Code)
+	assert.Equal(t, "https://hr.example.com:8443/employees?page=2", rr.Header().Get("Location"))
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	cfg := defaultConfig().TLS
+	assert.NoError(t, cfg.validate())
+	cfg.CertFile = "server.crt"
+	cfg.ClientAuth = "require"
+	err := cfg.validate()
+	assert.ErrorContains(t, err, "key_file")
+	assert.ErrorContains(t, err, "client_ca_file")
+}
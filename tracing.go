@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/shubhamkhanna/mux_crud"
+
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing installs the global tracer provider and W3C trace-context
+// propagator. exporter is "otlp" (configured through the standard
+// OTEL_EXPORTER_OTLP_* variables), "stdout" or "none". The returned function
+// flushes and stops the provider.
+func setupTracing(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		spanExporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithAttributes(semconv.ServiceName("mux_crud")),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span for every request, continuing the
+// trace of the caller when it sends a traceparent header. The span is named
+// after the matched route template once routing is done.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		ctx, span := tracer.Start(ctx, request.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(request.Method),
+				semconv.URLPath(request.URL.Path),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: response}
+		request = withRouteHolder(request.WithContext(ctx))
+		next.ServeHTTP(recorder, request)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		if route := routeTemplate(request); route != "" {
+			span.SetName(request.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// tracedStore wraps every store call in a client span.
+type tracedStore struct {
+	next EmployeeStore
+	// system and collection name the backend and the table, collection or
+	// bucket holding employees.
+	system     attribute.KeyValue
+	collection string
+}
+
+func newTracedStore(next EmployeeStore, system attribute.KeyValue, collection string) tracedStore {
+	return tracedStore{next: next, system: system, collection: collection}
+}
+
+func (s tracedStore) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		s.system,
+		semconv.DBCollectionName(s.collection),
+		semconv.DBOperationName(operation),
+	)
+	return tracer.Start(ctx, "store."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != ErrNotFound {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s tracedStore) Insert(ctx context.Context, employee *Employee) (err error) {
+	ctx, span := s.start(ctx, "Insert", attribute.Int("employee.empid", employee.EmpID))
+	defer func() { endSpan(span, err) }()
+	return s.next.Insert(ctx, employee)
+}
+
+func (s tracedStore) FindByID(ctx context.Context, id string) (employee Employee, err error) {
+	ctx, span := s.start(ctx, "FindByID", attribute.String("employee.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.FindByID(ctx, id)
+}
+
+// queryAttributes describes the filters, sort and page of query, leaving
+// out the filters it does not set.
+func queryAttributes(query EmployeeQuery) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.Int("query.limit", query.Limit),
+		attribute.Int("query.skip", query.Skip),
+	}
+	if query.Practice != "" {
+		attrs = append(attrs, attribute.String("query.practice", query.Practice))
+	}
+	if query.Lastname != "" {
+		attrs = append(attrs, attribute.String("query.lastname", query.Lastname))
+	}
+	if query.EmpID != 0 {
+		attrs = append(attrs, attribute.Int("query.empid", query.EmpID))
+	}
+	if query.Sort != "" {
+		attrs = append(attrs, attribute.String("query.sort", query.Sort))
+	}
+	return attrs
+}
+
+func (s tracedStore) Find(ctx context.Context, query EmployeeQuery) (employees []Employee, err error) {
+	ctx, span := s.start(ctx, "Find", queryAttributes(query)...)
+	defer func() {
+		span.SetAttributes(attribute.Int("db.response.returned_rows", len(employees)))
+		endSpan(span, err)
+	}()
+	return s.next.Find(ctx, query)
+}
+
+// Iter keeps the span open until the iterator is closed.
+func (s tracedStore) Iter(ctx context.Context, query EmployeeQuery) (EmployeeIterator, error) {
+	ctx, span := s.start(ctx, "Iter", queryAttributes(query)...)
+	it, err := s.next.Iter(ctx, query)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedIter{next: it, span: span}, nil
+}
+
+type tracedIter struct {
+	next EmployeeIterator
+	span trace.Span
+	rows int
+}
+
+func (it *tracedIter) Next(employee *Employee) bool {
+	if !it.next.Next(employee) {
+		return false
+	}
+	it.rows++
+	return true
+}
+
+func (it *tracedIter) Close() error {
+	err := it.next.Close()
+	it.span.SetAttributes(attribute.Int("db.response.returned_rows", it.rows))
+	endSpan(it.span, err)
+	return err
+}
+
+func (s tracedStore) Update(ctx context.Context, id string, employee Employee) (err error) {
+	ctx, span := s.start(ctx, "Update", attribute.String("employee.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.Update(ctx, id, employee)
+}
+
+func (s tracedStore) Remove(ctx context.Context, id string) (err error) {
+	ctx, span := s.start(ctx, "Remove", attribute.String("employee.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.Remove(ctx, id)
+}
+
+func (s tracedStore) CountByPractice(ctx context.Context) (counts map[string]int, err error) {
+	ctx, span := s.start(ctx, "CountByPractice")
+	defer func() { endSpan(span, err) }()
+	return s.next.CountByPractice(ctx)
+}
+
+// Ping is not traced: readiness probes would drown out real traffic.
+func (s tracedStore) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
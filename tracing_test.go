@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traced := newTracedStore(stubStore{}, semconv.DBSystemNameSQLite, "employee")
+	r := mux.NewRouter()
+	r.HandleFunc("/employee/{id}", func(response http.ResponseWriter, request *http.Request) {
+		traced.FindByID(request.Context(), mux.Vars(request)["id"])
+	})
+	r.Use(recordRoute)
+	handler := tracingMiddleware(r)
+
+	req, _ := http.NewRequest("GET", "/employee/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans want 2", len(spans))
+	}
+	storeSpan, serverSpan := spans[0], spans[1]
+	assert.Equal(t, "store.FindByID", storeSpan.Name())
+	assert.Equal(t, "GET /employee/{id}", serverSpan.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", serverSpan.Parent().SpanID().String())
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), storeSpan.Parent().SpanID())
+
+	t.Run("store spans describe the backend and the query", func(t *testing.T) {
+		recorder.Reset()
+		traced.Find(context.Background(), EmployeeQuery{Limit: 10, Practice: "SAP", Sort: "-salary"})
+		spans := recorder.Ended()
+		if len(spans) != 1 {
+			t.Fatalf("got %d spans want 1", len(spans))
+		}
+		attrs := attribute.NewSet(spans[0].Attributes()...)
+		for key, want := range map[attribute.Key]string{
+			"db.system.name":     "sqlite",
+			"db.collection.name": "employee",
+			"query.practice":     "SAP",
+			"query.sort":         "-salary",
+		} {
+			got, _ := attrs.Value(key)
+			assert.Equal(t, want, got.AsString(), key)
+		}
+		assert.False(t, attrs.HasValue("query.lastname"), "unset filters are left out")
+	})
+
+	t.Run("it rejects unknown exporters", func(t *testing.T) {
+		_, err := setupTracing(context.Background(), "zipkin")
+		assert.Error(t, err)
+	})
+}
@@ -1,16 +1,74 @@
 package main
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/gorilla/mux"
 	"gopkg.in/mgo.v2"
 )
 
 var db *mgo.Database
+var store EmployeeStore
+
+// storeReady is set once store has been installed. Readers must check it
+// before touching store.
+var storeReady atomic.Bool
 var router = mux.NewRouter()
 
+// errorEnvelope is the body of every error response.
+type errorEnvelope struct {
+	XMLName   xml.Name `json:"-" xml:"error"`
+	Message   string   `json:"message" xml:"message" yaml:"message"`
+	RequestID string   `json:"request_id,omitempty" xml:"request_id,omitempty" yaml:"request_id,omitempty"`
+}
+
 func setResponseHeader(response http.ResponseWriter) {
 	response.Header().Set("content-type", "application/json")
-	response.Header().Set("Access-Control-Allow-Origin", "*")
+}
+
+// writeError writes an error envelope carrying the request id, in the media
+// type the client accepts or else JSON. Server errors are logged since the
+// access log only records the status.
+func writeError(response http.ResponseWriter, request *http.Request, status int, err error) {
+	requestID := requestIDFrom(request.Context())
+	if status >= http.StatusInternalServerError {
+		logger.ErrorContext(request.Context(), err.Error(), "status", status, "request_id", requestID)
+	}
+	envelope := errorEnvelope{Message: err.Error(), RequestID: requestID}
+	codec, ok := acceptedCodec(request)
+	if !ok {
+		codec = codecs[0]
+	}
+	body, merr := codec.Marshal(envelope)
+	if merr != nil {
+		codec = jsonCodec
+		body, _ = json.Marshal(envelope)
+	}
+	response.Header().Set("content-type", codec.MediaType)
+	response.WriteHeader(status)
+	response.Write(body)
+}
+
+// writeJSON writes body as JSON with status, for the management endpoints
+// that do not negotiate a codec.
+func writeJSON(response http.ResponseWriter, status int, body interface{}) {
+	result, _ := json.Marshal(body)
+	response.Header().Set("content-type", "application/json")
+	response.WriteHeader(status)
+	response.Write(result)
+}
+
+// requireStore answers 503 until the store is connected.
+func requireStore(handler http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		if !storeReady.Load() {
+			setResponseHeader(response)
+			writeError(response, request, http.StatusServiceUnavailable, errStoreUnavailable)
+			return
+		}
+		handler(response, request)
+	}
 }
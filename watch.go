@@ -0,0 +1,680 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	watchBackoff    = time.Second
+	watchMaxBackoff = time.Minute
+	// watchAwait is how long a change stream or oplog read waits for new
+	// entries before the watcher checks for shutdown and saves its position.
+	watchAwait = time.Second
+)
+
+// Mongo error codes telling the watcher what the server supports.
+const (
+	codeChangeStreamNotReplicaSet = 40573
+	codeUnrecognizedStage         = 40324
+	codeChangeStreamHistoryLost   = 286
+)
+
+// errWatchUnsupported is returned by a watch method the server cannot
+// serve, letting the auto mode try the next one.
+var errWatchUnsupported = errors.New("not supported by this server")
+
+// WatchConfig selects how writes made to the employee collection outside
+// the API are noticed: a change stream, the oplog, polling, auto for the
+// first the server supports, or off.
+type WatchConfig struct {
+	Mode         string        `yaml:"mode" toml:"mode"`
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+}
+
+func (c WatchConfig) validate() error {
+	var errs []error
+	switch c.Mode {
+	case "off", "auto", "changestream", "oplog", "poll":
+	default:
+		errs = append(errs, fmt.Errorf("watch.mode: unknown mode %q", c.Mode))
+	}
+	if c.PollInterval <= 0 {
+		errs = append(errs, errors.New("watch.poll_interval: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// collectionChange is a write to the employee collection as a change
+// stream, the oplog or polling sees it. Employee is the document after the
+// change, when known, and Fields the fields an update set or removed.
+type collectionChange struct {
+	Op       string // insert, update, replace or delete
+	ID       bson.ObjectId
+	Employee *Employee
+	Fields   []string
+}
+
+// externalEvent converts change, a write made outside the API, into an
+// employee event, reporting false for updates of documents removed since.
+func externalEvent(change collectionChange) (EmployeeEvent, bool) {
+	event := EmployeeEvent{Type: EventEmployeeUpdated, Time: time.Now()}
+	switch change.Op {
+	case "insert":
+		event.Type = EventEmployeeCreated
+	case "delete":
+		event.Type = EventEmployeeDeleted
+		event.Employee.ID = change.ID
+		return event, true
+	}
+	if change.Employee == nil {
+		// Removed again before it could be read; the deletion follows.
+		return event, false
+	}
+	event.Employee = *change.Employee
+	return event, true
+}
+
+// polledChanges compares two polls of the collection. Documents whose
+// content changed are reported as replacements.
+func polledChanges(before, after map[bson.ObjectId]Employee) []collectionChange {
+	var changes []collectionChange
+	for id, doc := range after {
+		old, ok := before[id]
+		switch {
+		case !ok:
+			changes = append(changes, collectionChange{Op: "insert", ID: id, Employee: &doc})
+		case old != doc:
+			changes = append(changes, collectionChange{Op: "replace", ID: id, Employee: &doc})
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			changes = append(changes, collectionChange{Op: "delete", ID: id})
+		}
+	}
+	slices.SortFunc(changes, func(a, b collectionChange) int { return strings.Compare(string(a.ID), string(b.ID)) })
+	return changes
+}
+
+// recordedByAPI reports whether entries, the outbox entries of a polled
+// change's document, hold the API's event for it: the deletion of a removed
+// document, or the document as polled.
+func recordedByAPI(change collectionChange, entries []OutboxEntry) bool {
+	for _, entry := range entries {
+		switch {
+		case change.Op == "delete":
+			if entry.Type == EventEmployeeDeleted {
+				return true
+			}
+		case change.Employee != nil && entry.Type != EventEmployeeDeleted && entry.Employee == *change.Employee:
+			return true
+		}
+	}
+	return false
+}
+
+// oplogUpdateFields returns the fields an oplog update entry changes, or
+// false when the entry replaces the whole document. It reads both the
+// modifier form ({$set: {...}}) and the diff form of MongoDB 5
+// ({$v: 2, diff: {u: {...}, d: {...}, s<field>: {...}}}).
+func oplogUpdateFields(o mongobson.M) ([]string, bool) {
+	var fields []string
+	modifiers := false
+	for key, value := range o {
+		if !strings.HasPrefix(key, "$") && key != "diff" {
+			continue
+		}
+		modifiers = true
+		if key != "diff" {
+			for _, e := range subdocument(value) {
+				fields = append(fields, e.Key)
+			}
+			continue
+		}
+		for _, section := range subdocument(value) {
+			switch kind := section.Key; {
+			case kind == "u" || kind == "i" || kind == "d":
+				for _, e := range subdocument(section.Value) {
+					fields = append(fields, e.Key)
+				}
+			case strings.HasPrefix(kind, "s"):
+				// A diff of a subdocument or array.
+				fields = append(fields, kind[1:])
+			}
+		}
+	}
+	slices.Sort(fields)
+	return fields, modifiers
+}
+
+// subdocument returns the fields of a decoded subdocument, which the driver
+// reads as a bson.M or a bson.D depending on its settings.
+func subdocument(value any) mongobson.D {
+	switch doc := value.(type) {
+	case mongobson.D:
+		return doc
+	case mongobson.M:
+		d := make(mongobson.D, 0, len(doc))
+		for key, value := range doc {
+			d = append(d, mongobson.E{Key: key, Value: value})
+		}
+		return d
+	}
+	return nil
+}
+
+// watchState is where the watcher got to, saved in the "watch_state"
+// collection so that a restart resumes without missing changes.
+type watchState struct {
+	ID          string              `bson:"_id"`
+	ResumeToken mongobson.Raw       `bson:"resume_token,omitempty"`
+	OplogTS     primitive.Timestamp `bson:"oplog_ts,omitempty"`
+}
+
+// employeeWatcher publishes writes to the employee collection that did not
+// go through the API as the same events the API's own writes produce.
+type employeeWatcher struct {
+	cfg       WatchConfig
+	mongo     MongoConfig
+	publisher Publisher
+}
+
+func newEmployeeWatcher(cfg WatchConfig, mongo MongoConfig, publisher Publisher) *employeeWatcher {
+	return &employeeWatcher{cfg: cfg, mongo: mongo, publisher: publisher}
+}
+
+// run watches until ctx is cancelled, restarting with backoff after errors.
+func (w *employeeWatcher) run(ctx context.Context) {
+	delay := watchBackoff
+	for {
+		wait := watchBackoff
+		if storeReady.Load() {
+			started := time.Now()
+			err := w.watch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if time.Since(started) > watchMaxBackoff {
+				delay = watchBackoff
+			}
+			logger.Error("watching employee collection", "mode", w.cfg.Mode, "err", err, "retry_in", delay)
+			wait, delay = delay, min(delay*2, watchMaxBackoff)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// watchClient is the official driver's client of either store driver, which
+// the watcher reads with since mgo predates change streams.
+func watchClient() *mongo.Client {
+	if mongoClient != nil {
+		return mongoClient
+	}
+	return schemaClient
+}
+
+// watch runs the configured mode, or in auto the first of a change stream,
+// the oplog and polling that the server supports.
+func (w *employeeWatcher) watch(ctx context.Context) error {
+	wdb := watchClient().Database(w.mongo.Database)
+	switch w.cfg.Mode {
+	case "changestream":
+		return w.changeStream(ctx, wdb)
+	case "oplog":
+		return w.oplog(ctx, wdb)
+	case "poll":
+		return w.poll(ctx, wdb)
+	}
+	err := w.changeStream(ctx, wdb)
+	if errors.Is(err, errWatchUnsupported) {
+		logger.Info("change streams unavailable, tailing the oplog", "err", err)
+		err = w.oplog(ctx, wdb)
+	}
+	if errors.Is(err, errWatchUnsupported) {
+		logger.Info("oplog unavailable, polling", "err", err, "interval", w.cfg.PollInterval)
+		err = w.poll(ctx, wdb)
+	}
+	return err
+}
+
+func (w *employeeWatcher) loadState(ctx context.Context, wdb *mongo.Database) (watchState, error) {
+	state := watchState{ID: "employee"}
+	err := wdb.Collection("watch_state").FindOne(ctx, mongobson.M{"_id": state.ID}).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = nil
+	}
+	return state, err
+}
+
+// saveState also saves while the watcher shuts down, so that it resumes
+// from the last change it published.
+func (w *employeeWatcher) saveState(ctx context.Context, wdb *mongo.Database, state watchState) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := wdb.Collection("watch_state").ReplaceOne(context.WithoutCancel(ctx), mongobson.M{"_id": state.ID}, state, opts)
+	return err
+}
+
+// publish sends the event for change, a write made outside the API.
+func (w *employeeWatcher) publish(ctx context.Context, change collectionChange) error {
+	event, ok := externalEvent(change)
+	if !ok {
+		return nil
+	}
+	return w.publisher.Publish(ctx, event)
+}
+
+// serverErrorCode returns the code of a server error, or 0.
+func serverErrorCode(err error) int32 {
+	var command mongo.CommandError
+	if errors.As(err, &command) {
+		return command.Code
+	}
+	return 0
+}
+
+// streamChange is a change stream event on the employee or outbox
+// collection. LSID and TxnNumber identify the transaction it belongs to.
+type streamChange struct {
+	OperationType string `bson:"operationType"`
+	NS            struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	FullDocument *Employee `bson:"fullDocument"`
+	DocumentKey  struct {
+		ID bson.ObjectId `bson:"_id"`
+	} `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields   mongobson.M `bson:"updatedFields"`
+		RemovedFields   []string    `bson:"removedFields"`
+		TruncatedArrays []struct {
+			Field string `bson:"field"`
+		} `bson:"truncatedArrays"`
+	} `bson:"updateDescription"`
+	LSID      mongobson.Raw `bson:"lsid"`
+	TxnNumber int64         `bson:"txnNumber"`
+}
+
+func (c streamChange) change() collectionChange {
+	change := collectionChange{Op: c.OperationType, ID: c.DocumentKey.ID, Employee: c.FullDocument}
+	for field := range c.UpdateDescription.UpdatedFields {
+		change.Fields = append(change.Fields, field)
+	}
+	change.Fields = append(change.Fields, c.UpdateDescription.RemovedFields...)
+	for _, array := range c.UpdateDescription.TruncatedArrays {
+		change.Fields = append(change.Fields, array.Field)
+	}
+	return change
+}
+
+// txn identifies the transaction of the change, or is empty outside one.
+func (c streamChange) txn() string {
+	if c.LSID == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x/%d", []byte(c.LSID), c.TxnNumber)
+}
+
+// changeStream follows a change stream from the saved resume token. The
+// official driver's store writes each outbox entry in the transaction of
+// its write, so the stream covers the outbox too: a transaction's changes
+// to the collection came through the API if it inserted an outbox entry,
+// and are published once its last change has been read.
+func (w *employeeWatcher) changeStream(ctx context.Context, wdb *mongo.Database) error {
+	state, err := w.loadState(ctx, wdb)
+	if err != nil {
+		return err
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: mongobson.M{"ns.coll": mongobson.M{"$in": []string{"employee", "outbox"}}}}}}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup).SetMaxAwaitTime(watchAwait)
+	if state.ResumeToken != nil {
+		opts.SetResumeAfter(state.ResumeToken)
+	}
+	stream, err := wdb.Watch(ctx, pipeline, opts)
+	switch serverErrorCode(err) {
+	case codeChangeStreamNotReplicaSet, codeUnrecognizedStage:
+		return fmt.Errorf("change streams: %w: %v", errWatchUnsupported, err)
+	case codeChangeStreamHistoryLost:
+		logger.Warn("change stream resume token expired, changes may have been missed", "err", err)
+		state.ResumeToken = nil
+		if err := w.saveState(ctx, wdb, state); err != nil {
+			return err
+		}
+		return w.changeStream(ctx, wdb)
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.WithoutCancel(ctx))
+	logger.Info("watching employee collection", "mode", "changestream", "resumed", state.ResumeToken != nil)
+
+	var (
+		txn     string             // transaction being read
+		ours    bool               // whether it inserted an outbox entry
+		pending []collectionChange // its changes to the collection
+		saved   time.Time
+	)
+	flush := func() error {
+		for _, change := range pending {
+			if err := w.publish(ctx, change); err != nil {
+				return err
+			}
+		}
+		pending = nil
+		return nil
+	}
+	for {
+		if !stream.TryNext(ctx) {
+			if err := stream.Err(); err != nil {
+				return err
+			}
+			// Nothing arrived for watchAwait, so the transaction
+			// being read has no more changes.
+			if err := flush(); err != nil {
+				return err
+			}
+			txn = ""
+			state.ResumeToken = stream.ResumeToken()
+			if err := w.saveState(ctx, wdb, state); err != nil {
+				return err
+			}
+			saved = time.Now()
+			continue
+		}
+		var c streamChange
+		if err := stream.Decode(&c); err != nil {
+			return err
+		}
+		switch c.OperationType {
+		case "invalidate", "drop", "rename", "dropDatabase":
+			state.ResumeToken = nil
+			w.saveState(ctx, wdb, state)
+			return fmt.Errorf("change stream ended by %s", c.OperationType)
+		}
+		if key := c.txn(); key != txn || key == "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			txn, ours = key, false
+		}
+		switch {
+		case c.NS.Coll == "outbox":
+			if txn != "" && c.OperationType == "insert" {
+				ours, pending = true, nil
+			}
+		case ours:
+		case txn != "":
+			pending = append(pending, c.change())
+		default:
+			if err := w.publish(ctx, c.change()); err != nil {
+				return err
+			}
+		}
+		// Save the position under steady traffic too, but only past
+		// published changes.
+		if len(pending) == 0 && time.Since(saved) > watchAwait {
+			state.ResumeToken = stream.ResumeToken()
+			if err := w.saveState(ctx, wdb, state); err != nil {
+				return err
+			}
+			saved = time.Now()
+		}
+	}
+}
+
+// oplogRegistry decodes oplog entries, whose documents vary with the
+// operation, as the clients do.
+var oplogRegistry = mongoRegistry()
+
+func decodeOplog(raw mongobson.Raw, val any) error {
+	decoder, err := mongobson.NewDecoder(bsonrw.NewBSONDocumentReader(raw))
+	if err != nil {
+		return err
+	}
+	if err := decoder.SetRegistry(oplogRegistry); err != nil {
+		return err
+	}
+	return decoder.Decode(val)
+}
+
+// oplogEntry is an entry of local.oplog.rs. A transaction is a single
+// applyOps command entry holding its operations.
+type oplogEntry struct {
+	TS primitive.Timestamp `bson:"ts"`
+	Op string              `bson:"op"`
+	NS string              `bson:"ns"`
+	O  mongobson.Raw       `bson:"o"`
+	O2 struct {
+		ID bson.ObjectId `bson:"_id"`
+	} `bson:"o2"`
+}
+
+// changes reads the collection changes of an entry on ns. A transaction
+// that inserted an outbox entry came through the API and yields none.
+func (e oplogEntry) changes(ctx context.Context, wdb *mongo.Database, ns string) ([]collectionChange, error) {
+	if e.Op != "c" {
+		change, err := e.change(ctx, wdb.Collection("employee"))
+		return []collectionChange{change}, err
+	}
+	var command struct {
+		ApplyOps []oplogEntry `bson:"applyOps"`
+	}
+	if err := decodeOplog(e.O, &command); err != nil {
+		return nil, err
+	}
+	var changes []collectionChange
+	for _, op := range command.ApplyOps {
+		switch op.NS {
+		case wdb.Name() + ".outbox":
+			return nil, nil
+		case ns:
+			change, err := op.change(ctx, wdb.Collection("employee"))
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// change reads the collection change of an entry, looking the document up
+// after updates since the entry only holds the modification.
+func (e oplogEntry) change(ctx context.Context, collection *mongo.Collection) (collectionChange, error) {
+	var change collectionChange
+	switch e.Op {
+	case "i":
+		var doc Employee
+		if err := decodeOplog(e.O, &doc); err != nil {
+			return change, err
+		}
+		return collectionChange{Op: "insert", ID: doc.ID, Employee: &doc}, nil
+	case "d":
+		var key struct {
+			ID bson.ObjectId `bson:"_id"`
+		}
+		err := decodeOplog(e.O, &key)
+		return collectionChange{Op: "delete", ID: key.ID}, err
+	}
+	var o mongobson.M
+	if err := decodeOplog(e.O, &o); err != nil {
+		return change, err
+	}
+	fields, modifiers := oplogUpdateFields(o)
+	change = collectionChange{Op: "update", ID: e.O2.ID, Fields: fields}
+	if !modifiers {
+		change.Op = "replace"
+	}
+	var doc Employee
+	err := collection.FindOne(ctx, mongobson.M{"_id": change.ID}).Decode(&doc)
+	if err == nil {
+		change.Employee = &doc
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return change, err
+	}
+	return change, nil
+}
+
+// oplog tails the replica set oplog, for servers without change streams.
+func (w *employeeWatcher) oplog(ctx context.Context, wdb *mongo.Database) error {
+	local := wdb.Client().Database("local")
+	names, err := local.ListCollectionNames(ctx, mongobson.M{"name": "oplog.rs"})
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("oplog: %w: not a replica set member", errWatchUnsupported)
+	}
+	oplog := local.Collection("oplog.rs")
+	state, err := w.loadState(ctx, wdb)
+	if err != nil {
+		return err
+	}
+	if state.OplogTS.IsZero() {
+		var last oplogEntry
+		opts := options.FindOne().SetSort(mongobson.M{"$natural": -1})
+		if err := oplog.FindOne(ctx, mongobson.M{}, opts).Decode(&last); err != nil {
+			return err
+		}
+		state.OplogTS = last.TS
+	} else {
+		var first oplogEntry
+		opts := options.FindOne().SetSort(mongobson.M{"$natural": 1})
+		if err := oplog.FindOne(ctx, mongobson.M{}, opts).Decode(&first); err == nil && state.OplogTS.Before(first.TS) {
+			logger.Warn("oplog rolled over since the last run, changes may have been missed")
+		}
+	}
+	logger.Info("watching employee collection", "mode", "oplog")
+
+	ns := wdb.Name() + ".employee"
+	opts := options.Find().SetCursorType(options.TailableAwait).SetMaxAwaitTime(watchAwait)
+	for {
+		filter := mongobson.M{
+			"ts": mongobson.M{"$gt": state.OplogTS},
+			"$or": mongobson.A{
+				mongobson.M{"ns": ns},
+				mongobson.M{"op": "c", "ns": "admin.$cmd", "o.applyOps.ns": ns},
+			},
+		}
+		cursor, err := oplog.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		err = w.tail(ctx, wdb, cursor, ns, &state)
+		cursor.Close(context.WithoutCancel(ctx))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tail publishes the entries of an oplog cursor until it dies.
+func (w *employeeWatcher) tail(ctx context.Context, wdb *mongo.Database, cursor *mongo.Cursor, ns string, state *watchState) error {
+	for {
+		for cursor.TryNext(ctx) {
+			var entry oplogEntry
+			if err := cursor.Decode(&entry); err != nil {
+				return err
+			}
+			changes, err := entry.changes(ctx, wdb, ns)
+			if err != nil {
+				return err
+			}
+			for _, change := range changes {
+				if err := w.publish(ctx, change); err != nil {
+					return err
+				}
+			}
+			state.OplogTS = entry.TS
+		}
+		if err := w.saveState(ctx, wdb, *state); err != nil {
+			return err
+		}
+		if err := cursor.Err(); err != nil {
+			return err
+		}
+		if cursor.ID() == 0 {
+			return nil
+		}
+	}
+}
+
+// poll compares the whole collection every PollInterval, for servers that
+// are not replica set members. A change is published at the poll after the
+// one that saw it, by when the outbox holds the API's entry for it if the
+// API made it. Polling only sees the state at each poll, so a document
+// changed twice in between yields one event, and changes made while the
+// service is down are not reported.
+func (w *employeeWatcher) poll(ctx context.Context, wdb *mongo.Database) error {
+	logger.Info("watching employee collection", "mode", "poll", "interval", w.cfg.PollInterval)
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+	var (
+		known   map[bson.ObjectId]Employee
+		polled  time.Time
+		changes []collectionChange // seen at the last poll
+		since   time.Time          // the poll before, less outboxCommitLag
+	)
+	for {
+		now := time.Now()
+		cursor, err := wdb.Collection("employee").Find(ctx, mongobson.M{})
+		if err != nil {
+			return err
+		}
+		var docs []Employee
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err := w.publishPolled(ctx, wdb, change, since); err != nil {
+				return err
+			}
+		}
+		current := make(map[bson.ObjectId]Employee, len(docs))
+		for _, doc := range docs {
+			current[doc.ID] = doc
+		}
+		changes = nil
+		if known != nil {
+			changes, since = polledChanges(known, current), polled.Add(-outboxCommitLag)
+		}
+		known, polled = current, now
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishPolled publishes change unless the outbox has recorded it since.
+func (w *employeeWatcher) publishPolled(ctx context.Context, wdb *mongo.Database, change collectionChange, since time.Time) error {
+	filter := mongobson.M{"employee._id": change.ID, "time": mongobson.M{"$gte": since}}
+	cursor, err := wdb.Collection("outbox").Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var entries []OutboxEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return err
+	}
+	if recordedByAPI(change, entries) {
+		return nil
+	}
+	return w.publish(ctx, change)
+}
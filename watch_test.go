@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestExternalEvent(t *testing.T) {
+	id := bson.NewObjectId()
+	doc := &Employee{ID: id, Firstname: "ravi"}
+
+	tests := []struct {
+		name   string
+		change collectionChange
+		want   string // event type, empty when skipped
+	}{
+		{"insert", collectionChange{Op: "insert", ID: id, Employee: doc}, EventEmployeeCreated},
+		{"update", collectionChange{Op: "update", ID: id, Employee: doc, Fields: []string{"salary"}}, EventEmployeeUpdated},
+		{"update of a removed document", collectionChange{Op: "update", ID: id, Fields: []string{"salary"}}, ""},
+		{"replacement", collectionChange{Op: "replace", ID: id, Employee: doc}, EventEmployeeUpdated},
+		{"delete", collectionChange{Op: "delete", ID: id}, EventEmployeeDeleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := externalEvent(tt.change)
+			if tt.want == "" {
+				assert.False(t, ok)
+				return
+			}
+			if assert.True(t, ok) {
+				assert.Equal(t, tt.want, event.Type)
+				assert.Equal(t, id, event.Employee.ID)
+			}
+		})
+	}
+}
+
+func TestPolledChanges(t *testing.T) {
+	kept, edited, removed, added := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	before := map[bson.ObjectId]Employee{
+		kept:    {ID: kept, Firstname: "ravi"},
+		edited:  {ID: edited, Firstname: "aditi"},
+		removed: {ID: removed},
+	}
+	after := map[bson.ObjectId]Employee{
+		kept:   {ID: kept, Firstname: "ravi"},
+		edited: {ID: edited, Firstname: "aditi", Salary: 10},
+		added:  {ID: added},
+	}
+
+	ops := make(map[bson.ObjectId]string)
+	for _, change := range polledChanges(before, after) {
+		ops[change.ID] = change.Op
+	}
+	assert.Equal(t, map[bson.ObjectId]string{edited: "replace", removed: "delete", added: "insert"}, ops)
+}
+
+func TestRecordedByAPI(t *testing.T) {
+	id := bson.NewObjectId()
+	polled := Employee{ID: id, Firstname: "ravi", Salary: 10}
+	entry := func(eventType string, employee Employee) OutboxEntry {
+		return OutboxEntry{ID: bson.NewObjectId(), Type: eventType, Employee: employee}
+	}
+	updated := entry(EventEmployeeUpdated, polled)
+	stale := entry(EventEmployeeUpdated, Employee{ID: id, Firstname: "ravi"})
+	deleted := entry(EventEmployeeDeleted, polled)
+
+	tests := []struct {
+		name    string
+		change  collectionChange
+		entries []OutboxEntry
+		want    bool
+	}{
+		{"the API's update", collectionChange{Op: "replace", ID: id, Employee: &polled}, []OutboxEntry{stale, updated}, true},
+		{"an outside edit after the API's", collectionChange{Op: "replace", ID: id, Employee: &polled}, []OutboxEntry{stale}, false},
+		{"the API's insert", collectionChange{Op: "insert", ID: id, Employee: &polled}, []OutboxEntry{entry(EventEmployeeCreated, polled)}, true},
+		{"no entries", collectionChange{Op: "insert", ID: id, Employee: &polled}, nil, false},
+		{"the API's delete", collectionChange{Op: "delete", ID: id}, []OutboxEntry{updated, deleted}, true},
+		{"an outside delete", collectionChange{Op: "delete", ID: id}, []OutboxEntry{updated}, false},
+		{"a document deleted since", collectionChange{Op: "replace", ID: id, Employee: &polled}, []OutboxEntry{deleted}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, recordedByAPI(tt.change, tt.entries))
+		})
+	}
+}
+
+func TestOplogUpdateFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		o         mongobson.M
+		fields    []string
+		modifiers bool
+	}{
+		{"modifiers", mongobson.M{"$set": mongobson.M{"salary": 10}, "$unset": mongobson.M{"practice": 1}}, []string{"practice", "salary"}, true},
+		{"several modifiers", mongobson.M{"$set": mongobson.M{"salary": 10, "txn-revno": 3}, "$pullAll": mongobson.M{"txn-queue": []string{"a"}}}, []string{"salary", "txn-queue", "txn-revno"}, true},
+		{"diff", mongobson.M{"$v": 2, "diff": mongobson.M{"u": mongobson.M{"salary": 10}, "d": mongobson.M{"practice": false}, "stxn-queue": mongobson.M{"a": true}}}, []string{"practice", "salary", "txn-queue"}, true},
+		{"decoded as bson.D", mongobson.M{"$v": 2, "diff": mongobson.D{{Key: "u", Value: mongobson.D{{Key: "salary", Value: 10}}}}}, []string{"salary"}, true},
+		{"replacement", mongobson.M{"_id": bson.NewObjectId(), "firstname": "ravi"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, modifiers := oplogUpdateFields(tt.o)
+			assert.Equal(t, tt.fields, fields)
+			assert.Equal(t, tt.modifiers, modifiers)
+		})
+	}
+}
+
+func TestOplogChanges(t *testing.T) {
+	id := bson.NewObjectId()
+	op := func(ns string) mongobson.M {
+		return mongobson.M{"op": "i", "ns": ns, "o": mongobson.M{"_id": id, "firstname": "ravi"}}
+	}
+	applyOps := func(ops ...mongobson.M) oplogEntry {
+		o, err := mongobson.MarshalWithRegistry(oplogRegistry, mongobson.M{"applyOps": ops})
+		assert.NoError(t, err)
+		return oplogEntry{Op: "c", NS: "admin.$cmd", O: o}
+	}
+	db := (&mongo.Client{}).Database("test")
+
+	changes, err := applyOps(op("test.employee"), op("test.other")).changes(context.Background(), db, "test.employee")
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, "insert", changes[0].Op)
+		assert.Equal(t, "ravi", changes[0].Employee.Firstname)
+	}
+
+	changes, err = applyOps(op("test.employee"), op("test.outbox")).changes(context.Background(), db, "test.employee")
+	assert.NoError(t, err)
+	assert.Empty(t, changes, "a transaction with an outbox entry came through the API")
+}
@@ -0,0 +1,903 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Delivery states. Dead deliveries have used up their attempts and wait in
+// the dead-letter list until replayed.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+// Failed deliveries back off from webhookBackoff, doubling up to
+// webhookMaxBackoff.
+const (
+	webhookBackoff    = 5 * time.Second
+	webhookMaxBackoff = time.Hour
+	webhookPoll       = time.Second
+	webhookBatch      = 100
+	// webhookClaimSlack is how much longer than the request timeout a
+	// claimed delivery is left to its instance before others may send it.
+	webhookClaimSlack = 30 * time.Second
+	// webhookLogLimit bounds the attempts kept on a delivery.
+	webhookLogLimit = 20
+)
+
+var (
+	errWebhookURL     = errors.New("url must be an absolute http or https URL")
+	errWebhookAddress = errors.New("webhook address is not public")
+)
+
+// WebhooksConfig sets how hard deliveries are tried and how many
+// subscriptions are sent to at once. Subscribers on loopback, private and
+// link-local addresses are refused unless AllowPrivateNetworks is set.
+type WebhooksConfig struct {
+	MaxAttempts          int           `yaml:"max_attempts" toml:"max_attempts"`
+	Timeout              time.Duration `yaml:"timeout" toml:"timeout"`
+	Workers              int           `yaml:"workers" toml:"workers"`
+	AllowPrivateNetworks bool          `yaml:"allow_private_networks" toml:"allow_private_networks"`
+}
+
+// Subscription sends the employee events it lists, or all of them when
+// Events is empty, to URL. The secret is only shown when it is created.
+type Subscription struct {
+	ID        bson.ObjectId `json:"id" bson:"_id"`
+	URL       string        `json:"url" bson:"url"`
+	Events    []string      `json:"events" bson:"events"`
+	Secret    string        `json:"secret,omitempty" bson:"secret"`
+	Active    bool          `json:"active" bson:"active"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+}
+
+func (s Subscription) wants(eventType string) bool {
+	if !s.Active {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event on its way to one subscription, with the log of
+// its attempts.
+type Delivery struct {
+	ID             bson.ObjectId     `json:"id" bson:"_id"`
+	SubscriptionID bson.ObjectId     `json:"subscription_id" bson:"subscription_id"`
+	Event          string            `json:"event" bson:"event"`
+	Payload        json.RawMessage   `json:"payload" bson:"payload"`
+	Status         string            `json:"status" bson:"status"`
+	Attempts       int               `json:"attempts" bson:"attempts"`
+	NextAttempt    time.Time         `json:"next_attempt,omitzero" bson:"next_attempt"`
+	Log            []DeliveryAttempt `json:"log" bson:"log"`
+	CreatedAt      time.Time         `json:"created_at" bson:"created_at"`
+}
+
+// DeliveryAttempt records the outcome of one POST to a subscriber.
+type DeliveryAttempt struct {
+	At         time.Time     `json:"at" bson:"at"`
+	StatusCode int           `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty" bson:"error,omitempty"`
+	Duration   time.Duration `json:"duration" bson:"duration"`
+}
+
+// DeliveryQuery selects deliveries, newest first. Empty fields match all.
+type DeliveryQuery struct {
+	SubscriptionID string
+	Status         string
+	Limit          int
+}
+
+// WebhookStore persists subscriptions and deliveries.
+type WebhookStore interface {
+	InsertSubscription(ctx context.Context, sub *Subscription) error
+	FindSubscription(ctx context.Context, id string) (Subscription, error)
+	FindSubscriptions(ctx context.Context) ([]Subscription, error)
+	UpdateSubscription(ctx context.Context, sub Subscription) error
+	RemoveSubscription(ctx context.Context, id string) error
+	// InsertDelivery stores delivery unless one with its id exists, so that
+	// publishing an event again adds nothing.
+	InsertDelivery(ctx context.Context, delivery *Delivery) error
+	FindDelivery(ctx context.Context, id string) (Delivery, error)
+	FindDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error)
+	// DueDeliveries returns up to limit pending deliveries whose next
+	// attempt is not after now, oldest first, leaving out those of the
+	// subscriptions in exclude.
+	DueDeliveries(ctx context.Context, now time.Time, exclude []bson.ObjectId, limit int) ([]Delivery, error)
+	// ClaimDelivery moves the next attempt of the delivery with id to until,
+	// if it is pending and due at now, and returns it. Only one of the
+	// instances claiming a delivery gets it; the others get ErrNotFound.
+	ClaimDelivery(ctx context.Context, id bson.ObjectId, now, until time.Time) (Delivery, error)
+	UpdateDelivery(ctx context.Context, delivery Delivery) error
+}
+
+// webhookStore is installed with store once Mongo is connected.
+var webhookStore WebhookStore
+
+// mgoWebhookStore keeps subscriptions in "webhooks" and deliveries in
+// "webhook_deliveries".
+type mgoWebhookStore struct {
+	db *mgo.Database
+}
+
+func (s mgoWebhookStore) subscriptions() *mgo.Collection { return s.db.C("webhooks") }
+func (s mgoWebhookStore) deliveries() *mgo.Collection    { return s.db.C("webhook_deliveries") }
+
+func (s mgoWebhookStore) InsertSubscription(ctx context.Context, sub *Subscription) error {
+	return s.subscriptions().Insert(sub)
+}
+
+func (s mgoWebhookStore) FindSubscription(ctx context.Context, id string) (Subscription, error) {
+	var sub Subscription
+	oid, err := objectID(id)
+	if err != nil {
+		return sub, err
+	}
+	return sub, mgoError(s.subscriptions().FindId(oid).One(&sub))
+}
+
+func (s mgoWebhookStore) FindSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subs := []Subscription{}
+	return subs, s.subscriptions().Find(nil).Sort("_id").All(&subs)
+}
+
+func (s mgoWebhookStore) UpdateSubscription(ctx context.Context, sub Subscription) error {
+	return mgoError(s.subscriptions().UpdateId(sub.ID, sub))
+}
+
+func (s mgoWebhookStore) RemoveSubscription(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	return mgoError(s.subscriptions().RemoveId(oid))
+}
+
+func (s mgoWebhookStore) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	err := s.deliveries().Insert(delivery)
+	if mgo.IsDup(err) {
+		return nil
+	}
+	return err
+}
+
+func (s mgoWebhookStore) FindDelivery(ctx context.Context, id string) (Delivery, error) {
+	var delivery Delivery
+	oid, err := objectID(id)
+	if err != nil {
+		return delivery, err
+	}
+	return delivery, mgoError(s.deliveries().FindId(oid).One(&delivery))
+}
+
+func (s mgoWebhookStore) FindDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	filter := bson.M{}
+	if query.SubscriptionID != "" {
+		oid, err := objectID(query.SubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		filter["subscription_id"] = oid
+	}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+	deliveries := []Delivery{}
+	return deliveries, s.deliveries().Find(filter).Sort("-_id").Limit(query.Limit).All(&deliveries)
+}
+
+func (s mgoWebhookStore) DueDeliveries(ctx context.Context, now time.Time, exclude []bson.ObjectId, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	filter := bson.M{"status": deliveryPending, "next_attempt": bson.M{"$lte": now}}
+	if len(exclude) > 0 {
+		filter["subscription_id"] = bson.M{"$nin": exclude}
+	}
+	return deliveries, s.deliveries().Find(filter).Sort("next_attempt", "_id").Limit(limit).All(&deliveries)
+}
+
+func (s mgoWebhookStore) ClaimDelivery(ctx context.Context, id bson.ObjectId, now, until time.Time) (Delivery, error) {
+	var delivery Delivery
+	filter := bson.M{"_id": id, "status": deliveryPending, "next_attempt": bson.M{"$lte": now}}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"next_attempt": until}}, ReturnNew: true}
+	_, err := s.deliveries().Find(filter).Apply(change, &delivery)
+	return delivery, mgoError(err)
+}
+
+func (s mgoWebhookStore) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	return mgoError(s.deliveries().UpdateId(delivery.ID, delivery))
+}
+
+// webhookPayload is the body POSTed to subscribers.
+type webhookPayload struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      Employee  `json:"data"`
+}
+
+// signWebhook returns the X-Webhook-Signature of body sent at timestamp:
+// the hex HMAC-SHA256, keyed by the subscription secret, of
+// "<timestamp>.<body>". Including the timestamp lets receivers reject
+// replayed requests.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonPublicPrefixes are the special-purpose ranges the netip predicates do
+// not cover.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// publicOnly is a net.Dialer Control refusing addresses that are not
+// publicly routable, so that subscriptions cannot reach the service's own
+// network. It sees the address actually dialed, after DNS and on redirects.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	public := ip.IsGlobalUnicast() && !ip.IsPrivate()
+	for _, prefix := range nonPublicPrefixes {
+		public = public && !prefix.Contains(ip)
+	}
+	if !public {
+		return fmt.Errorf("%w: %s", errWebhookAddress, ip)
+	}
+	return nil
+}
+
+// webhookClient returns the client deliveries are POSTed with. Guarded
+// clients dial subscribers directly, as checking a proxy's address would
+// say nothing of the subscriber's.
+func webhookClient(cfg WebhooksConfig) *http.Client {
+	if cfg.AllowPrivateNetworks {
+		return &http.Client{Timeout: cfg.Timeout}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicOnly}).DialContext
+	return &http.Client{Timeout: cfg.Timeout, Transport: transport}
+}
+
+// webhookDispatcher turns employee events into deliveries and sends them.
+// Each subscription's deliveries are sent in turn by one worker, with up to
+// workers subscriptions at once, so that a slow subscriber only holds up
+// its own deliveries.
+type webhookDispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	workers     int
+	claim       time.Duration
+	wake        chan struct{}
+
+	mu      sync.Mutex
+	sending map[bson.ObjectId]bool // subscriptions a worker is sending to
+}
+
+// webhooks is installed by run while the server is up.
+var webhooks *webhookDispatcher
+
+func newWebhookDispatcher(cfg WebhooksConfig) *webhookDispatcher {
+	return &webhookDispatcher{
+		client:      webhookClient(cfg),
+		maxAttempts: cfg.MaxAttempts,
+		workers:     cfg.Workers,
+		claim:       cfg.Timeout + webhookClaimSlack,
+		wake:        make(chan struct{}, 1),
+		sending:     make(map[bson.ObjectId]bool),
+	}
+}
+
+// notify wakes the sender for deliveries that are due now.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliveryID is the id of the delivery of the event with eventID to sub:
+// the event's time, so that deliveries list in about the order of their
+// events, followed by a hash of both ids.
+func deliveryID(eventID string, eventTime time.Time, sub bson.ObjectId) bson.ObjectId {
+	id := make([]byte, 12)
+	binary.BigEndian.PutUint32(id, uint32(eventTime.Unix()))
+	sum := sha256.Sum256([]byte(eventID + "/" + sub.Hex()))
+	copy(id[4:], sum[:])
+	return bson.ObjectId(id)
+}
+
+// Publish stores a pending delivery of event for every subscription that
+// wants it. It is a Publisher of the outbox relay, so an event is only
+// acked once its deliveries are stored. Delivery ids follow from the event
+// id, so an event published again, by a retry or another instance, adds no
+// deliveries.
+func (d *webhookDispatcher) Publish(ctx context.Context, event EmployeeEvent) error {
+	subs, err := webhookStore.FindSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	if event.ID == "" {
+		event.ID = bson.NewObjectId().Hex()
+	}
+	payload, err := json.Marshal(webhookPayload{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.Time.UTC(),
+		Data:      event.Employee,
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, sub := range subs {
+		if !sub.wants(event.Type) {
+			continue
+		}
+		now := time.Now().UTC()
+		delivery := Delivery{
+			ID:             deliveryID(event.ID, event.Time, sub.ID),
+			SubscriptionID: sub.ID,
+			Event:          event.Type,
+			Payload:        payload,
+			Status:         deliveryPending,
+			NextAttempt:    now,
+			Log:            []DeliveryAttempt{},
+			CreatedAt:      now,
+		}
+		errs = append(errs, webhookStore.InsertDelivery(ctx, &delivery))
+	}
+	d.notify()
+	return errors.Join(errs...)
+}
+
+// run hands out due deliveries whenever woken and at least every
+// webhookPoll until ctx is cancelled, then waits for the workers.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	ticker := time.NewTicker(webhookPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		if storeReady.Load() {
+			d.dispatch(ctx, &workers)
+		}
+	}
+}
+
+// dispatch starts a worker for each subscription with due deliveries that
+// has none yet, while workers are free.
+func (d *webhookDispatcher) dispatch(ctx context.Context, workers *sync.WaitGroup) {
+	d.mu.Lock()
+	busy := make([]bson.ObjectId, 0, len(d.sending))
+	for id := range d.sending {
+		busy = append(busy, id)
+	}
+	d.mu.Unlock()
+	if len(busy) >= d.workers {
+		return
+	}
+	due, err := webhookStore.DueDeliveries(ctx, time.Now().UTC(), busy, webhookBatch)
+	if err != nil {
+		logger.Error("finding due webhook deliveries", "err", err)
+		return
+	}
+	var subs []bson.ObjectId
+	bySub := make(map[bson.ObjectId][]Delivery)
+	for _, delivery := range due {
+		if _, ok := bySub[delivery.SubscriptionID]; !ok {
+			subs = append(subs, delivery.SubscriptionID)
+		}
+		bySub[delivery.SubscriptionID] = append(bySub[delivery.SubscriptionID], delivery)
+	}
+	for _, sub := range subs[:min(len(subs), d.workers-len(busy))] {
+		d.mu.Lock()
+		d.sending[sub] = true
+		d.mu.Unlock()
+		workers.Add(1)
+		go func(deliveries []Delivery) {
+			defer workers.Done()
+			for _, delivery := range deliveries {
+				if ctx.Err() != nil {
+					break
+				}
+				now := time.Now().UTC()
+				claimed, err := webhookStore.ClaimDelivery(ctx, delivery.ID, now, now.Add(d.claim))
+				if errors.Is(err, ErrNotFound) {
+					continue // sent or claimed by another instance
+				}
+				if err != nil {
+					logger.Error("claiming webhook delivery", "delivery", delivery.ID.Hex(), "err", err)
+					break
+				}
+				d.attempt(ctx, claimed)
+			}
+			d.mu.Lock()
+			delete(d.sending, sub)
+			d.mu.Unlock()
+			d.notify()
+		}(bySub[sub])
+	}
+}
+
+// attempt POSTs delivery once and records the outcome. Any 2xx response
+// counts as delivered.
+func (d *webhookDispatcher) attempt(ctx context.Context, delivery Delivery) Delivery {
+	sub, err := webhookStore.FindSubscription(ctx, delivery.SubscriptionID.Hex())
+	if errors.Is(err, ErrNotFound) {
+		delivery.Status = deliveryDead
+		delivery.Log = append(delivery.Log, DeliveryAttempt{At: time.Now().UTC(), Error: "subscription removed"})
+		d.save(ctx, delivery)
+		return delivery
+	}
+	if err != nil {
+		logger.Error("finding webhook subscription", "delivery", delivery.ID.Hex(), "err", err)
+		return delivery
+	}
+
+	start := time.Now()
+	result := DeliveryAttempt{At: start.UTC()}
+	result.StatusCode, err = d.post(ctx, sub, delivery)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	delivery.Attempts++
+	delivery.Log = append(delivery.Log, result)
+	if len(delivery.Log) > webhookLogLimit {
+		delivery.Log = delivery.Log[len(delivery.Log)-webhookLogLimit:]
+	}
+	switch {
+	case err == nil:
+		delivery.Status = deliveryDelivered
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = deliveryDead
+		logger.Warn("webhook delivery dead-lettered", "delivery", delivery.ID.Hex(), "url", sub.URL, "err", err)
+	default:
+		delay := min(webhookBackoff<<(delivery.Attempts-1), webhookMaxBackoff)
+		delivery.NextAttempt = time.Now().UTC().Add(delay)
+	}
+	d.save(ctx, delivery)
+	return delivery
+}
+
+func (d *webhookDispatcher) save(ctx context.Context, delivery Delivery) {
+	if err := webhookStore.UpdateDelivery(ctx, delivery); err != nil {
+		logger.Error("saving webhook delivery", "delivery", delivery.ID.Hex(), "err", err)
+	}
+}
+
+// post sends the signed payload, returning the response status.
+func (d *webhookDispatcher) post(ctx context.Context, sub Subscription, delivery Delivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mux_crud-webhooks")
+	req.Header.Set("X-Webhook-Id", delivery.ID.Hex())
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(sub.Secret, timestamp, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("subscriber answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// subscriptionRequest is the body of POST and PUT /webhooks.
+type subscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+// apply validates req and copies it onto sub.
+func (req subscriptionRequest) apply(sub *Subscription) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errWebhookURL
+	}
+	for _, e := range req.Events {
+		if e != EventEmployeeCreated && e != EventEmployeeUpdated && e != EventEmployeeDeleted {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	sub.URL, sub.Events = req.URL, req.Events
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	if req.Secret != "" {
+		sub.Secret = req.Secret
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+	return nil
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func decodeSubscriptionRequest(response http.ResponseWriter, request *http.Request) (subscriptionRequest, bool) {
+	var req subscriptionRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		writeError(response, request, http.StatusBadRequest, err)
+		return req, false
+	}
+	return req, true
+}
+
+func writeWebhookError(response http.ResponseWriter, request *http.Request, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(response, request, http.StatusNotFound, err)
+		return
+	}
+	writeError(response, request, http.StatusInternalServerError, err)
+}
+
+// CreateWebhookEndpoint subscribes a URL to employee events.
+func CreateWebhookEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation POST /webhooks CreateWebhookEndpoint
+	//
+	// Subscribes a URL to employee events.
+	// Deliveries are signed with the secret, which is generated when not
+	// given and only returned here.
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// responses:
+	//   '201':
+	//     description: subscription
+	//   '400':
+	//     description: bad request
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	req, ok := decodeSubscriptionRequest(response, request)
+	if !ok {
+		return
+	}
+	sub := Subscription{ID: bson.NewObjectId(), Secret: newWebhookSecret(), Active: true, CreatedAt: time.Now().UTC()}
+	if err := req.apply(&sub); err != nil {
+		writeError(response, request, http.StatusBadRequest, err)
+		return
+	}
+	if err := webhookStore.InsertSubscription(request.Context(), &sub); err != nil {
+		writeError(response, request, http.StatusInternalServerError, err)
+		return
+	}
+	response.Header().Set("Location", "/webhooks/"+sub.ID.Hex())
+	writeJSON(response, http.StatusCreated, sub)
+}
+
+// GetWebhooksEndpoint lists the subscriptions.
+func GetWebhooksEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /webhooks GetWebhooksEndpoint
+	//
+	// Lists webhook subscriptions.
+	// ---
+	// produces:
+	// - application/json
+	// responses:
+	//   '200':
+	//     description: subscriptions
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	subs, err := webhookStore.FindSubscriptions(request.Context())
+	if err != nil {
+		writeError(response, request, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	writeJSON(response, http.StatusOK, subs)
+}
+
+// GetWebhookEndpoint returns one subscription.
+func GetWebhookEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /webhooks/{id} GetWebhookEndpoint
+	//
+	// Returns a webhook subscription.
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: subscription id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: subscription
+	//   '404':
+	//     description: not found
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	sub, err := webhookStore.FindSubscription(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		writeWebhookError(response, request, err)
+		return
+	}
+	sub.Secret = ""
+	writeJSON(response, http.StatusOK, sub)
+}
+
+// UpdateWebhookEndpoint replaces a subscription's URL, events and state,
+// and its secret when one is given.
+func UpdateWebhookEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation PUT /webhooks/{id} UpdateWebhookEndpoint
+	//
+	// Updates a webhook subscription.
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: subscription id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: subscription
+	//   '400':
+	//     description: bad request
+	//   '404':
+	//     description: not found
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	sub, err := webhookStore.FindSubscription(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		writeWebhookError(response, request, err)
+		return
+	}
+	req, ok := decodeSubscriptionRequest(response, request)
+	if !ok {
+		return
+	}
+	if err := req.apply(&sub); err != nil {
+		writeError(response, request, http.StatusBadRequest, err)
+		return
+	}
+	if err := webhookStore.UpdateSubscription(request.Context(), sub); err != nil {
+		writeWebhookError(response, request, err)
+		return
+	}
+	sub.Secret = ""
+	writeJSON(response, http.StatusOK, sub)
+}
+
+// DeleteWebhookEndpoint removes a subscription. Its pending deliveries are
+// dead-lettered when next attempted.
+func DeleteWebhookEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation DELETE /webhooks/{id} DeleteWebhookEndpoint
+	//
+	// Removes a webhook subscription.
+	// ---
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: subscription id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '204':
+	//     description: removed
+	//   '404':
+	//     description: not found
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	if err := webhookStore.RemoveSubscription(request.Context(), mux.Vars(request)["id"]); err != nil {
+		writeWebhookError(response, request, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveriesEndpoint lists deliveries, newest first: those of one
+// subscription under /webhooks/{id}/deliveries, and the dead-letter list
+// with status=dead.
+func GetWebhookDeliveriesEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /webhooks/deliveries GetWebhookDeliveriesEndpoint
+	//
+	// Lists webhook deliveries with their attempt logs.
+	// Also served as /webhooks/{id}/deliveries for one subscription.
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: status
+	//   in: query
+	//   description: pending, delivered or dead (the dead-letter list)
+	//   type: string
+	// - name: limit
+	//   in: query
+	//   description: most deliveries returned, 100 by default
+	//   type: integer
+	// responses:
+	//   '200':
+	//     description: deliveries
+	//   '400':
+	//     description: bad request
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	query := DeliveryQuery{SubscriptionID: mux.Vars(request)["id"], Status: request.FormValue("status"), Limit: 100}
+	switch query.Status {
+	case "", deliveryPending, deliveryDelivered, deliveryDead:
+	default:
+		writeError(response, request, http.StatusBadRequest, fmt.Errorf("unknown status %q", query.Status))
+		return
+	}
+	if limit, err := strconv.Atoi(request.FormValue("limit")); err == nil && limit > 0 {
+		query.Limit = limit
+	}
+	if query.SubscriptionID != "" {
+		if _, err := webhookStore.FindSubscription(request.Context(), query.SubscriptionID); err != nil {
+			writeWebhookError(response, request, err)
+			return
+		}
+	}
+	deliveries, err := webhookStore.FindDeliveries(request.Context(), query)
+	if err != nil {
+		writeWebhookError(response, request, err)
+		return
+	}
+	writeJSON(response, http.StatusOK, deliveries)
+}
+
+// GetWebhookDeliveryEndpoint returns one delivery with its attempt log.
+func GetWebhookDeliveryEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /webhooks/deliveries/{id} GetWebhookDeliveryEndpoint
+	//
+	// Returns a webhook delivery with its attempt log.
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: delivery id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '200':
+	//     description: delivery
+	//   '404':
+	//     description: not found
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	delivery, err := webhookStore.FindDelivery(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		writeWebhookError(response, request, err)
+		return
+	}
+	writeJSON(response, http.StatusOK, delivery)
+}
+
+// ReplayWebhookDeliveryEndpoint queues a delivery to be sent again with a
+// fresh set of attempts, whatever its state.
+func ReplayWebhookDeliveryEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation POST /webhooks/deliveries/{id}/replay ReplayWebhookDeliveryEndpoint
+	//
+	// Replays a webhook delivery.
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: delivery id
+	//   required: true
+	//   type: string
+	// responses:
+	//   '202':
+	//     description: delivery queued
+	//   '404':
+	//     description: not found
+	//   default:
+	//     description: unexpected error
+
+	setResponseHeader(response)
+	delivery, err := webhookStore.FindDelivery(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		writeWebhookError(response, request, err)
+		return
+	}
+	delivery.Status, delivery.Attempts, delivery.NextAttempt = deliveryPending, 0, time.Now().UTC()
+	if err := webhookStore.UpdateDelivery(request.Context(), delivery); err != nil {
+		writeWebhookError(response, request, err)
+		return
+	}
+	if webhooks != nil {
+		webhooks.notify()
+	}
+	writeJSON(response, http.StatusAccepted, delivery)
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memoryWebhookStore is an in-memory WebhookStore for tests.
+type memoryWebhookStore struct {
+	mu            sync.Mutex
+	subscriptions map[bson.ObjectId]Subscription
+	deliveries    map[bson.ObjectId]Delivery
+}
+
+func (s *memoryWebhookStore) InsertSubscription(ctx context.Context, sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[sub.ID] = *sub
+	return nil
+}
+
+func (s *memoryWebhookStore) FindSubscription(ctx context.Context, id string) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[bson.ObjectId(bsonID(id))]
+	if !ok {
+		return sub, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (s *memoryWebhookStore) FindSubscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := []Subscription{}
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return subs, nil
+}
+
+func (s *memoryWebhookStore) UpdateSubscription(ctx context.Context, sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[sub.ID]; !ok {
+		return ErrNotFound
+	}
+	s.subscriptions[sub.ID] = sub
+	return nil
+}
+
+func (s *memoryWebhookStore) RemoveSubscription(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oid := bson.ObjectId(bsonID(id))
+	if _, ok := s.subscriptions[oid]; !ok {
+		return ErrNotFound
+	}
+	delete(s.subscriptions, oid)
+	return nil
+}
+
+func (s *memoryWebhookStore) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deliveries[delivery.ID]; !ok {
+		s.deliveries[delivery.ID] = *delivery
+	}
+	return nil
+}
+
+func (s *memoryWebhookStore) FindDelivery(ctx context.Context, id string) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery, ok := s.deliveries[bson.ObjectId(bsonID(id))]
+	if !ok {
+		return delivery, ErrNotFound
+	}
+	return delivery, nil
+}
+
+func (s *memoryWebhookStore) FindDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []Delivery{}
+	for _, d := range s.deliveries {
+		if query.SubscriptionID != "" && d.SubscriptionID.Hex() != query.SubscriptionID {
+			continue
+		}
+		if query.Status != "" && d.Status != query.Status {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if query.Limit > 0 && query.Limit < len(deliveries) {
+		deliveries = deliveries[:query.Limit]
+	}
+	return deliveries, nil
+}
+
+func (s *memoryWebhookStore) DueDeliveries(ctx context.Context, now time.Time, exclude []bson.ObjectId, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Delivery
+	for _, d := range s.deliveries {
+		if d.Status == deliveryPending && !d.NextAttempt.After(now) && !slices.Contains(exclude, d.SubscriptionID) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	return due[:min(limit, len(due))], nil
+}
+
+func (s *memoryWebhookStore) ClaimDelivery(ctx context.Context, id bson.ObjectId, now, until time.Time) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok || d.Status != deliveryPending || d.NextAttempt.After(now) {
+		return Delivery{}, ErrNotFound
+	}
+	d.NextAttempt = until
+	s.deliveries[id] = d
+	return d, nil
+}
+
+func (s *memoryWebhookStore) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deliveries[delivery.ID]; !ok {
+		return ErrNotFound
+	}
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+// bsonID returns the raw ObjectId of a hex id, or "" for malformed ones.
+func bsonID(id string) string {
+	if !bson.IsObjectIdHex(id) {
+		return ""
+	}
+	return string(bson.ObjectIdHex(id))
+}
+
+// useMemoryWebhookStore installs an empty memoryWebhookStore for the
+// duration of the test.
+func useMemoryWebhookStore(t *testing.T) *memoryWebhookStore {
+	saved := webhookStore
+	mem := &memoryWebhookStore{subscriptions: map[bson.ObjectId]Subscription{}, deliveries: map[bson.ObjectId]Delivery{}}
+	webhookStore = mem
+	t.Cleanup(func() { webhookStore = saved })
+	return mem
+}
+
+func webhooksRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/webhooks", CreateWebhookEndpoint).Methods("POST")
+	r.HandleFunc("/webhooks", GetWebhooksEndpoint).Methods("GET")
+	r.HandleFunc("/webhooks/deliveries", GetWebhookDeliveriesEndpoint).Methods("GET")
+	r.HandleFunc("/webhooks/deliveries/{id}", GetWebhookDeliveryEndpoint).Methods("GET")
+	r.HandleFunc("/webhooks/deliveries/{id}/replay", ReplayWebhookDeliveryEndpoint).Methods("POST")
+	r.HandleFunc("/webhooks/{id}", GetWebhookEndpoint).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", UpdateWebhookEndpoint).Methods("PUT")
+	r.HandleFunc("/webhooks/{id}", DeleteWebhookEndpoint).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", GetWebhookDeliveriesEndpoint).Methods("GET")
+	return r
+}
+
+func serveWebhooks(r http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	useMemoryWebhookStore(t)
+	r := webhooksRouter()
+
+	rr := serveWebhooks(r, "POST", "/webhooks", `{"url":"https://badges.example.com/hook","events":["employee.created"]}`)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var sub Subscription
+	json.Unmarshal(rr.Body.Bytes(), &sub)
+	assert.Len(t, sub.Secret, 64)
+	assert.True(t, sub.Active)
+	assert.Equal(t, "/webhooks/"+sub.ID.Hex(), rr.Header().Get("Location"))
+
+	t.Run("it hides the secret after creation", func(t *testing.T) {
+		rr := serveWebhooks(r, "GET", "/webhooks/"+sub.ID.Hex(), "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "secret")
+		rr = serveWebhooks(r, "GET", "/webhooks", "")
+		assert.NotContains(t, rr.Body.String(), sub.Secret)
+	})
+
+	t.Run("it updates subscriptions", func(t *testing.T) {
+		rr := serveWebhooks(r, "PUT", "/webhooks/"+sub.ID.Hex(), `{"url":"https://badges.example.com/v2","active":false}`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var updated Subscription
+		json.Unmarshal(rr.Body.Bytes(), &updated)
+		assert.Equal(t, "https://badges.example.com/v2", updated.URL)
+		assert.Empty(t, updated.Events)
+		assert.False(t, updated.Active)
+		stored, _ := webhookStore.FindSubscription(context.Background(), sub.ID.Hex())
+		assert.Equal(t, sub.Secret, stored.Secret)
+	})
+
+	t.Run("it validates subscriptions", func(t *testing.T) {
+		for _, body := range []string{
+			`{"url":"ftp://example.com"}`,
+			`{"url":"/relative"}`,
+			`{"url":"https://example.com","events":["employee.promoted"]}`,
+			`{"url":`,
+		} {
+			rr := serveWebhooks(r, "POST", "/webhooks", body)
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("%s: handler returned wrong status code: got %v want %v", body, status, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("it deletes subscriptions", func(t *testing.T) {
+		rr := serveWebhooks(r, "DELETE", "/webhooks/"+sub.ID.Hex(), "")
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		rr = serveWebhooks(r, "GET", "/webhooks/"+sub.ID.Hex(), "")
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
+
+// webhookReceiver records the deliveries it accepts and answers status.
+type webhookReceiver struct {
+	secret string
+	status atomic.Int32
+	mu     sync.Mutex
+	got    []webhookPayload
+	errs   []string
+}
+
+func (rec *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte(rec.secret))
+	mac.Write([]byte(r.Header.Get("X-Webhook-Timestamp") + "." + string(body)))
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if r.Header.Get("X-Webhook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		rec.errs = append(rec.errs, "bad signature")
+	}
+	if status := int(rec.status.Load()); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	var payload webhookPayload
+	json.Unmarshal(body, &payload)
+	if r.Header.Get("X-Webhook-Event") != payload.Type {
+		rec.errs = append(rec.errs, "event header mismatch")
+	}
+	rec.got = append(rec.got, payload)
+}
+
+func (rec *webhookReceiver) received() []webhookPayload {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]webhookPayload(nil), rec.got...)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	useMemoryWebhookStore(t)
+	ctx := context.Background()
+	rec := &webhookReceiver{secret: "s3cret"}
+	rec.status.Store(http.StatusOK)
+	server := httptest.NewServer(rec)
+	defer server.Close()
+	sub := Subscription{ID: bson.NewObjectId(), URL: server.URL, Events: []string{EventEmployeeCreated}, Secret: rec.secret, Active: true}
+	webhookStore.InsertSubscription(ctx, &sub)
+	d := newWebhookDispatcher(WebhooksConfig{MaxAttempts: 2, Timeout: time.Second, Workers: 2, AllowPrivateNetworks: true})
+
+	ravi := Employee{ID: bson.NewObjectId(), Firstname: "ravi", EmpID: 7}
+	assert.NoError(t, d.Publish(ctx, EmployeeEvent{Type: EventEmployeeCreated, Employee: ravi, Time: time.Now()}))
+	assert.NoError(t, d.Publish(ctx, EmployeeEvent{Type: EventEmployeeDeleted, Employee: ravi, Time: time.Now()}))
+	pending, _ := webhookStore.DueDeliveries(ctx, time.Now(), nil, 10)
+	if !assert.Len(t, pending, 1) {
+		return
+	}
+
+	t.Run("it signs and sends deliveries", func(t *testing.T) {
+		delivery := d.attempt(ctx, pending[0])
+		assert.Equal(t, deliveryDelivered, delivery.Status)
+		assert.Equal(t, http.StatusOK, delivery.Log[0].StatusCode)
+		got := rec.received()
+		assert.Len(t, got, 1)
+		assert.Equal(t, EventEmployeeCreated, got[0].Type)
+		assert.Equal(t, ravi, got[0].Data)
+	})
+
+	t.Run("it retries then dead-letters", func(t *testing.T) {
+		rec.status.Store(http.StatusServiceUnavailable)
+		delivery := d.attempt(ctx, pending[0])
+		assert.Equal(t, deliveryPending, delivery.Status)
+		assert.WithinDuration(t, time.Now().Add(webhookBackoff), delivery.NextAttempt, time.Second)
+		delivery = d.attempt(ctx, delivery)
+		assert.Equal(t, deliveryDead, delivery.Status)
+		assert.Equal(t, http.StatusServiceUnavailable, delivery.Log[len(delivery.Log)-1].StatusCode)
+
+		r := webhooksRouter()
+		rr := serveWebhooks(r, "GET", "/webhooks/deliveries?status=dead", "")
+		var dead []Delivery
+		json.Unmarshal(rr.Body.Bytes(), &dead)
+		assert.Len(t, dead, 1)
+		rr = serveWebhooks(r, "GET", "/webhooks/"+sub.ID.Hex()+"/deliveries", "")
+		assert.Contains(t, rr.Body.String(), delivery.ID.Hex())
+	})
+
+	t.Run("it replays deliveries", func(t *testing.T) {
+		rec.status.Store(http.StatusOK)
+		rr := serveWebhooks(webhooksRouter(), "POST", "/webhooks/deliveries/"+pending[0].ID.Hex()+"/replay", "")
+		if status := rr.Code; status != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+		}
+		due, _ := webhookStore.DueDeliveries(ctx, time.Now(), nil, 10)
+		if assert.Len(t, due, 1) {
+			assert.Equal(t, deliveryDelivered, d.attempt(ctx, due[0]).Status)
+		}
+		assert.Len(t, rec.received(), 2)
+		assert.Empty(t, rec.errs)
+	})
+
+	t.Run("it answers 404 for unknown deliveries", func(t *testing.T) {
+		rr := serveWebhooks(webhooksRouter(), "GET", "/webhooks/deliveries/"+bson.NewObjectId().Hex(), "")
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
+
+func TestWebhookPublishIsIdempotent(t *testing.T) {
+	useMemoryWebhookStore(t)
+	ctx := context.Background()
+	sub := Subscription{ID: bson.NewObjectId(), URL: "https://hooks.example.com", Active: true}
+	webhookStore.InsertSubscription(ctx, &sub)
+	entry := OutboxEntry{ID: bson.NewObjectId(), Type: EventEmployeeCreated, Employee: Employee{Firstname: "ravi"}, Time: time.Now()}
+
+	for _, d := range []*webhookDispatcher{newWebhookDispatcher(WebhooksConfig{}), newWebhookDispatcher(WebhooksConfig{})} {
+		assert.NoError(t, d.Publish(ctx, entry.event()))
+	}
+	deliveries, _ := webhookStore.FindDeliveries(ctx, DeliveryQuery{})
+	if !assert.Len(t, deliveries, 1, "publishing again, here or elsewhere, adds nothing") {
+		return
+	}
+	delivery := deliveries[0]
+	assert.Equal(t, deliveryID(entry.ID.Hex(), entry.Time, sub.ID), delivery.ID)
+	var payload webhookPayload
+	json.Unmarshal(delivery.Payload, &payload)
+	assert.Equal(t, entry.ID.Hex(), payload.ID)
+
+	now := time.Now().UTC()
+	claimed, err := webhookStore.ClaimDelivery(ctx, delivery.ID, now, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute), claimed.NextAttempt)
+	_, err = webhookStore.ClaimDelivery(ctx, delivery.ID, now, now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrNotFound, "a claimed delivery is not handed out again")
+	_, err = webhookStore.ClaimDelivery(ctx, delivery.ID, now.Add(time.Minute), now.Add(2*time.Minute))
+	assert.NoError(t, err, "the claim lapses")
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	mem := useMemoryStore(t)
+	useMemoryWebhookStore(t)
+	rec := &webhookReceiver{secret: "s3cret"}
+	rec.status.Store(http.StatusOK)
+	server := httptest.NewServer(rec)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	webhookStore.InsertSubscription(ctx, &Subscription{ID: bson.NewObjectId(), URL: server.URL, Secret: rec.secret, Active: true})
+
+	webhooks = newWebhookDispatcher(WebhooksConfig{MaxAttempts: 2, Timeout: time.Second, Workers: 2, AllowPrivateNetworks: true})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		webhooks.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+		webhooks = nil
+	}()
+	assert.NoError(t, store.Insert(ctx, &Employee{Firstname: "asha", EmpID: 9}))
+	assert.Eventually(t, func() bool { return len(rec.received()) > 0 }, 5*time.Second, 20*time.Millisecond)
+	got := rec.received()
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, EventEmployeeCreated, got[0].Type)
+		assert.Equal(t, "asha", got[0].Data.Firstname)
+		assert.Contains(t, mem.employees, got[0].Data)
+	}
+
+	t.Run("a slow subscriber does not hold up the others", func(t *testing.T) {
+		slow := make(chan struct{})
+		stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-slow }))
+		defer stalled.Close()
+		defer close(slow)
+		webhookStore.InsertSubscription(ctx, &Subscription{ID: bson.NewObjectId(), URL: stalled.URL, Active: true})
+
+		assert.NoError(t, store.Insert(ctx, &Employee{Firstname: "ravi", EmpID: 10}))
+		assert.Eventually(t, func() bool { return len(rec.received()) == 2 }, 5*time.Second, 20*time.Millisecond)
+	})
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.1.2.3:80", "192.168.0.10:80", "169.254.169.254:80", "[fe80::1]:80", "0.0.0.0:80", "100.64.0.1:80", "[fd00::1]:80", "[::ffff:127.0.0.1]:80"} {
+		assert.ErrorIs(t, publicOnly("tcp", address, nil), errWebhookAddress, address)
+	}
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1::]:443"} {
+		assert.NoError(t, publicOnly("tcp", address, nil), address)
+	}
+
+	rec := &webhookReceiver{secret: "s3cret"}
+	rec.status.Store(http.StatusOK)
+	server := httptest.NewServer(rec)
+	defer server.Close()
+	d := newWebhookDispatcher(WebhooksConfig{MaxAttempts: 1, Timeout: time.Second, Workers: 1})
+	_, err := d.post(context.Background(), Subscription{URL: server.URL, Secret: rec.secret}, Delivery{ID: bson.NewObjectId(), Payload: []byte(`{}`)})
+	assert.ErrorIs(t, err, errWebhookAddress, "loopback subscribers are refused")
+	assert.Empty(t, rec.received())
+}
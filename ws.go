@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// wsWriteWait is how long a write to a WebSocket client may take before the
+// connection is given up.
+const wsWriteWait = 10 * time.Second
+
+// WebSocketConfig limits the /ws subscription API. Tokens are
+// "subject:secret" pairs accepted as bearer tokens by clients without a
+// client certificate.
+type WebSocketConfig struct {
+	Tokens           []string      `yaml:"tokens" toml:"tokens"`
+	MaxSubscriptions int           `yaml:"max_subscriptions" toml:"max_subscriptions"`
+	MaxEmployees     int           `yaml:"max_employees" toml:"max_employees"`
+	SendBuffer       int           `yaml:"send_buffer" toml:"send_buffer"`
+	MaxMessageBytes  int           `yaml:"max_message_bytes" toml:"max_message_bytes"`
+	PingInterval     time.Duration `yaml:"ping_interval" toml:"ping_interval"`
+	MessageRate      RateLimit     `yaml:"message_rate" toml:"message_rate"`
+}
+
+func (c WebSocketConfig) validate() error {
+	var errs []error
+	for _, token := range c.Tokens {
+		if subject, secret, _ := strings.Cut(token, ":"); subject == "" || secret == "" {
+			errs = append(errs, errors.New("websocket.tokens: entries must be subject:secret"))
+			break
+		}
+	}
+	if c.MaxSubscriptions < 1 || c.MaxEmployees < 1 || c.SendBuffer < 1 || c.MaxMessageBytes < 1 {
+		errs = append(errs, errors.New("websocket: max_subscriptions, max_employees, send_buffer and max_message_bytes must be positive"))
+	}
+	if c.PingInterval <= 0 {
+		errs = append(errs, errors.New("websocket.ping_interval: must be positive"))
+	}
+	if c.MessageRate.Rate <= 0 || c.MessageRate.Burst < 1 {
+		errs = append(errs, errors.New("websocket.message_rate: rate and burst must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// wsConfig and wsAllowedOrigins are installed by main before DefineRoute.
+var (
+	wsConfig         = defaultConfig().WebSocket
+	wsAllowedOrigins = defaultConfig().CORS.AllowedOrigins
+)
+
+// wsRequest is a message from the client. ID names the subscription to
+// subscribe or unsubscribe, and is echoed back in replies to other messages.
+type wsRequest struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id,omitempty"`
+	IDs        []string `json:"ids,omitempty"`
+	Query      *wsQuery `json:"query,omitempty"`
+	EmployeeID string   `json:"employee_id,omitempty"`
+	err        error
+}
+
+// wsQuery selects the employees of a query subscription.
+type wsQuery struct {
+	Practice string `json:"practice,omitempty"`
+	Lastname string `json:"lastname,omitempty"`
+	EmpID    int    `json:"empid,omitempty"`
+}
+
+// wsMessage is a message to the client. Empty fields are left out, so a
+// presence message without editors means nobody is editing the record.
+type wsMessage struct {
+	Type       string                     `json:"type"`
+	ID         string                     `json:"id,omitempty"`
+	Subject    string                     `json:"subject,omitempty"`
+	Op         string                     `json:"op,omitempty"`
+	EmployeeID string                     `json:"employee_id,omitempty"`
+	Employee   *Employee                  `json:"employee,omitempty"`
+	Employees  []Employee                 `json:"employees,omitempty"`
+	Changes    map[string]json.RawMessage `json:"changes,omitempty"`
+	Editors    []string                   `json:"editors,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+// employeeJSON returns the JSON fields of employee.
+func employeeJSON(employee Employee) map[string]json.RawMessage {
+	data, _ := json.Marshal(employee)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// employeeChanges returns the JSON fields of after that differ from before,
+// with null for the fields after no longer has.
+func employeeChanges(before, after Employee) map[string]json.RawMessage {
+	old, changes := employeeJSON(before), make(map[string]json.RawMessage)
+	for name, value := range employeeJSON(after) {
+		if !bytes.Equal(old[name], value) {
+			changes[name] = value
+		}
+		delete(old, name)
+	}
+	for name := range old {
+		changes[name] = json.RawMessage("null")
+	}
+	return changes
+}
+
+// wsSubscription follows a fixed set of ids, or every employee matching a
+// query, and remembers the employees as last sent to diff changes against.
+type wsSubscription struct {
+	ids   map[bson.ObjectId]bool // nil for a query subscription
+	query EmployeeQuery
+	sent  map[bson.ObjectId]Employee
+}
+
+func (s *wsSubscription) wants(employee Employee) bool {
+	if s.ids != nil {
+		return s.ids[employee.ID]
+	}
+	return s.query.matches(employee)
+}
+
+// tracks reports whether the subscription covers the employee with id.
+func (s *wsSubscription) tracks(id bson.ObjectId) bool {
+	_, sent := s.sent[id]
+	return sent || s.ids[id]
+}
+
+// apply brings the subscription up to date with event and returns the
+// change message to send, if there is one. Employees entering a query
+// subscription are added whole, employees still in it get the fields that
+// changed and employees leaving it are removed.
+func (s *wsSubscription) apply(event EmployeeEvent) (wsMessage, bool) {
+	employee := event.Employee
+	before, sent := s.sent[employee.ID]
+	wanted := event.Type != EventEmployeeDeleted && s.wants(employee)
+	message := wsMessage{Type: "change", EmployeeID: employee.ID.Hex()}
+	switch {
+	case wanted && !sent:
+		message.Op, message.Employee = "add", &employee
+	case wanted:
+		message.Op, message.Changes = "update", employeeChanges(before, employee)
+		if len(message.Changes) == 0 {
+			return message, false
+		}
+	case sent:
+		delete(s.sent, employee.ID)
+		message.Op = "remove"
+		return message, true
+	default:
+		return message, false
+	}
+	s.sent[employee.ID] = employee
+	return message, true
+}
+
+// presenceHub tracks who has joined each employee record and tells every
+// connection when that changes; connections pass on what concerns them.
+type presenceHub struct {
+	mu      sync.Mutex
+	conns   map[*wsConn]struct{}
+	editors map[string]map[*wsConn]string
+}
+
+var presence = &presenceHub{
+	conns:   make(map[*wsConn]struct{}),
+	editors: make(map[string]map[*wsConn]string),
+}
+
+func (h *presenceHub) add(c *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[c] = struct{}{}
+}
+
+// remove makes c leave every record it joined.
+func (h *presenceHub) remove(c *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, c)
+	for id, editors := range h.editors {
+		if _, ok := editors[c]; ok {
+			h.leaveLocked(c, id)
+		}
+	}
+}
+
+func (h *presenceHub) join(c *wsConn, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.editors[id] == nil {
+		h.editors[id] = make(map[*wsConn]string)
+	}
+	h.editors[id][c] = c.subject
+	h.broadcastLocked(id)
+}
+
+func (h *presenceHub) leave(c *wsConn, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leaveLocked(c, id)
+}
+
+func (h *presenceHub) leaveLocked(c *wsConn, id string) {
+	delete(h.editors[id], c)
+	if len(h.editors[id]) == 0 {
+		delete(h.editors, id)
+	}
+	h.broadcastLocked(id)
+}
+
+// broadcastLocked sends the editors of id to every connection without
+// blocking; h.mu must be held. A connection that cannot keep up is closed.
+func (h *presenceHub) broadcastLocked(id string) {
+	var editors []string
+	for _, subject := range h.editors[id] {
+		editors = append(editors, subject)
+	}
+	slices.Sort(editors)
+	message := wsMessage{Type: "presence", EmployeeID: id, Editors: slices.Compact(editors)}
+	for c := range h.conns {
+		select {
+		case c.presence <- message:
+		default:
+			c.overload()
+		}
+	}
+}
+
+// wsConn is one WebSocket client. Its subscriptions and joined records are
+// only touched by the serve loop; the reader and writer goroutines talk to it
+// through channels.
+type wsConn struct {
+	ws       *websocket.Conn
+	subject  string
+	send     chan wsMessage
+	presence chan wsMessage
+	limiter  *memoryRateLimiter
+	subs     map[string]*wsSubscription
+	joined   map[string]bool
+
+	overloaded chan struct{}
+	overload   func()
+	closing    chan struct{}
+	closeCode  int
+	closeText  string
+}
+
+func newWSConn(ws *websocket.Conn, subject string) *wsConn {
+	c := &wsConn{
+		ws:         ws,
+		subject:    subject,
+		send:       make(chan wsMessage, wsConfig.SendBuffer),
+		presence:   make(chan wsMessage, wsConfig.SendBuffer),
+		limiter:    newMemoryRateLimiter(),
+		subs:       make(map[string]*wsSubscription),
+		joined:     make(map[string]bool),
+		overloaded: make(chan struct{}),
+		closing:    make(chan struct{}),
+	}
+	c.overload = sync.OnceFunc(func() { close(c.overloaded) })
+	return c
+}
+
+// enqueue queues message for the writer, closing a connection that has
+// fallen a whole buffer behind rather than blocking on it.
+func (c *wsConn) enqueue(message wsMessage) {
+	select {
+	case c.send <- message:
+	default:
+		c.overload()
+	}
+}
+
+func (c *wsConn) fail(id string, format string, args ...any) {
+	c.enqueue(wsMessage{Type: "error", ID: id, Error: fmt.Sprintf(format, args...)})
+}
+
+// serve runs the connection until the client goes away, falls behind or the
+// server shuts down.
+func (c *wsConn) serve(ctx context.Context) {
+	wsConnections.Inc()
+	defer wsConnections.Dec()
+	ch, unsubscribe := events.Subscribe(wsConfig.SendBuffer)
+	defer unsubscribe()
+	presence.add(c)
+	defer presence.remove(c)
+
+	requests := make(chan wsRequest)
+	readDone := make(chan struct{})
+	go c.read(requests, readDone)
+	writeDone := make(chan struct{})
+	go c.write(writeDone)
+
+	c.enqueue(wsMessage{Type: "welcome", Subject: c.subject})
+	c.closeCode, c.closeText = c.loop(ctx, ch, requests, readDone)
+	close(c.closing)
+	<-writeDone
+	<-readDone
+}
+
+// loop handles requests, events and presence changes and returns the close
+// code to send, or 0 when the client is already gone.
+func (c *wsConn) loop(ctx context.Context, ch <-chan EmployeeEvent, requests <-chan wsRequest, readDone <-chan struct{}) (int, string) {
+	for {
+		select {
+		case <-streamsDone:
+			return websocket.CloseGoingAway, "server shutting down"
+		case <-c.overloaded:
+			return websocket.CloseTryAgainLater, "client too slow"
+		case <-readDone:
+			return 0, ""
+		case event, ok := <-ch:
+			if !ok {
+				return websocket.CloseTryAgainLater, "client too slow"
+			}
+			for id, sub := range c.subs {
+				if message, ok := sub.apply(event); ok {
+					message.ID = id
+					c.enqueue(message)
+				}
+			}
+		case message := <-c.presence:
+			if c.interested(message.EmployeeID) {
+				c.enqueue(message)
+			}
+		case request := <-requests:
+			c.handle(ctx, request)
+		}
+	}
+}
+
+// interested reports whether the client joined or subscribes to the record
+// with id.
+func (c *wsConn) interested(id string) bool {
+	if c.joined[id] {
+		return true
+	}
+	oid, err := objectID(id)
+	if err != nil {
+		return false
+	}
+	for _, sub := range c.subs {
+		if sub.tracks(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *wsConn) handle(ctx context.Context, request wsRequest) {
+	if request.err != nil {
+		c.fail("", "invalid message: %v", request.err)
+		return
+	}
+	if result, _ := c.limiter.Allow("", wsConfig.MessageRate); !result.Allowed {
+		c.fail(request.ID, "%v", errRateLimited)
+		return
+	}
+	switch request.Type {
+	case "subscribe":
+		c.subscribe(ctx, request)
+	case "unsubscribe":
+		if _, ok := c.subs[request.ID]; !ok {
+			c.fail(request.ID, "no subscription %q", request.ID)
+			return
+		}
+		delete(c.subs, request.ID)
+		c.enqueue(wsMessage{Type: "unsubscribed", ID: request.ID})
+	case "join":
+		if _, err := objectID(request.EmployeeID); err != nil {
+			c.fail(request.ID, "invalid employee_id")
+			return
+		}
+		if !c.joined[request.EmployeeID] && len(c.joined) >= wsConfig.MaxSubscriptions {
+			c.fail(request.ID, "cannot join more than %d records", wsConfig.MaxSubscriptions)
+			return
+		}
+		c.joined[request.EmployeeID] = true
+		presence.join(c, request.EmployeeID)
+	case "leave":
+		if c.joined[request.EmployeeID] {
+			delete(c.joined, request.EmployeeID)
+			presence.leave(c, request.EmployeeID)
+		}
+	case "ping":
+		c.enqueue(wsMessage{Type: "pong", ID: request.ID})
+	default:
+		c.fail(request.ID, "unknown message type %q", request.Type)
+	}
+}
+
+// subscribe starts a subscription and sends the employees it covers. Events
+// published while the snapshot is read wait in the bus channel and are
+// applied to it afterwards.
+func (c *wsConn) subscribe(ctx context.Context, request wsRequest) {
+	switch {
+	case request.ID == "":
+		c.fail("", "subscribe needs an id")
+		return
+	case c.subs[request.ID] != nil:
+		c.fail(request.ID, "subscription %q exists", request.ID)
+		return
+	case len(c.subs) >= wsConfig.MaxSubscriptions:
+		c.fail(request.ID, "cannot have more than %d subscriptions", wsConfig.MaxSubscriptions)
+		return
+	case (len(request.IDs) == 0) == (request.Query == nil):
+		c.fail(request.ID, "subscribe needs either ids or a query")
+		return
+	case len(request.IDs) > wsConfig.MaxEmployees:
+		c.fail(request.ID, "cannot subscribe to more than %d ids", wsConfig.MaxEmployees)
+		return
+	}
+
+	sub := &wsSubscription{sent: make(map[bson.ObjectId]Employee)}
+	var snapshot []Employee
+	if request.Query != nil {
+		sub.query = EmployeeQuery{Practice: request.Query.Practice, Lastname: request.Query.Lastname, EmpID: request.Query.EmpID}
+		query := sub.query
+		query.Limit = wsConfig.MaxEmployees + 1
+		found, err := store.Find(ctx, query)
+		if err != nil {
+			c.fail(request.ID, "%v", err)
+			return
+		}
+		if len(found) > wsConfig.MaxEmployees {
+			c.fail(request.ID, "query matches more than %d employees", wsConfig.MaxEmployees)
+			return
+		}
+		snapshot = found
+	} else {
+		sub.ids = make(map[bson.ObjectId]bool, len(request.IDs))
+		for _, id := range request.IDs {
+			oid, err := objectID(id)
+			if err != nil {
+				c.fail(request.ID, "invalid id %q", id)
+				return
+			}
+			sub.ids[oid] = true
+			employee, err := store.FindByID(ctx, id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				c.fail(request.ID, "%v", err)
+				return
+			}
+			snapshot = append(snapshot, employee)
+		}
+	}
+	for _, employee := range snapshot {
+		sub.sent[employee.ID] = employee
+	}
+	c.subs[request.ID] = sub
+	c.enqueue(wsMessage{Type: "subscribed", ID: request.ID, Employees: snapshot})
+}
+
+// read decodes client messages until the connection fails. A client that
+// stops answering pings is timed out.
+func (c *wsConn) read(requests chan<- wsRequest, done chan<- struct{}) {
+	defer close(done)
+	c.ws.SetReadLimit(int64(wsConfig.MaxMessageBytes))
+	c.ws.SetReadDeadline(time.Now().Add(2 * wsConfig.PingInterval))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(2 * wsConfig.PingInterval))
+	})
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var request wsRequest
+		request.err = json.Unmarshal(data, &request)
+		select {
+		case requests <- request:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// write sends queued messages and pings, and the close message once serve
+// is done. Closing the connection also ends read.
+func (c *wsConn) write(done chan<- struct{}) {
+	defer close(done)
+	defer c.ws.Close()
+	ping := time.NewTicker(wsConfig.PingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-c.closing:
+			if c.closeCode != 0 {
+				c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText), time.Now().Add(wsWriteWait))
+			}
+			return
+		case message := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = c.ws.WriteJSON(message)
+		case <-ping.C:
+			err = c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		}
+		if err != nil {
+			// Closing the connection ends read, and with it serve.
+			c.ws.Close()
+			<-c.closing
+			return
+		}
+	}
+}
+
+// wsSubject authenticates an upgrade request by its client certificate or
+// by a bearer token listed in wsConfig.Tokens. Browsers cannot set headers
+// on a WebSocket, so the token may also come as the access_token parameter.
+func wsSubject(request *http.Request) (string, bool) {
+	if identity, ok := identityFrom(request.Context()); ok {
+		return identity.Subject, true
+	}
+	token := request.URL.Query().Get("access_token")
+	if auth, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer "); ok {
+		token = auth
+	}
+	if token == "" {
+		return "", false
+	}
+	for _, entry := range wsConfig.Tokens {
+		subject, secret, _ := strings.Cut(entry, ":")
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1 {
+			return subject, true
+		}
+	}
+	return "", false
+}
+
+// wsCheckOrigin accepts clients that send no Origin, which are not
+// browsers, and pages from the allowed CORS origins.
+func wsCheckOrigin(request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range wsAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+// WebSocketEndpoint upgrades to a WebSocket carrying live employee updates.
+func WebSocketEndpoint(response http.ResponseWriter, request *http.Request) {
+
+	// swagger:operation GET /ws WebSocketEndpoint
+	//
+	// Opens a WebSocket for live employee updates and presence.
+	// Callers authenticate with a client certificate or a configured bearer
+	// token. Messages are JSON objects with a type. Clients send subscribe
+	// (id plus ids or a query of practice, lastname and empid), unsubscribe,
+	// join and leave (employee_id) and ping. The server answers with welcome,
+	// subscribed (a snapshot of employees), unsubscribed, change (op add,
+	// update with the changed fields, or remove), presence (the editors of a
+	// record), error and pong. Clients that fall behind are closed with code
+	// 1013 and should reconnect and subscribe again.
+	// ---
+	// parameters:
+	// - name: access_token
+	//   in: query
+	//   description: bearer token, for clients that cannot set the Authorization header
+	//   type: string
+	// responses:
+	//   '101':
+	//     description: switching to the WebSocket protocol
+	//   '401':
+	//     description: no valid client certificate or token
+	//   default:
+	//     description: unexpected error
+
+	subject, ok := wsSubject(request)
+	if !ok {
+		setResponseHeader(response)
+		response.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(response, request, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: wsCheckOrigin}
+	ws, err := upgrader.Upgrade(response, request, nil)
+	if err != nil {
+		// The upgrader has answered with an error.
+		return
+	}
+	newWSConn(ws, subject).serve(request.Context())
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// useWSConfig installs a WebSocket configuration accepting the tokens
+// "alice-token" and "bob-token" for the duration of the test.
+func useWSConfig(t *testing.T) *WebSocketConfig {
+	saved := wsConfig
+	wsConfig = defaultConfig().WebSocket
+	wsConfig.Tokens = []string{"alice:alice-token", "bob:bob-token"}
+	t.Cleanup(func() { wsConfig = saved })
+	return &wsConfig
+}
+
+func wsServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(accessLogMiddleware(metricsMiddleware(http.HandlerFunc(WebSocketEndpoint))))
+	t.Cleanup(server.Close)
+	// Hijacked connections outlive server.Close, so wait for them to end
+	// before the globals they use are restored.
+	t.Cleanup(func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for testutil.ToFloat64(wsConnections) > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	return server
+}
+
+// dialWS connects with token and reads the welcome message.
+func dialWS(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	t.Cleanup(func() { ws.Close() })
+	assert.Equal(t, wsMessage{Type: "welcome", Subject: strings.TrimSuffix(token, "-token")}, readWS(t, ws))
+	return ws
+}
+
+func readWS(t *testing.T, ws *websocket.Conn) wsMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message wsMessage
+	if err := ws.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func sendWS(t *testing.T, ws *websocket.Conn, request wsRequest) {
+	t.Helper()
+	if err := ws.WriteJSON(request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebSocketAuth(t *testing.T) {
+	useMemoryStore(t)
+	useWSConfig(t)
+	server := wsServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for name, header := range map[string]http.Header{
+		"no token":    nil,
+		"wrong token": {"Authorization": {"Bearer nope"}},
+	} {
+		t.Run("it rejects "+name, func(t *testing.T) {
+			_, resp, err := websocket.DefaultDialer.Dial(url, header)
+			assert.Error(t, err)
+			if status := resp.StatusCode; status != http.StatusUnauthorized {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+			}
+		})
+	}
+
+	t.Run("it accepts the token as a parameter", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.Dial(url+"?access_token=bob-token", nil)
+		if assert.NoError(t, err) {
+			defer ws.Close()
+			assert.Equal(t, "bob", readWS(t, ws).Subject)
+		}
+	})
+
+	t.Run("it rejects foreign origins", func(t *testing.T) {
+		saved := wsAllowedOrigins
+		wsAllowedOrigins = []string{"https://hr.example.com"}
+		defer func() { wsAllowedOrigins = saved }()
+		header := http.Header{"Authorization": {"Bearer bob-token"}, "Origin": {"https://evil.example.com"}}
+		_, resp, err := websocket.DefaultDialer.Dial(url, header)
+		assert.Error(t, err)
+		if status := resp.StatusCode; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+		}
+	})
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	useMemoryStore(t)
+	useWSConfig(t)
+	server := wsServer(t)
+	ctx := context.Background()
+
+	ravi := Employee{Firstname: "ravi", Practice: "SAP"}
+	store.Insert(ctx, &ravi)
+	ws := dialWS(t, server, "alice-token")
+
+	t.Run("it sends a snapshot", func(t *testing.T) {
+		sendWS(t, ws, wsRequest{Type: "subscribe", ID: "sap", Query: &wsQuery{Practice: "SAP"}})
+		assert.Equal(t, wsMessage{Type: "subscribed", ID: "sap", Employees: []Employee{ravi}}, readWS(t, ws))
+	})
+
+	aditi := Employee{Firstname: "aditi", Practice: "SAP"}
+	t.Run("it adds employees entering the query", func(t *testing.T) {
+		store.Insert(ctx, &aditi)
+		assert.Equal(t, wsMessage{Type: "change", ID: "sap", Op: "add", EmployeeID: aditi.ID.Hex(), Employee: &aditi}, readWS(t, ws))
+	})
+
+	t.Run("it sends the fields that changed", func(t *testing.T) {
+		store.Update(ctx, aditi.ID.Hex(), Employee{Lastname: "rao", Salary: 10})
+		message := readWS(t, ws)
+		assert.Equal(t, "update", message.Op)
+		assert.Equal(t, aditi.ID.Hex(), message.EmployeeID)
+		assert.Equal(t, map[string]string{"lastname": `"rao"`, "salary": "10"}, rawStrings(message.Changes))
+	})
+
+	t.Run("it removes employees leaving the query", func(t *testing.T) {
+		store.Update(ctx, ravi.ID.Hex(), Employee{Practice: "IBM"})
+		assert.Equal(t, wsMessage{Type: "change", ID: "sap", Op: "remove", EmployeeID: ravi.ID.Hex()}, readWS(t, ws))
+	})
+
+	t.Run("it follows ids", func(t *testing.T) {
+		sendWS(t, ws, wsRequest{Type: "subscribe", ID: "ravi", IDs: []string{ravi.ID.Hex()}})
+		message := readWS(t, ws)
+		assert.Equal(t, "subscribed", message.Type)
+		assert.Len(t, message.Employees, 1)
+		store.Remove(ctx, ravi.ID.Hex())
+		assert.Equal(t, wsMessage{Type: "change", ID: "ravi", Op: "remove", EmployeeID: ravi.ID.Hex()}, readWS(t, ws))
+	})
+
+	t.Run("it unsubscribes", func(t *testing.T) {
+		sendWS(t, ws, wsRequest{Type: "unsubscribe", ID: "sap"})
+		assert.Equal(t, wsMessage{Type: "unsubscribed", ID: "sap"}, readWS(t, ws))
+		store.Update(ctx, aditi.ID.Hex(), Employee{Salary: 20})
+		sendWS(t, ws, wsRequest{Type: "ping", ID: "p1"})
+		assert.Equal(t, wsMessage{Type: "pong", ID: "p1"}, readWS(t, ws))
+	})
+}
+
+func rawStrings(changes map[string]json.RawMessage) map[string]string {
+	out := make(map[string]string, len(changes))
+	for name, value := range changes {
+		out[name] = string(value)
+	}
+	return out
+}
+
+func TestWebSocketPresence(t *testing.T) {
+	useMemoryStore(t)
+	useWSConfig(t)
+	server := wsServer(t)
+	aditi := Employee{Firstname: "aditi"}
+	store.Insert(context.Background(), &aditi)
+	id := aditi.ID.Hex()
+
+	alice := dialWS(t, server, "alice-token")
+	bob := dialWS(t, server, "bob-token")
+	sendWS(t, bob, wsRequest{Type: "subscribe", ID: "aditi", IDs: []string{id}})
+	readWS(t, bob)
+
+	t.Run("subscribers see who joins", func(t *testing.T) {
+		sendWS(t, alice, wsRequest{Type: "join", EmployeeID: id})
+		want := wsMessage{Type: "presence", EmployeeID: id, Editors: []string{"alice"}}
+		assert.Equal(t, want, readWS(t, alice))
+		assert.Equal(t, want, readWS(t, bob))
+	})
+
+	t.Run("editors leave when they disconnect", func(t *testing.T) {
+		alice.Close()
+		assert.Equal(t, wsMessage{Type: "presence", EmployeeID: id}, readWS(t, bob))
+	})
+}
+
+func TestWebSocketLimits(t *testing.T) {
+	useMemoryStore(t)
+	config := useWSConfig(t)
+	config.MaxSubscriptions = 1
+	config.MaxMessageBytes = 256
+	config.MessageRate = RateLimit{Rate: 0.001, Burst: 2}
+	server := wsServer(t)
+	ws := dialWS(t, server, "alice-token")
+
+	t.Run("it reports invalid messages", func(t *testing.T) {
+		ws.WriteMessage(websocket.TextMessage, []byte("{"))
+		assert.Equal(t, "error", readWS(t, ws).Type)
+	})
+
+	t.Run("it limits subscriptions", func(t *testing.T) {
+		sendWS(t, ws, wsRequest{Type: "subscribe", ID: "a", Query: &wsQuery{}})
+		assert.Equal(t, "subscribed", readWS(t, ws).Type)
+		sendWS(t, ws, wsRequest{Type: "subscribe", ID: "b", Query: &wsQuery{}})
+		assert.Equal(t, wsMessage{Type: "error", ID: "b", Error: "cannot have more than 1 subscriptions"}, readWS(t, ws))
+	})
+
+	t.Run("it limits the message rate", func(t *testing.T) {
+		sendWS(t, ws, wsRequest{Type: "ping", ID: "p"})
+		assert.Equal(t, wsMessage{Type: "error", ID: "p", Error: errRateLimited.Error()}, readWS(t, ws))
+	})
+
+	t.Run("it closes on oversized messages", func(t *testing.T) {
+		ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping","id":"`+strings.Repeat("x", 300)+`"}`))
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err := ws.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), err)
+	})
+}
+
+func TestWebSocketBackpressure(t *testing.T) {
+	config := useWSConfig(t)
+	config.SendBuffer = 1
+	c := newWSConn(nil, "alice")
+	c.enqueue(wsMessage{Type: "pong"})
+	select {
+	case <-c.overloaded:
+		t.Fatal("overloaded with room in the buffer")
+	default:
+	}
+	c.enqueue(wsMessage{Type: "pong"})
+	select {
+	case <-c.overloaded:
+	default:
+		t.Fatal("not overloaded with a full buffer")
+	}
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	saved := streamsDone
+	streamsDone = make(chan struct{})
+	t.Cleanup(func() { streamsDone = saved })
+	useMemoryStore(t)
+	useWSConfig(t)
+	server := wsServer(t)
+	ws := dialWS(t, server, "alice-token")
+
+	close(streamsDone)
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := ws.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+}